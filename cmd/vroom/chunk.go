@@ -5,29 +5,28 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/segmentio/kafka-go"
-	"gocloud.dev/gcerrors"
 	"google.golang.org/api/googleapi"
 
 	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/httputil"
+	"github.com/getsentry/vroom/internal/jsonutil"
 	"github.com/getsentry/vroom/internal/metrics"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/occurrence"
 	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/schema"
 	"github.com/getsentry/vroom/internal/storageutil"
 )
 
-type (
-	chunkPlatform struct {
-		Platform platform.Platform `json:"platform"`
-	}
-)
-
 const (
 	// when computing slowest functions, ignore frames/node whose depth in the callTree
 	// is less than 1 (i.e. root frames).
@@ -40,110 +39,89 @@ func (env *environment) postChunk(w http.ResponseWriter, r *http.Request) {
 
 	s := sentry.StartSpan(ctx, "processing")
 	s.Description = "Read HTTP body"
-	body, err := io.ReadAll(r.Body)
+	body, err := httputil.ReadBody(r)
 	s.Finish()
 	if err != nil {
 		if hub != nil {
 			hub.CaptureException(err)
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
 		return
 	}
 	r.Body.Close()
 
-	var p chunkPlatform
-	err = json.Unmarshal(body, &p)
+	err = env.ingestChunk(ctx, body, ingestOptions{})
 	if err != nil {
-		if hub != nil {
-			hub.CaptureException(err)
-		}
-		w.WriteHeader(http.StatusBadRequest)
+		env.storeDeadLetter(ctx, deadLetterChunk, body, err)
+		writeIngestError(w, err)
 		return
 	}
 
-	var c chunk.Chunk
-	switch p.Platform {
-	case platform.Android:
-		c = chunk.New(new(chunk.AndroidChunk))
-	default:
-		c = chunk.New(new(chunk.SampleChunk))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestChunk stores a chunk and sends the messages derived from it
+// downstream.
+func (env *environment) ingestChunk(ctx context.Context, body []byte, opts ingestOptions) (err error) {
+	ctx, hub := ingestHub(ctx)
+
+	var payloadPlatform string
+	defer func() {
+		observeIngestion(ingestKindChunk, payloadPlatform, len(body), err)
+	}()
+
+	// The version tells us how to decode the chunk, we read it without
+	// decoding the whole payload so it's only decoded once.
+	fields, err := jsonutil.Peek(body, "platform", "version")
+	if err != nil {
+		hub.CaptureException(err)
+		return newIngestError(ingestErrorInvalid, ingestStageUnmarshal, err)
 	}
+	// Errors decoding the payload are tagged with its platform too.
+	payloadPlatform = fields["platform"]
+	hub.Scope().SetTag("platform", payloadPlatform)
 
-	s = sentry.StartSpan(ctx, "json.unmarshal")
+	var c chunk.Chunk
+	s := sentry.StartSpan(ctx, "json.unmarshal")
 	s.Description = "Unmarshal profile"
-	err = json.Unmarshal(body, &c)
+	err = c.UnmarshalVersion(body, fields["version"])
 	s.Finish()
 	if err != nil {
-		if hub != nil {
-			hub.CaptureException(err)
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		hub.CaptureException(err)
+		return newIngestError(ingestErrorInvalid, ingestStageUnmarshal, err)
 	}
 
 	c.Normalize()
 
-	if hub != nil {
-		hub.Scope().SetContext("Profile metadata", map[string]interface{}{
-			"chunk_id":        c.GetID(),
-			"organization_id": strconv.FormatUint(c.GetOrganizationID(), 10),
-			"profiler_id":     c.GetProfilerID(),
-			"project_id":      strconv.FormatUint(c.GetProjectID(), 10),
-			"size":            len(body),
-		})
-
-		hub.Scope().SetTags(map[string]string{
-			"platform": string(c.GetPlatform()),
-		})
-	}
+	hub.Scope().SetContext("Profile metadata", map[string]interface{}{
+		"chunk_id":        c.GetID(),
+		"organization_id": strconv.FormatUint(c.GetOrganizationID(), 10),
+		"profiler_id":     c.GetProfilerID(),
+		"project_id":      strconv.FormatUint(c.GetProjectID(), 10),
+		"size":            len(body),
+	})
 
-	s = sentry.StartSpan(ctx, "gcs.write")
-	s.Description = "Write profile to GCS"
-	err = storageutil.CompressedWrite(ctx, env.storage, c.StoragePath(), c)
-	s.Finish()
-	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			// This is a transient error, we'll retry
-			w.WriteHeader(http.StatusTooManyRequests)
-		} else {
-			if code := gcerrors.Code(err); code == gcerrors.FailedPrecondition {
-				w.WriteHeader(http.StatusPreconditionFailed)
-			} else {
-				if hub != nil {
-					hub.CaptureException(err)
-				}
-				// These errors won't be retried
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		}
-		return
-	}
+	hub.Scope().SetTags(map[string]string{
+		"platform": string(c.GetPlatform()),
+	})
 
 	s = sentry.StartSpan(ctx, "json.marshal")
 	s.Description = "Marshal chunk Kafka message"
 	b, err := json.Marshal(buildChunkKafkaMessage(c))
 	s.Finish()
 	if err != nil {
-		if hub != nil {
-			hub.CaptureException(err)
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		hub.CaptureException(err)
+		return newIngestError(ingestErrorInternal, ingestStageMarshal, err)
 	}
-	s = sentry.StartSpan(ctx, "processing")
-	s.Description = "Send chunk to Kafka"
-	err = env.profilingWriter.WriteMessages(ctx, kafka.Message{
-		Topic: env.config.ProfileChunksKafkaTopic,
-		Value: b,
-	})
-	if err != nil {
-		if hub != nil {
-			hub.CaptureException(err)
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// The message is built before we store the chunk so it can be kept in the
+	// outbox until it's delivered.
+	keys := kafkaMessageKeys{
+		ProjectID:  c.GetProjectID(),
+		ProfilerID: c.GetProfilerID(),
+	}
+	messages := []kafka.Message{
+		env.kafkaMessage(env.config.ProfileChunksKafkaTopic, schema.ProfileChunk, b, keys),
 	}
-	s.Finish()
 
 	// nb.: here we don't have a specific thread ID, so we're going to ingest
 	// functions metrics from all the thread.
@@ -155,53 +133,112 @@ func (env *environment) postChunk(w http.ResponseWriter, r *http.Request) {
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return newIngestError(ingestErrorInternal, ingestStageCallTrees, err)
 	}
+
 	s = sentry.StartSpan(ctx, "processing")
 	s.Description = "Extract functions"
-	functions := metrics.ExtractFunctionsFromCallTrees(callTrees, minDepth)
-	functions = metrics.CapAndFilterFunctions(functions, maxUniqueFunctionsPerProfile, true)
+	buckets := env.extractChunkFunctions(c, callTrees)
 	s.Finish()
 
 	// This block writes into the functions dataset
 	s = sentry.StartSpan(ctx, "json.marshal")
-	s.Description = "Marshal functions Kafka message"
-	b, err = json.Marshal(buildChunkFunctionsKafkaMessage(&c, functions))
-	s.Finish()
-	if err != nil {
-		if hub != nil {
+	s.Description = "Marshal functions Kafka messages"
+	var functionsPayloadSize int
+	functionsMessages := make([]kafka.Message, 0, len(buckets))
+	for _, bucket := range buckets {
+		if !env.config.FunctionsThreadMetrics {
+			dropThreadMetrics(bucket.Functions)
+		}
+		if env.config.FunctionsDurationsSketches {
+			addDurationsSketches(bucket.Functions)
+		}
+
+		m := buildChunkFunctionsKafkaMessage(&c, bucket.Functions)
+		if env.config.ChunkFunctionsBucketSize > 0 {
+			m = withFunctionsBucket(m, bucket)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			s.Finish()
 			hub.CaptureException(err)
+			return newIngestError(ingestErrorInternal, ingestStageMarshal, err)
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		functionsPayloadSize += len(b)
+		functionsMessages = append(functionsMessages, env.functionsMessage(b, keys))
 	}
-	s = sentry.StartSpan(ctx, "processing")
-	s.Description = "Send functions to Kafka"
-	err = env.profilingWriter.WriteMessages(ctx, kafka.Message{
-		Topic: env.config.CallTreesKafkaTopic,
-		Value: b,
-	})
 	s.Finish()
-	if hub != nil {
-		hub.Scope().SetContext("Call functions payload", map[string]interface{}{
-			"Size": len(b),
-		})
+	hub.Scope().SetContext("Call functions payload", map[string]interface{}{
+		"Size":    functionsPayloadSize,
+		"Buckets": len(buckets),
+	})
+
+	stored, err := env.store(ctx, hub, c.StoragePath(), c, messages, opts)
+	if err != nil {
+		return err
 	}
+	err = env.publish(ctx, hub, c.StoragePath(), stored, messages)
 	if err != nil {
-		if hub != nil {
+		return err
+	}
+	env.publishFunctions(ctx, hub, functionsMessages)
+
+	s = sentry.StartSpan(ctx, "processing")
+	s.Description = "Find occurrences"
+	occurrences := occurrence.FindInChunk(c, callTrees)
+	occurrences = env.occurrencesRateLimiter.Filter(occurrences)
+	s.Finish()
+	if len(occurrences) > 0 {
+		s = sentry.StartSpan(ctx, "processing")
+		s.Description = "Build Kafka message batch"
+		occurrenceMessages, err := occurrence.GenerateKafkaMessageBatch(occurrences)
+		s.Finish()
+		if err != nil {
+			// Report the error but don't fail chunk insertion
 			hub.CaptureException(err)
+		} else {
+			s = sentry.StartSpan(ctx, "processing")
+			s.Description = "Send occurrences to Kafka"
+			err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
+			s.Finish()
+			switch {
+			case err == nil:
+				env.occurrencesRateLimiter.Emitted(occurrences)
+				countOccurrences(occurrences)
+			default:
+				// Report the error but don't fail chunk insertion
+				hub.CaptureException(err)
+			}
 		}
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	for _, bucket := range buckets {
+		env.regressionDetector.Add(
+			c.GetOrganizationID(),
+			c.GetProjectID(),
+			time.Unix(0, int64(bucket.StartNS)),
+			examples.NewExampleFromProfilerChunk(
+				c.GetProjectID(),
+				c.GetProfilerID(),
+				c.GetID(),
+				"",
+				nil,
+				bucket.StartNS,
+				bucket.EndNS,
+			),
+			bucket.Functions,
+		)
+	}
+
+	return nil
 }
 
 type postProfileFromChunkIDsRequest struct {
-	ProfilerID string   `json:"profiler_id"`
-	ChunkIDs   []string `json:"chunk_ids"`
-	Start      uint64   `json:"start,string"`
-	End        uint64   `json:"end,string"`
+	ProfilerID           string   `json:"profiler_id"`
+	ChunkIDs             []string `json:"chunk_ids"`
+	Start                uint64   `json:"start,string"`
+	End                  uint64   `json:"end,string"`
+	CollapseInlineFrames bool     `json:"collapse_inline_frames"`
 }
 
 // Instead of returning Chunk directly, we'll return this struct
@@ -213,6 +250,35 @@ type postProfileFromChunkIDsResponse struct {
 	DebugChunkIDs []string    `json:"debug_chunk_ids,omitempty"`
 }
 
+// extractChunkFunctions returns the functions of a chunk, split in time
+// buckets if configured, or in a single bucket covering the whole chunk
+// otherwise.
+func (env *environment) extractChunkFunctions(
+	c chunk.Chunk,
+	callTrees map[string][]*nodetree.Node,
+) []metrics.FunctionsBucket {
+	if env.config.ChunkFunctionsBucketSize <= 0 {
+		functions := metrics.ExtractFunctionsFromCallTrees(callTrees, minDepth, c)
+		return []metrics.FunctionsBucket{
+			{
+				StartNS:   uint64(c.StartTimestamp() * 1e9),
+				EndNS:     uint64(c.EndTimestamp() * 1e9),
+				Functions: metrics.CapAndFilterFunctions(functions, maxUniqueFunctionsPerProfile, true),
+			},
+		}
+	}
+	buckets := metrics.ExtractFunctionsFromCallTreesPerBucket(
+		callTrees,
+		minDepth,
+		c,
+		uint64(env.config.ChunkFunctionsBucketSize),
+	)
+	for i := range buckets {
+		buckets[i].Functions = metrics.CapAndFilterFunctions(buckets[i].Functions, maxUniqueFunctionsPerProfile, true)
+	}
+	return buckets
+}
+
 // This is more of a GET method, but since we're receiving a list of chunk IDs as part of a
 // body request, we use a POST method instead (similarly to the flamegraph endpoint).
 func (env *environment) postProfileFromChunkIDs(w http.ResponseWriter, r *http.Request) {
@@ -245,7 +311,7 @@ func (env *environment) postProfileFromChunkIDs(w http.ResponseWriter, r *http.R
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
 		return
 	}
 	r.Body.Close()
@@ -257,17 +323,23 @@ func (env *environment) postProfileFromChunkIDs(w http.ResponseWriter, r *http.R
 	results := make(chan storageutil.ReadJobResult, len(requestBody.ChunkIDs))
 	defer close(results)
 
-	// send a task to the workers pool for each chunk
+	// send a task to the workers pool for each chunk, chunks we couldn't
+	// enqueue before the request was done are reported as failed
+	queue := env.readQueue(storageutil.PriorityInteractive)
 	go func() {
 		for _, ID := range requestBody.ChunkIDs {
-			readJobs <- chunk.ReadJob{
-				Ctx:            ctx,
-				Storage:        env.storage,
-				OrganizationID: organizationID,
-				ProjectID:      projectID,
-				ProfilerID:     requestBody.ProfilerID,
-				ChunkID:        ID,
-				Result:         results,
+			err := queue.Enqueue(ctx, chunk.ReadJob{
+				Ctx:                  ctx,
+				Storage:              env.storage,
+				OrganizationID:       organizationID,
+				ProjectID:            projectID,
+				ProfilerID:           requestBody.ProfilerID,
+				ChunkID:              ID,
+				CollapseInlineFrames: requestBody.CollapseInlineFrames,
+				Result:               results,
+			})
+			if err != nil {
+				results <- chunk.ReadJobResult{Err: err}
 			}
 		}
 	}()
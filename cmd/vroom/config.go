@@ -1,11 +1,24 @@
 package main
 
+import (
+	"time"
+
+	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/platform"
+)
+
 type (
 	ServiceConfig struct {
 		Environment    string `env:"SENTRY_ENVIRONMENT" env-default:"development"`
 		Port           int    `env:"PORT"               env-default:"8085"`
 		WorkerPoolSize int    `env:"WORKER_POOL_SIZE"               env-default:"10"`
 
+		ReadPoolMaxJobsPerRequest int `env:"SENTRY_READ_POOL_MAX_JOBS_PER_REQUEST" env-default:"5"`
+
+		MaxBodySize        int64 `env:"SENTRY_MAX_BODY_SIZE" env-default:"10485760"`
+		ChunkMaxBodySize   int64 `env:"SENTRY_CHUNK_MAX_BODY_SIZE" env-default:"52428800"`
+		ProfileMaxBodySize int64 `env:"SENTRY_PROFILE_MAX_BODY_SIZE" env-default:"52428800"`
+
 		SentryDSN string `env:"SENTRY_DSN"`
 
 		KafkaSaslMechanism string `env:"SENTRY_KAFKA_SASL_MECHANISM"`
@@ -14,16 +27,95 @@ type (
 		KafkaSslCaPath     string `env:"SENTRY_KAFKA_SSL_CA_PATH"`
 		KafkaSslCertPath   string `env:"SENTRY_KAFKA_SSL_CERT_PATH"`
 		KafkaSslKeyPath    string `env:"SENTRY_KAFKA_SSL_KEY_PATH"`
+		KafkaSyncDelivery  bool   `env:"SENTRY_KAFKA_SYNC_DELIVERY" env-default:"false"`
 
 		OccurrencesKafkaBrokers []string `env:"SENTRY_KAFKA_BROKERS_OCCURRENCES" env-default:"localhost:9092"`
 		ProfilingKafkaBrokers   []string `env:"SENTRY_KAFKA_BROKERS_PROFILING" env-default:"localhost:9092"`
-		SpansKafkaBrokers       []string `env:"SENTRY_KAFKA_BROKERS_SPANS" env-default:"localhost:9092"`
+		// SpansKafkaBrokers is deprecated, no writer uses it. Brokers are set
+		// per topic with SENTRY_KAFKA_WRITER_<TOPIC>_BROKERS.
+		SpansKafkaBrokers []string `env:"SENTRY_KAFKA_BROKERS_SPANS"`
 
 		CallTreesKafkaTopic     string `env:"SENTRY_KAFKA_TOPIC_CALL_TREES" env-default:"profiles-call-tree"`
 		OccurrencesKafkaTopic   string `env:"SENTRY_KAFKA_TOPIC_OCCURRENCES" env-default:"ingest-occurrences"`
 		ProfileChunksKafkaTopic string `env:"SENTRY_KAFKA_TOPIC_PROFILE_CHUNKS" env-default:"snuba-profile-chunks"`
 		ProfilesKafkaTopic      string `env:"SENTRY_KAKFA_TOPIC_PROFILES" env-default:"processed-profiles"`
 
-		BucketURL string `env:"SENTRY_BUCKET_PROFILES" env-default:"file://./test/gcs/sentry-profiles"`
+		CallTreesKafkaWriter     KafkaWriterConfig `env-prefix:"SENTRY_KAFKA_WRITER_CALL_TREES_"`
+		OccurrencesKafkaWriter   KafkaWriterConfig `env-prefix:"SENTRY_KAFKA_WRITER_OCCURRENCES_"`
+		ProfileChunksKafkaWriter KafkaWriterConfig `env-prefix:"SENTRY_KAFKA_WRITER_PROFILE_CHUNKS_"`
+		ProfilesKafkaWriter      KafkaWriterConfig `env-prefix:"SENTRY_KAFKA_WRITER_PROFILES_"`
+
+		KafkaMessagesValidationSampleRate float64 `env:"SENTRY_KAFKA_MESSAGES_VALIDATION_SAMPLE_RATE" env-default:"0"`
+
+		MessageSink     string `env:"SENTRY_MESSAGE_SINK" env-default:"kafka"`
+		MessageSinkPath string `env:"SENTRY_MESSAGE_SINK_PATH"`
+
+		// OccurrencesRateLimit is the number of occurrences emitted per
+		// fingerprint and window, 0 disables the limit.
+		OccurrencesRateLimit       int           `env:"SENTRY_OCCURRENCES_RATE_LIMIT" env-default:"0"`
+		OccurrencesRateLimitWindow time.Duration `env:"SENTRY_OCCURRENCES_RATE_LIMIT_WINDOW" env-default:"1m"`
+
+		AppHangThresholdAndroid time.Duration `env:"SENTRY_APP_HANG_THRESHOLD_ANDROID" env-default:"5s"`
+		AppHangThresholdCocoa   time.Duration `env:"SENTRY_APP_HANG_THRESHOLD_COCOA" env-default:"2s"`
+
+		AppStartColdThresholdAndroid time.Duration `env:"SENTRY_APP_START_COLD_THRESHOLD_ANDROID" env-default:"5s"`
+		AppStartWarmThresholdAndroid time.Duration `env:"SENTRY_APP_START_WARM_THRESHOLD_ANDROID" env-default:"2s"`
+		AppStartColdThresholdCocoa   time.Duration `env:"SENTRY_APP_START_COLD_THRESHOLD_COCOA" env-default:"2s"`
+		AppStartWarmThresholdCocoa   time.Duration `env:"SENTRY_APP_START_WARM_THRESHOLD_COCOA" env-default:"1s"`
+
+		FunctionsDurationsSketches bool          `env:"SENTRY_FUNCTIONS_DURATIONS_SKETCHES" env-default:"false"`
+		FunctionsThreadMetrics     bool          `env:"SENTRY_FUNCTIONS_THREAD_METRICS" env-default:"false"`
+		ChunkFunctionsBucketSize   time.Duration `env:"SENTRY_CHUNK_FUNCTIONS_BUCKET_SIZE" env-default:"0"`
+
+		FunctionRegressionsEnabled  bool          `env:"SENTRY_FUNCTION_REGRESSIONS_ENABLED" env-default:"false"`
+		FunctionRegressionsInterval time.Duration `env:"SENTRY_FUNCTION_REGRESSIONS_INTERVAL" env-default:"10m"`
+
+		ConsumerKafkaBrokers          []string      `env:"SENTRY_KAFKA_BROKERS_CONSUMER" env-default:"localhost:9092"`
+		ConsumerGroupID               string        `env:"SENTRY_KAFKA_CONSUMER_GROUP" env-default:"vroom"`
+		IngestProfilesKafkaTopic      string        `env:"SENTRY_KAFKA_TOPIC_INGEST_PROFILES" env-default:"ingest-profiles"`
+		IngestProfileChunksKafkaTopic string        `env:"SENTRY_KAFKA_TOPIC_INGEST_PROFILE_CHUNKS" env-default:"ingest-profile-chunks"`
+		DeadLetterKafkaTopic          string        `env:"SENTRY_KAFKA_TOPIC_DEAD_LETTER" env-default:"vroom-dead-letter"`
+		ConsumerMaxRetries            int           `env:"SENTRY_CONSUMER_MAX_RETRIES" env-default:"5"`
+		ConsumerRetryBackoff          time.Duration `env:"SENTRY_CONSUMER_RETRY_BACKOFF" env-default:"1s"`
+
+		BucketURL         string `env:"SENTRY_BUCKET_PROFILES" env-default:"file://./test/gcs/sentry-profiles"`
+		DeadLettersPrefix string `env:"SENTRY_DEAD_LETTERS_PREFIX"`
+
+		OutboxPrefix          string        `env:"SENTRY_OUTBOX_PREFIX"`
+		OutboxPublishInterval time.Duration `env:"SENTRY_OUTBOX_PUBLISH_INTERVAL" env-default:"1m"`
+	}
+
+	// KafkaWriterConfig holds the settings of the writer of a topic. Unset
+	// settings fall back to the defaults of the topic. Key is the field
+	// messages are keyed by, either project_id, profiler_id or none, and is
+	// ignored for occurrences which are always keyed by project.
+	KafkaWriterConfig struct {
+		Brokers      []string      `env:"BROKERS"`
+		Compression  string        `env:"COMPRESSION"`
+		BatchSize    int           `env:"BATCH_SIZE"`
+		BatchBytes   int64         `env:"BATCH_BYTES"`
+		Linger       time.Duration `env:"LINGER"`
+		RequiredAcks string        `env:"REQUIRED_ACKS"`
+		Key          string        `env:"KEY"`
 	}
 )
+
+// occurrenceOptions returns the settings of the occurrence detectors.
+func (c ServiceConfig) occurrenceOptions() occurrence.Options {
+	return occurrence.Options{
+		AppHangThresholds: map[platform.Platform]time.Duration{
+			platform.Android: c.AppHangThresholdAndroid,
+			platform.Cocoa:   c.AppHangThresholdCocoa,
+		},
+		AppStartThresholds: map[platform.Platform]map[string]time.Duration{
+			platform.Android: {
+				occurrence.AppStartColdOp: c.AppStartColdThresholdAndroid,
+				occurrence.AppStartWarmOp: c.AppStartWarmThresholdAndroid,
+			},
+			platform.Cocoa: {
+				occurrence.AppStartColdOp: c.AppStartColdThresholdCocoa,
+				occurrence.AppStartWarmOp: c.AppStartWarmThresholdCocoa,
+			},
+		},
+	}
+}
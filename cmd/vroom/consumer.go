@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/segmentio/kafka-go"
+)
+
+type (
+	KafkaReader interface {
+		FetchMessage(ctx context.Context) (kafka.Message, error)
+		CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+		Close() error
+	}
+
+	// consumer ingests profiles and chunks read from Kafka through the same
+	// pipeline as the HTTP handlers. A message is only committed once it was
+	// processed or sent to the dead-letter topic.
+	consumer struct {
+		env              *environment
+		reader           KafkaReader
+		deadLetterWriter KafkaWriter
+		maxRetries       int
+		retryBackoff     time.Duration
+	}
+
+	ingestFunc func(ctx context.Context, body []byte, opts ingestOptions) error
+)
+
+const (
+	deadLetterErrorHeader     = "vroom-error"
+	deadLetterTopicHeader     = "vroom-original-topic"
+	deadLetterPartitionHeader = "vroom-original-partition"
+	deadLetterOffsetHeader    = "vroom-original-offset"
+)
+
+var errUnknownTopic = errors.New("no ingestion pipeline for this topic")
+
+func (env *environment) newConsumer() (*consumer, error) {
+	transport, ok := createKafkaRoundTripper(env.config).(*kafka.Transport)
+	if !ok {
+		return nil, errors.New("kafka round tripper is not a transport")
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(env.config.ConsumerKafkaBrokers...),
+		Balancer:     kafka.CRC32Balancer{},
+		BatchBytes:   20 * MiB,
+		Completion:   countKafkaDeliveries(env.config.DeadLetterKafkaTopic),
+		Compression:  kafka.Lz4,
+		ReadTimeout:  3 * time.Second,
+		RequiredAcks: kafka.RequireAll,
+		Topic:        env.config.DeadLetterKafkaTopic,
+		WriteTimeout: 3 * time.Second,
+		Transport:    transport,
+	}
+	var deadLetterWriter KafkaWriter = w
+	if env.sink != nil {
+		deadLetterWriter = topicSink{sink: env.sink, topic: env.config.DeadLetterKafkaTopic}
+	} else {
+		kafkaWriterStats.add(w)
+	}
+	return &consumer{
+		env: env,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: env.config.ConsumerKafkaBrokers,
+			GroupID: env.config.ConsumerGroupID,
+			GroupTopics: []string{
+				env.config.IngestProfilesKafkaTopic,
+				env.config.IngestProfileChunksKafkaTopic,
+			},
+			MaxBytes: int(20 * MiB),
+			Dialer: &kafka.Dialer{
+				SASLMechanism: transport.SASL,
+				TLS:           transport.TLS,
+				Timeout:       3 * time.Second,
+				DualStack:     true,
+			},
+		}),
+		deadLetterWriter: deadLetterWriter,
+		maxRetries:       env.config.ConsumerMaxRetries,
+		retryBackoff:     env.config.ConsumerRetryBackoff,
+	}, nil
+}
+
+// run consumes messages until the context is canceled or a message can't be
+// processed nor dead-lettered, in which case it's left uncommitted.
+func (c *consumer) run(ctx context.Context) error {
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		err = c.process(ctx, m)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		err = c.reader.CommitMessages(ctx, m)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (c *consumer) close() {
+	err := c.reader.Close()
+	if err != nil {
+		sentry.CaptureException(err)
+	}
+	err = c.deadLetterWriter.Close()
+	if err != nil {
+		sentry.CaptureException(err)
+	}
+}
+
+// process ingests a message, retrying with an exponential backoff while the
+// pipeline reports a retryable error, and sends it to the dead-letter topic
+// once it fails for good.
+func (c *consumer) process(ctx context.Context, m kafka.Message) error {
+	ingest := c.ingestFunc(m.Topic)
+	if ingest == nil {
+		return c.deadLetter(ctx, m, errUnknownTopic)
+	}
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		hub := sentry.CurrentHub().Clone()
+		mctx := sentry.SetHubOnContext(ctx, hub)
+		hub.Scope().SetTags(map[string]string{
+			"kafka.topic":     m.Topic,
+			"kafka.partition": strconv.Itoa(m.Partition),
+		})
+		tx := sentry.StartTransaction(mctx, "consume "+m.Topic, sentry.WithOpName("queue.process"))
+		// Messages are delivered at least once, a payload already stored
+		// means a previous delivery failed after the storage write.
+		err := ingest(tx.Context(), m.Value, ingestOptions{AcceptStored: true})
+		tx.Finish()
+		if err == nil {
+			return nil
+		}
+		var ie *ingestError
+		if !errors.As(err, &ie) || !ie.Retryable() || attempt >= c.maxRetries {
+			return c.deadLetter(ctx, m, err)
+		}
+		slog.Warn(
+			"retrying message",
+			"topic", m.Topic,
+			"partition", m.Partition,
+			"offset", m.Offset,
+			"attempt", attempt+1,
+			"err", err,
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *consumer) ingestFunc(topic string) ingestFunc {
+	switch topic {
+	case c.env.config.IngestProfilesKafkaTopic:
+		return c.env.ingestProfile
+	case c.env.config.IngestProfileChunksKafkaTopic:
+		return c.env.ingestChunk
+	default:
+		return nil
+	}
+}
+
+// deadLetter sends the message to the dead-letter topic along with where it
+// came from and why it failed.
+func (c *consumer) deadLetter(ctx context.Context, m kafka.Message, cause error) error {
+	headers := make([]kafka.Header, 0, len(m.Headers)+4)
+	headers = append(headers, m.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: deadLetterErrorHeader, Value: []byte(cause.Error())},
+		kafka.Header{Key: deadLetterTopicHeader, Value: []byte(m.Topic)},
+		kafka.Header{Key: deadLetterPartitionHeader, Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: deadLetterOffsetHeader, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+	)
+	err := c.deadLetterWriter.WriteMessages(ctx, kafka.Message{
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't send message to the dead-letter topic: %w", err)
+	}
+	slog.Error(
+		"message sent to the dead-letter topic",
+		"topic", m.Topic,
+		"partition", m.Partition,
+		"offset", m.Offset,
+		"err", cause,
+	)
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/testutil"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+type (
+	kafkaReaderMock struct {
+		messages  []kafka.Message
+		committed []kafka.Message
+		cancel    context.CancelFunc
+	}
+
+	kafkaWriterRecorder struct {
+		messages []kafka.Message
+		calls    int
+		err      error
+	}
+)
+
+func (k *kafkaReaderMock) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(k.messages) == 0 {
+		k.cancel()
+		return kafka.Message{}, ctx.Err()
+	}
+	m := k.messages[0]
+	k.messages = k.messages[1:]
+	return m, nil
+}
+
+func (k *kafkaReaderMock) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	k.committed = append(k.committed, msgs...)
+	return nil
+}
+
+func (k *kafkaReaderMock) Close() error {
+	return nil
+}
+
+func (k *kafkaWriterRecorder) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	k.calls++
+	if k.err != nil {
+		return k.err
+	}
+	k.messages = append(k.messages, msgs...)
+	return nil
+}
+
+func (k *kafkaWriterRecorder) Close() error {
+	return nil
+}
+
+func consumerTestChunk(t *testing.T) []byte {
+	b, err := json.Marshal(chunk.SampleChunk{
+		ID:             uuid.New().String(),
+		ProfilerID:     uuid.New().String(),
+		Environment:    "dev",
+		Platform:       "python",
+		Release:        "1.2",
+		OrganizationID: 1,
+		ProjectID:      1,
+		Version:        "2",
+		Profile: chunk.SampleData{
+			Frames: []frame.Frame{
+				{
+					Function: "test",
+					InApp:    &testutil.True,
+					Platform: platform.Python,
+				},
+			},
+			Stacks: [][]int{
+				{0},
+			},
+			Samples: []chunk.Sample{
+				{StackID: 0, Timestamp: 1.0},
+			},
+		},
+		Measurements: json.RawMessage("null"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestConsumer(t *testing.T) {
+	errWrite := errors.New("write failed")
+
+	tests := []struct {
+		name               string
+		message            kafka.Message
+		profilingWriterErr error
+		deadLetterErr      error
+		wantErr            bool
+		wantCommitted      bool
+		wantDeadLetter     bool
+		wantWriteCalls     int
+	}{
+		{
+			name:           "valid chunk",
+			message:        kafka.Message{Topic: "ingest-profile-chunks", Value: consumerTestChunk(t)},
+			wantCommitted:  true,
+			wantWriteCalls: 2,
+		},
+		{
+			name:           "invalid payload",
+			message:        kafka.Message{Topic: "ingest-profile-chunks", Value: []byte("{")},
+			wantCommitted:  true,
+			wantDeadLetter: true,
+		},
+		{
+			name:           "unknown topic",
+			message:        kafka.Message{Topic: "unknown", Value: consumerTestChunk(t)},
+			wantCommitted:  true,
+			wantDeadLetter: true,
+		},
+		{
+			name:               "retried publish failure",
+			message:            kafka.Message{Topic: "ingest-profile-chunks", Value: consumerTestChunk(t)},
+			profilingWriterErr: errWrite,
+			wantCommitted:      true,
+			wantDeadLetter:     true,
+			wantWriteCalls:     3,
+		},
+		{
+			name:               "dead-letter failure",
+			message:            kafka.Message{Topic: "ingest-profile-chunks", Value: []byte("{")},
+			profilingWriterErr: errWrite,
+			deadLetterErr:      errWrite,
+			wantErr:            true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			profilingWriter := &kafkaWriterRecorder{err: test.profilingWriterErr}
+			deadLetterWriter := &kafkaWriterRecorder{err: test.deadLetterErr}
+			reader := &kafkaReaderMock{
+				messages: []kafka.Message{test.message},
+				cancel:   cancel,
+			}
+			env := &environment{
+				storage:         fileBlobBucket,
+				profilingWriter: profilingWriter,
+				config: ServiceConfig{
+					CallTreesKafkaTopic:           "profiles-call-tree",
+					ProfileChunksKafkaTopic:       "snuba-profile-chunks",
+					IngestProfilesKafkaTopic:      "ingest-profiles",
+					IngestProfileChunksKafkaTopic: "ingest-profile-chunks",
+				},
+			}
+			c := &consumer{
+				env:              env,
+				reader:           reader,
+				deadLetterWriter: deadLetterWriter,
+				maxRetries:       2,
+			}
+
+			err := c.run(ctx)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %v, got: %v", test.wantErr, err)
+			}
+			if committed := len(reader.committed) == 1; committed != test.wantCommitted {
+				t.Fatalf("expected committed: %v, got: %v", test.wantCommitted, committed)
+			}
+			if deadLettered := len(deadLetterWriter.messages) == 1; deadLettered != test.wantDeadLetter {
+				t.Fatalf("expected dead-lettered: %v, got: %v", test.wantDeadLetter, deadLettered)
+			}
+			if profilingWriter.calls != test.wantWriteCalls {
+				t.Fatalf("expected %d writes, got: %d", test.wantWriteCalls, profilingWriter.calls)
+			}
+			if test.wantDeadLetter {
+				headers := make(map[string]string)
+				for _, h := range deadLetterWriter.messages[0].Headers {
+					headers[h.Key] = string(h.Value)
+				}
+				if headers[deadLetterTopicHeader] != test.message.Topic {
+					t.Fatalf("expected original topic header %q, got: %q", test.message.Topic, headers[deadLetterTopicHeader])
+				}
+				if headers[deadLetterErrorHeader] == "" {
+					t.Fatal("expected an error header")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"gocloud.dev/blob"
+
+	"github.com/getsentry/vroom/internal/jsonutil"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+type (
+	deadLetterKind string
+
+	// deadLetter holds a payload the ingestion pipeline couldn't process,
+	// along with what we know about it and why it failed, so it can be
+	// replayed once a fix is deployed.
+	deadLetter struct {
+		ID             string            `json:"id"`
+		Kind           deadLetterKind    `json:"kind"`
+		Stage          ingestStage       `json:"stage"`
+		Error          string            `json:"error"`
+		Platform       platform.Platform `json:"platform,omitempty"`
+		OrganizationID uint64            `json:"organization_id"`
+		ProjectID      uint64            `json:"project_id"`
+		Received       time.Time         `json:"received"`
+		Payload        []byte            `json:"payload"`
+	}
+)
+
+const (
+	deadLetterProfile deadLetterKind = "profile"
+	deadLetterChunk   deadLetterKind = "chunk"
+)
+
+// shouldDeadLetter reports whether a payload failing with err would be
+// dropped for good and should be kept for later.
+func shouldDeadLetter(err error) bool {
+	var ie *ingestError
+	if !errors.As(err, &ie) {
+		return false
+	}
+	return ie.kind != ingestErrorDuplicate && !ie.Retryable()
+}
+
+func newDeadLetter(kind deadLetterKind, body []byte, err error) deadLetter {
+	dl := deadLetter{
+		ID:       uuid.New().String(),
+		Kind:     kind,
+		Error:    err.Error(),
+		Received: time.Now().UTC(),
+		Payload:  body,
+	}
+	var ie *ingestError
+	if errors.As(err, &ie) {
+		dl.Stage = ie.stage
+	}
+	// The payload might not be valid, we keep whatever metadata we can read
+	// without decoding it entirely.
+	fields, err := jsonutil.PeekRaw(body, "platform", "organization_id", "project_id")
+	if err != nil {
+		return dl
+	}
+	for field, v := range map[string]interface{}{
+		"platform":        &dl.Platform,
+		"organization_id": &dl.OrganizationID,
+		"project_id":      &dl.ProjectID,
+	} {
+		if raw, ok := fields[field]; ok {
+			_ = json.Unmarshal(raw, v)
+		}
+	}
+	return dl
+}
+
+func (dl deadLetter) StoragePath(prefix string) string {
+	return path.Join(
+		prefix,
+		string(dl.Kind),
+		strconv.FormatUint(dl.OrganizationID, 10),
+		strconv.FormatUint(dl.ProjectID, 10),
+		dl.ID,
+	)
+}
+
+// storeDeadLetter keeps a payload the pipeline failed to process if dead
+// letters are enabled and retrying wouldn't help.
+func (env *environment) storeDeadLetter(ctx context.Context, kind deadLetterKind, body []byte, err error) {
+	if env.config.DeadLettersPrefix == "" || !shouldDeadLetter(err) {
+		return
+	}
+	dl := newDeadLetter(kind, body, err)
+	s := sentry.StartSpan(ctx, "gcs.write")
+	s.Description = "Write dead letter to GCS"
+	err = storageutil.CompressedWrite(ctx, env.storage, dl.StoragePath(env.config.DeadLettersPrefix), dl)
+	s.Finish()
+	if err != nil {
+		if hub := sentry.GetHubFromContext(ctx); hub != nil {
+			hub.CaptureException(err)
+		}
+	}
+}
+
+// replayDeadLetters runs the stored dead letters through the ingestion
+// pipeline again. Dead letters processed successfully are deleted, the other
+// ones are kept for a later replay.
+func (env *environment) replayDeadLetters(ctx context.Context) (int, int, error) {
+	if env.config.DeadLettersPrefix == "" {
+		return 0, 0, errors.New("dead letters prefix is not configured")
+	}
+	var replayed, failed int
+	it := env.storage.List(&blob.ListOptions{Prefix: env.config.DeadLettersPrefix + "/"})
+	for {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return replayed, failed, err
+		}
+		if obj.IsDir {
+			continue
+		}
+		var dl deadLetter
+		err = storageutil.UnmarshalCompressed(ctx, env.storage, obj.Key, &dl)
+		if err != nil {
+			return replayed, failed, err
+		}
+		var ingest ingestFunc
+		switch dl.Kind {
+		case deadLetterProfile:
+			ingest = env.ingestProfile
+		case deadLetterChunk:
+			ingest = env.ingestChunk
+		default:
+			slog.Warn("unknown dead letter kind", "key", obj.Key, "kind", dl.Kind)
+			failed++
+			continue
+		}
+		hub := sentry.CurrentHub().Clone()
+		err = ingest(sentry.SetHubOnContext(ctx, hub), dl.Payload, ingestOptions{AcceptStored: true})
+		if err != nil {
+			slog.Warn("dead letter replay failed", "key", obj.Key, "err", err)
+			failed++
+			continue
+		}
+		err = env.storage.Delete(ctx, obj.Key)
+		if err != nil {
+			return replayed, failed, err
+		}
+		replayed++
+	}
+	return replayed, failed, nil
+}
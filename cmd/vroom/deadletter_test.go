@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"gocloud.dev/blob"
+
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+func listDeadLetters(t *testing.T, b *blob.Bucket, prefix string) []string {
+	var keys []string
+	it := b.List(&blob.ListOptions{Prefix: prefix + "/"})
+	for {
+		obj, err := it.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestPostChunkStoresDeadLetter(t *testing.T) {
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: KafkaWriterMock{},
+		config: ServiceConfig{
+			DeadLettersPrefix: "dead-letters-post",
+		},
+	}
+	body := []byte(`{"platform":"python","organization_id":1,"project_id":2,"profile":[]}`)
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	env.postChunk(w, req)
+	resp := w.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status code 400. Found: %d", resp.StatusCode)
+	}
+
+	keys := listDeadLetters(t, fileBlobBucket, env.config.DeadLettersPrefix)
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 dead letter, got: %d", len(keys))
+	}
+	var dl deadLetter
+	err := storageutil.UnmarshalCompressed(context.Background(), fileBlobBucket, keys[0], &dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Kind != deadLetterChunk || dl.Stage != ingestStageUnmarshal {
+		t.Fatalf("unexpected kind or stage: %s, %s", dl.Kind, dl.Stage)
+	}
+	if dl.Platform != "python" || dl.OrganizationID != 1 || dl.ProjectID != 2 {
+		t.Fatalf("unexpected metadata: %v, %d, %d", dl.Platform, dl.OrganizationID, dl.ProjectID)
+	}
+	if dl.Error == "" {
+		t.Fatal("expected an error")
+	}
+	if !bytes.Equal(dl.Payload, body) {
+		t.Fatalf("unexpected payload: %s", dl.Payload)
+	}
+}
+
+func TestNewDeadLetterTruncatedPayload(t *testing.T) {
+	body := []byte(`{"platform":"cocoa","organization_id":1,"project_id":2,"profile":{"samples":[`)
+	dl := newDeadLetter(deadLetterProfile, body, errors.New("unexpected end of JSON input"))
+	if dl.Platform != "cocoa" || dl.OrganizationID != 1 || dl.ProjectID != 2 {
+		t.Fatalf("unexpected metadata: %v, %d, %d", dl.Platform, dl.OrganizationID, dl.ProjectID)
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: KafkaWriterMock{},
+		config: ServiceConfig{
+			DeadLettersPrefix: "dead-letters-replay",
+		},
+	}
+	ctx := context.Background()
+	for _, body := range [][]byte{consumerTestChunk(t), []byte("{")} {
+		err := newIngestError(ingestErrorInvalid, ingestStageUnmarshal, io.ErrUnexpectedEOF)
+		env.storeDeadLetter(ctx, deadLetterChunk, body, err)
+	}
+	if keys := listDeadLetters(t, fileBlobBucket, env.config.DeadLettersPrefix); len(keys) != 2 {
+		t.Fatalf("expected 2 dead letters, got: %d", len(keys))
+	}
+
+	replayed, failed, err := env.replayDeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 || failed != 1 {
+		t.Fatalf("expected 1 replayed and 1 failed, got: %d and %d", replayed, failed)
+	}
+	if keys := listDeadLetters(t, fileBlobBucket, env.config.DeadLettersPrefix); len(keys) != 1 {
+		t.Fatalf("expected 1 dead letter left, got: %d", len(keys))
+	}
+}
@@ -12,14 +12,17 @@ import (
 
 	"github.com/getsentry/vroom/internal/examples"
 	"github.com/getsentry/vroom/internal/flamegraph"
+	"github.com/getsentry/vroom/internal/httputil"
 	"github.com/getsentry/vroom/internal/metrics"
+	"github.com/getsentry/vroom/internal/storageutil"
 )
 
 type (
 	postFlamegraphBody struct {
-		Transaction     []examples.TransactionProfileCandidate `json:"transaction"`
-		Continuous      []examples.ContinuousProfileCandidate  `json:"continuous"`
-		GenerateMetrics bool                                   `json:"generate_metrics"`
+		Transaction          []examples.TransactionProfileCandidate `json:"transaction"`
+		Continuous           []examples.ContinuousProfileCandidate  `json:"continuous"`
+		GenerateMetrics      bool                                   `json:"generate_metrics"`
+		CollapseInlineFrames bool                                   `json:"collapse_inline_frames"`
 	}
 )
 
@@ -50,7 +53,7 @@ func (env *environment) postFlamegraph(w http.ResponseWriter, r *http.Request) {
 		if hub != nil {
 			hub.CaptureException(err)
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
 		return
 	}
 
@@ -66,7 +69,8 @@ func (env *environment) postFlamegraph(w http.ResponseWriter, r *http.Request) {
 		organizationID,
 		body.Transaction,
 		body.Continuous,
-		readJobs,
+		body.CollapseInlineFrames,
+		env.readQueue(storageutil.PriorityBulk),
 		ma,
 		s,
 	)
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/flamegraph"
+	"github.com/getsentry/vroom/internal/httputil"
+	"github.com/getsentry/vroom/internal/metrics"
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+type (
+	functionsCandidates struct {
+		Transaction []examples.TransactionProfileCandidate `json:"transaction"`
+		Continuous  []examples.ContinuousProfileCandidate  `json:"continuous"`
+	}
+
+	postFunctionsBody struct {
+		functionsCandidates
+		MaxUniqueFunctions *uint                    `json:"max_unique_functions"`
+		MaxNumOfExamples   *uint                    `json:"max_num_of_examples"`
+		MinDepth           *uint                    `json:"min_depth"`
+		SortBy             metrics.SortKey          `json:"sort_by"`
+		Filter             *metrics.FunctionsFilter `json:"filter"`
+	}
+
+	postFunctionsResponse struct {
+		Functions []examples.FunctionMetrics `json:"functions"`
+	}
+
+	postFunctionsComparisonBody struct {
+		Before functionsCandidates `json:"before"`
+		After  functionsCandidates `json:"after"`
+	}
+
+	postFunctionsComparisonResponse struct {
+		Functions []metrics.FunctionComparison `json:"functions"`
+	}
+
+	postFunctionCallGraphBody struct {
+		functionsCandidates
+		Fingerprint      uint32 `json:"fingerprint"`
+		MaxNumOfExamples *uint  `json:"max_num_of_examples"`
+	}
+)
+
+const (
+	maxUniqueFunctionsLimit uint = 1000
+	maxNumOfExamplesLimit   uint = 100
+	defaultNumOfExamples    uint = 5
+)
+
+// newAggregator returns an aggregator configured from the request, falling
+// back on the defaults we use for flamegraphs.
+func (b postFunctionsBody) newAggregator() (metrics.Aggregator, error) {
+	maxUniqueFunctions := uint(maxUniqueFunctionsPerProfile)
+	if b.MaxUniqueFunctions != nil {
+		maxUniqueFunctions = min(*b.MaxUniqueFunctions, maxUniqueFunctionsLimit)
+	}
+	maxNumOfExamples := defaultNumOfExamples
+	if b.MaxNumOfExamples != nil {
+		maxNumOfExamples = min(*b.MaxNumOfExamples, maxNumOfExamplesLimit)
+	}
+	depth := minDepth
+	if b.MinDepth != nil {
+		depth = *b.MinDepth
+	}
+	if err := b.SortBy.Validate(); err != nil {
+		return metrics.Aggregator{}, err
+	}
+	ma := metrics.NewAggregator(maxUniqueFunctions, maxNumOfExamples, depth)
+	// Without a filter, the aggregator only keeps application functions.
+	ma.Filter = b.Filter
+	ma.SortBy = b.SortBy
+	return ma, nil
+}
+
+func (env *environment) postFunctions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	downloadContext, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	hub := sentry.GetHubFromContext(ctx)
+	ps := httprouter.ParamsFromContext(ctx)
+	rawOrganizationID := ps.ByName("organization_id")
+	organizationID, err := strconv.ParseUint(rawOrganizationID, 10, 64)
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if hub != nil {
+		hub.Scope().SetTag("organization_id", rawOrganizationID)
+	}
+
+	var body postFunctionsBody
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Decoding data"
+	err = json.NewDecoder(r.Body).Decode(&body)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
+		return
+	}
+
+	ma, err := body.newAggregator()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s = sentry.StartSpan(ctx, "processing")
+	s.Description = "Aggregate functions"
+	functions, err := flamegraph.GetMetricsFromCandidates(
+		downloadContext,
+		env.storage,
+		organizationID,
+		body.Transaction,
+		body.Continuous,
+		env.readQueue(storageutil.PriorityBulk),
+		&ma,
+		s,
+	)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s = sentry.StartSpan(ctx, "json.marshal")
+	defer s.Finish()
+	b, err := json.Marshal(postFunctionsResponse{Functions: functions})
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+func (env *environment) postFunctionsComparison(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	downloadContext, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	hub := sentry.GetHubFromContext(ctx)
+	ps := httprouter.ParamsFromContext(ctx)
+	rawOrganizationID := ps.ByName("organization_id")
+	organizationID, err := strconv.ParseUint(rawOrganizationID, 10, 64)
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if hub != nil {
+		hub.Scope().SetTag("organization_id", rawOrganizationID)
+	}
+
+	var body postFunctionsComparisonBody
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Decoding data"
+	err = json.NewDecoder(r.Body).Decode(&body)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
+		return
+	}
+
+	s = sentry.StartSpan(ctx, "processing")
+	s.Description = "Aggregate functions"
+	sides := []functionsCandidates{body.Before, body.After}
+	aggregators := make([]metrics.Aggregator, len(sides))
+	errs := make([]error, len(sides))
+	// Both sides share the read jobs limit of the request.
+	queue := env.readQueue(storageutil.PriorityBulk)
+	var wg sync.WaitGroup
+	for i, candidates := range sides {
+		aggregators[i] = metrics.NewAggregator(maxUniqueFunctionsPerProfile, 5, minDepth)
+		wg.Add(1)
+		go func(i int, candidates functionsCandidates) {
+			defer wg.Done()
+			errs[i] = flamegraph.AggregateMetricsFromCandidates(
+				downloadContext,
+				env.storage,
+				organizationID,
+				candidates.Transaction,
+				candidates.Continuous,
+				queue,
+				&aggregators[i],
+				s,
+			)
+		}(i, candidates)
+	}
+	wg.Wait()
+	s.Finish()
+	for _, err := range errs {
+		if err != nil {
+			if hub != nil {
+				hub.CaptureException(err)
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s = sentry.StartSpan(ctx, "json.marshal")
+	defer s.Finish()
+	b, err := json.Marshal(postFunctionsComparisonResponse{
+		Functions: metrics.CompareAggregators(&aggregators[0], &aggregators[1], maxUniqueFunctionsPerProfile),
+	})
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+func (env *environment) postFunctionCallGraph(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	downloadContext, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	hub := sentry.GetHubFromContext(ctx)
+	ps := httprouter.ParamsFromContext(ctx)
+	rawOrganizationID := ps.ByName("organization_id")
+	organizationID, err := strconv.ParseUint(rawOrganizationID, 10, 64)
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if hub != nil {
+		hub.Scope().SetTag("organization_id", rawOrganizationID)
+	}
+
+	var body postFunctionCallGraphBody
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Decoding data"
+	err = json.NewDecoder(r.Body).Decode(&body)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
+		return
+	}
+
+	if hub != nil {
+		hub.Scope().SetTag("fingerprint", strconv.FormatUint(uint64(body.Fingerprint), 10))
+	}
+
+	maxNumOfExamples := defaultNumOfExamples
+	if body.MaxNumOfExamples != nil {
+		maxNumOfExamples = min(*body.MaxNumOfExamples, maxNumOfExamplesLimit)
+	}
+	cga := metrics.NewCallGraphAggregator(body.Fingerprint, maxNumOfExamples)
+
+	s = sentry.StartSpan(ctx, "processing")
+	s.Description = "Aggregate call graph"
+	callGraph, err := flamegraph.GetCallGraphFromCandidates(
+		downloadContext,
+		env.storage,
+		organizationID,
+		body.Transaction,
+		body.Continuous,
+		env.readQueue(storageutil.PriorityBulk),
+		&cga,
+		s,
+	)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s = sentry.StartSpan(ctx, "json.marshal")
+	defer s.Finish()
+	b, err := json.Marshal(callGraph)
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+func TestPostFunctionsBodyFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantInApp bool
+		wantOther bool
+	}{
+		{
+			name:      "without a filter",
+			body:      `{}`,
+			wantInApp: true,
+		},
+		{
+			name:      "with a filter on system functions",
+			body:      `{"filter":{"in_app":false}}`,
+			wantOther: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body postFunctionsBody
+			if err := json.Unmarshal([]byte(test.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			ma, err := body.newAggregator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected := ma.SelectFunctions([]nodetree.CallTreeFunction{
+				{Function: "app", Fingerprint: 1, InApp: true},
+				{Function: "system", Fingerprint: 2, InApp: false},
+			})
+			var gotInApp, gotOther bool
+			for _, f := range selected {
+				if f.InApp {
+					gotInApp = true
+				} else {
+					gotOther = true
+				}
+			}
+			if gotInApp != test.wantInApp || gotOther != test.wantOther {
+				t.Fatalf("unexpected functions selected: %+v", selected)
+			}
+		})
+	}
+}
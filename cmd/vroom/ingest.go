@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	"gocloud.dev/gcerrors"
+)
+
+type (
+	ingestErrorKind int
+
+	// ingestStage is the step of the ingestion pipeline an error happened at.
+	ingestStage string
+
+	// ingestError is returned by the ingestion pipeline shared by the HTTP
+	// handlers and the Kafka consumer so each of them can decide how to
+	// report a failure.
+	ingestError struct {
+		kind  ingestErrorKind
+		stage ingestStage
+		err   error
+	}
+
+	ingestOptions struct {
+		// AcceptStored lets processing carry on when the payload was already
+		// written to storage, so a redelivered message still produces its
+		// downstream messages.
+		AcceptStored bool
+	}
+)
+
+const (
+	// ingestErrorInvalid is used for payloads we can't decode.
+	ingestErrorInvalid ingestErrorKind = iota
+	// ingestErrorDuplicate is used when the payload was already stored.
+	ingestErrorDuplicate
+	// ingestErrorTransient is used for storage errors worth retrying.
+	ingestErrorTransient
+	// ingestErrorInternal is used for errors retrying won't fix.
+	ingestErrorInternal
+	// ingestErrorPublish is used when a downstream Kafka message couldn't
+	// be written.
+	ingestErrorPublish
+)
+
+const (
+	ingestStageUnmarshal ingestStage = "unmarshal"
+	ingestStageStorage   ingestStage = "storage"
+	ingestStageCallTrees ingestStage = "call_trees"
+	ingestStageMarshal   ingestStage = "marshal"
+	ingestStagePublish   ingestStage = "publish"
+)
+
+func (k ingestErrorKind) String() string {
+	switch k {
+	case ingestErrorInvalid:
+		return "invalid"
+	case ingestErrorDuplicate:
+		return "duplicate"
+	case ingestErrorTransient:
+		return "transient"
+	case ingestErrorInternal:
+		return "internal"
+	case ingestErrorPublish:
+		return "publish"
+	default:
+		return "unknown"
+	}
+}
+
+func (e *ingestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ingestError) Unwrap() error {
+	return e.err
+}
+
+// Retryable reports whether processing the same payload again might succeed.
+func (e *ingestError) Retryable() bool {
+	return e.kind == ingestErrorTransient || e.kind == ingestErrorPublish
+}
+
+func (e *ingestError) StatusCode() int {
+	switch e.kind {
+	case ingestErrorInvalid:
+		return http.StatusBadRequest
+	case ingestErrorDuplicate:
+		return http.StatusPreconditionFailed
+	case ingestErrorTransient:
+		return http.StatusTooManyRequests
+	case ingestErrorPublish:
+		// The payload can be sent again once Kafka is reachable.
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ingestHub returns the hub set on the context, or sets a new one on it, so
+// the ingestion pipeline always has a hub to report errors to.
+func ingestHub(ctx context.Context) (context.Context, *sentry.Hub) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub().Clone()
+		ctx = sentry.SetHubOnContext(ctx, hub)
+	}
+	return ctx, hub
+}
+
+func newIngestError(kind ingestErrorKind, stage ingestStage, err error) error {
+	return &ingestError{kind: kind, stage: stage, err: err}
+}
+
+// storageWriteError classifies an error returned while writing a payload to
+// storage. It returns nil if the payload was already stored and opts allow it.
+func storageWriteError(hub *sentry.Hub, err error, opts ingestOptions) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// These are transient errors, we'll retry.
+		return newIngestError(ingestErrorTransient, ingestStageStorage, err)
+	}
+	if code := gcerrors.Code(err); code == gcerrors.FailedPrecondition {
+		if opts.AcceptStored {
+			return nil
+		}
+		// This indicates a duplicate, we won't retry.
+		return newIngestError(ingestErrorDuplicate, ingestStageStorage, err)
+	}
+	if hub != nil {
+		hub.CaptureException(err)
+	}
+	// These errors won't be retried.
+	return newIngestError(ingestErrorInternal, ingestStageStorage, err)
+}
+
+// removeStored deletes a payload written to storage by a call of the
+// pipeline failing to send its metadata downstream, so a retry isn't
+// rejected as a duplicate.
+func (env *environment) removeStored(ctx context.Context, hub *sentry.Hub, objectName string) {
+	err := env.storage.Delete(ctx, objectName)
+	if err != nil && hub != nil {
+		hub.CaptureException(err)
+	}
+}
+
+// writeIngestError writes the status code matching an error returned by the
+// ingestion pipeline.
+func writeIngestError(w http.ResponseWriter, err error) {
+	var ie *ingestError
+	if errors.As(err, &ie) {
+		w.WriteHeader(ie.StatusCode())
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
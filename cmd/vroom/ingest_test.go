@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/chunk"
+)
+
+func TestPostChunkRetryableAfterPublishFailure(t *testing.T) {
+	body := consumerTestChunk(t)
+	c := chunk.New(new(chunk.SampleChunk))
+	if err := c.UnmarshalJSON(body); err != nil {
+		t.Fatal(err)
+	}
+	writer := &kafkaWriterRecorder{err: errors.New("write failed")}
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: writer,
+		config: ServiceConfig{
+			ProfileChunksKafkaTopic: "snuba-profile-chunks",
+		},
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	env.postChunk(w, req)
+	if code := w.Result().StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code 503. Found: %d", code)
+	}
+	exists, err := fileBlobBucket.Exists(context.Background(), c.StoragePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the chunk to be removed from storage")
+	}
+
+	// Once Kafka is reachable again, a retry goes through.
+	writer.err = nil
+	req = httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	env.postChunk(w, req)
+	if code := w.Result().StatusCode; code != http.StatusNoContent {
+		t.Fatalf("Expected status code 204. Found: %d", code)
+	}
+}
+
+// topicFailingWriter fails to write messages to a topic.
+type topicFailingWriter struct {
+	kafkaWriterRecorder
+	topic string
+}
+
+func (k *topicFailingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		if m.Topic == k.topic {
+			return errors.New("write failed")
+		}
+	}
+	return k.kafkaWriterRecorder.WriteMessages(ctx, msgs...)
+}
+
+func TestPostChunkFunctionsFailureIsNotFatal(t *testing.T) {
+	body := consumerTestChunk(t)
+	c := chunk.New(new(chunk.SampleChunk))
+	if err := c.UnmarshalJSON(body); err != nil {
+		t.Fatal(err)
+	}
+	writer := &topicFailingWriter{topic: "profiles-call-tree"}
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: writer,
+		config: ServiceConfig{
+			CallTreesKafkaTopic:     "profiles-call-tree",
+			ProfileChunksKafkaTopic: "snuba-profile-chunks",
+		},
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	env.postChunk(w, req)
+	if code := w.Result().StatusCode; code != http.StatusNoContent {
+		t.Fatalf("Expected status code 204. Found: %d", code)
+	}
+	if len(writer.messages) != 1 || writer.messages[0].Topic != env.config.ProfileChunksKafkaTopic {
+		t.Fatalf("unexpected messages: %v", writer.messages)
+	}
+	exists, err := fileBlobBucket.Exists(context.Background(), c.StoragePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the chunk to be kept in storage")
+	}
+}
+
+func TestPostChunkBodyTooLarge(t *testing.T) {
+	body := consumerTestChunk(t)
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: &kafkaWriterRecorder{},
+		config: ServiceConfig{
+			ChunkMaxBodySize: int64(len(body) - 1),
+		},
+	}
+	router, err := env.newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/chunk", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if code := w.Result().StatusCode; code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status code 413. Found: %d", code)
+	}
+}
+
+func TestIngestWithoutHub(t *testing.T) {
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: &kafkaWriterRecorder{},
+	}
+	body := []byte("{")
+	for name, ingest := range map[string]func(context.Context, []byte, ingestOptions) error{
+		"profile": env.ingestProfile,
+		"chunk":   env.ingestChunk,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var e *ingestError
+			err := ingest(context.Background(), body, ingestOptions{})
+			if !errors.As(err, &e) || e.kind != ingestErrorInvalid {
+				t.Fatalf("expected an invalid payload error, got %v", err)
+			}
+		})
+	}
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/segmentio/kafka-go"
 
 	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/metrics"
 	"github.com/getsentry/vroom/internal/nodetree"
 	"github.com/getsentry/vroom/internal/platform"
 	"github.com/getsentry/vroom/internal/profile"
-	"github.com/segmentio/kafka-go"
+	"github.com/getsentry/vroom/internal/quantile"
+	"github.com/getsentry/vroom/internal/schema"
 )
 
 type (
@@ -74,6 +85,34 @@ func buildFunctionsKafkaMessage(p profile.Profile, functions []nodetree.CallTree
 	}
 }
 
+// addDurationsSketches replaces the durations of each function, and of its
+// metrics per thread, with a sketch so consumers can merge them instead of
+// keeping every duration. Messages with sketches are sent with the version
+// of the functions schema made for them.
+func addDurationsSketches(functions []nodetree.CallTreeFunction) {
+	for i, f := range functions {
+		if f.Durations == nil {
+			functions[i].Durations = quantile.FromValues(f.DurationsNS)
+		}
+		functions[i].DurationsNS = nil
+		for name, t := range f.Threads {
+			if t.Durations == nil {
+				t.Durations = quantile.FromValues(t.DurationsNS)
+			}
+			t.DurationsNS = nil
+			f.Threads[name] = t
+		}
+	}
+}
+
+// dropThreadMetrics removes the metrics per thread of functions, which are only
+// sent downstream once enabled.
+func dropThreadMetrics(functions []nodetree.CallTreeFunction) {
+	for i := range functions {
+		functions[i].Threads = nil
+	}
+}
+
 func buildChunkFunctionsKafkaMessage(c *chunk.Chunk, functions []nodetree.CallTreeFunction) FunctionsKafkaMessage {
 	return FunctionsKafkaMessage{
 		Environment:            c.GetEnvironment(),
@@ -92,6 +131,14 @@ func buildChunkFunctionsKafkaMessage(c *chunk.Chunk, functions []nodetree.CallTr
 	}
 }
 
+// withFunctionsBucket scopes a functions message to a time bucket of a chunk.
+func withFunctionsBucket(m FunctionsKafkaMessage, bucket metrics.FunctionsBucket) FunctionsKafkaMessage {
+	m.Timestamp = int64(bucket.StartNS / uint64(time.Second))
+	m.StartTimestamp = float64(bucket.StartNS) / float64(time.Second)
+	m.EndTimestamp = float64(bucket.EndNS) / float64(time.Second)
+	return m
+}
+
 func buildProfileKafkaMessage(p profile.Profile) ProfileKafkaMessage {
 	t := p.Transaction()
 	m := p.Metadata()
@@ -123,7 +170,294 @@ func buildProfileKafkaMessage(p profile.Profile) ProfileKafkaMessage {
 	}
 }
 
+// countKafkaDeliveries returns the completion callback of the writer of a
+// topic. It counts the messages delivered and the ones we failed to write.
+// Writers set the topic themselves so messages only carry it once delivered.
+func countKafkaDeliveries(topic string) func(messages []kafka.Message, err error) {
+	return func(messages []kafka.Message, err error) {
+		counter := kafkaMessagesDelivered
+		if err != nil {
+			counter = kafkaMessagesFailed
+		}
+		counter.WithLabelValues(topic).Add(float64(len(messages)))
+	}
+}
+
 type KafkaWriter interface {
 	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
 	Close() error
 }
+
+// validatingWriter checks a sample of the messages against the schema named
+// in their headers before writing them. Invalid messages are counted and
+// reported but still written, consumers decide what to do with them.
+type validatingWriter struct {
+	KafkaWriter
+
+	sampleRate float64
+}
+
+// withValidation returns w validating a sample of its messages, or w itself
+// if the sample rate is 0.
+func withValidation(w KafkaWriter, sampleRate float64) KafkaWriter {
+	if sampleRate <= 0 {
+		return w
+	}
+	return validatingWriter{KafkaWriter: w, sampleRate: sampleRate}
+}
+
+func (w validatingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		if rand.Float64() >= w.sampleRate {
+			continue
+		}
+		name, version, ok := schema.FromHeaders(m.Headers)
+		if !ok {
+			continue
+		}
+		err := schema.Validate(name, version, m.Value)
+		if err == nil {
+			continue
+		}
+		kafkaMessagesInvalid.WithLabelValues(string(name)).Inc()
+		hub := sentry.GetHubFromContext(ctx)
+		if hub == nil {
+			hub = sentry.CurrentHub()
+		}
+		hub.WithScope(func(scope *sentry.Scope) {
+			scope.SetTags(map[string]string{
+				"schema":      string(name),
+				"kafka_topic": m.Topic,
+			})
+			hub.CaptureException(err)
+		})
+	}
+	return w.KafkaWriter.WriteMessages(ctx, msgs...)
+}
+
+// syncWritesLinger is how long synchronous writers wait for a batch to fill
+// up when no linger is configured.
+const syncWritesLinger = 5 * time.Millisecond
+
+const (
+	kafkaKeyNone       = "none"
+	kafkaKeyProjectID  = "project_id"
+	kafkaKeyProfilerID = "profiler_id"
+)
+
+type (
+	// kafkaMessageKeys holds the values a message can be keyed by.
+	kafkaMessageKeys struct {
+		ProjectID  uint64
+		ProfilerID string
+	}
+
+	// topicWriters sends each message with the writer of its topic.
+	topicWriters map[string]KafkaWriter
+)
+
+// withDefaults returns the settings with the ones left unset taken from d.
+func (c KafkaWriterConfig) withDefaults(d KafkaWriterConfig) KafkaWriterConfig {
+	if len(c.Brokers) == 0 {
+		c.Brokers = d.Brokers
+	}
+	if c.Compression == "" {
+		c.Compression = d.Compression
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.BatchBytes == 0 {
+		c.BatchBytes = d.BatchBytes
+	}
+	if c.Linger == 0 {
+		c.Linger = d.Linger
+	}
+	if c.RequiredAcks == "" {
+		c.RequiredAcks = d.RequiredAcks
+	}
+	if c.Key == "" {
+		c.Key = d.Key
+	}
+	return c
+}
+
+func (c KafkaWriterConfig) compression() (kafka.Compression, error) {
+	switch strings.ToLower(c.Compression) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unknown kafka compression codec: %q", c.Compression)
+	}
+}
+
+func (c KafkaWriterConfig) requiredAcks() (kafka.RequiredAcks, error) {
+	switch strings.ToLower(c.RequiredAcks) {
+	case "", "none":
+		return kafka.RequireNone, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "all":
+		return kafka.RequireAll, nil
+	default:
+		return 0, fmt.Errorf("unknown kafka required acks: %q", c.RequiredAcks)
+	}
+}
+
+func (c KafkaWriterConfig) checkKey() error {
+	switch c.Key {
+	case "", kafkaKeyNone, kafkaKeyProjectID, kafkaKeyProfilerID:
+		return nil
+	default:
+		return fmt.Errorf("unknown kafka message key: %q", c.Key)
+	}
+}
+
+// key returns the key of a message, the project is used for messages
+// without a profiler.
+func (c KafkaWriterConfig) key(keys kafkaMessageKeys) []byte {
+	switch c.Key {
+	case kafkaKeyNone:
+		return nil
+	case kafkaKeyProfilerID:
+		if keys.ProfilerID != "" {
+			return []byte(keys.ProfilerID)
+		}
+	}
+	return []byte(strconv.FormatUint(keys.ProjectID, 10))
+}
+
+// kafkaWriterConfigs returns the settings of the writer of each profiling
+// topic, with defaults applied.
+func (c ServiceConfig) kafkaWriterConfigs() map[string]KafkaWriterConfig {
+	defaults := KafkaWriterConfig{
+		Brokers:     c.ProfilingKafkaBrokers,
+		Compression: "lz4",
+		BatchSize:   10,
+		BatchBytes:  20 * MiB,
+	}
+	withKey := func(key string) KafkaWriterConfig {
+		d := defaults
+		d.Key = key
+		return d
+	}
+	return map[string]KafkaWriterConfig{
+		c.CallTreesKafkaTopic:     c.CallTreesKafkaWriter.withDefaults(withKey(kafkaKeyProfilerID)),
+		c.ProfileChunksKafkaTopic: c.ProfileChunksKafkaWriter.withDefaults(withKey(kafkaKeyProfilerID)),
+		c.ProfilesKafkaTopic:      c.ProfilesKafkaWriter.withDefaults(withKey(kafkaKeyProjectID)),
+	}
+}
+
+// kafkaMessage builds a message for a profiling topic, keyed as configured
+// for it so messages sharing a key keep their order, and carrying the name and
+// version of the schema of its value.
+func (env *environment) kafkaMessage(
+	topic string,
+	name schema.Name,
+	value []byte,
+	keys kafkaMessageKeys,
+) kafka.Message {
+	return kafka.Message{
+		Topic:   topic,
+		Key:     env.profilingWriterConfigs[topic].key(keys),
+		Value:   value,
+		Headers: schema.Headers(name),
+	}
+}
+
+// functionsMessage builds a functions message, sent with the version of the
+// schema summarizing durations with sketches once they're enabled.
+func (env *environment) functionsMessage(value []byte, keys kafkaMessageKeys) kafka.Message {
+	m := env.kafkaMessage(env.config.CallTreesKafkaTopic, schema.Functions, value, keys)
+	if env.config.FunctionsDurationsSketches {
+		m.Headers = schema.VersionHeaders(schema.Functions, schema.FunctionsSketchesVersion)
+	}
+	return m
+}
+
+func newKafkaWriter(
+	config ServiceConfig,
+	topic string,
+	wc KafkaWriterConfig,
+	syncWrites bool,
+) (*kafka.Writer, error) {
+	compression, err := wc.compression()
+	if err != nil {
+		return nil, err
+	}
+	requiredAcks, err := wc.requiredAcks()
+	if err != nil {
+		return nil, err
+	}
+	// Synchronous writes are only useful once brokers acknowledged them.
+	if syncWrites && wc.RequiredAcks == "" {
+		requiredAcks = kafka.RequireAll
+	}
+	// Requests wait on synchronous writes, don't let them wait on the 1s
+	// batch timeout kafka-go defaults to.
+	linger := wc.Linger
+	if syncWrites && linger == 0 {
+		linger = syncWritesLinger
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(wc.Brokers...),
+		Async:        !syncWrites,
+		Balancer:     kafka.CRC32Balancer{},
+		BatchBytes:   wc.BatchBytes,
+		BatchSize:    wc.BatchSize,
+		BatchTimeout: linger,
+		Completion:   countKafkaDeliveries(topic),
+		Compression:  compression,
+		ReadTimeout:  3 * time.Second,
+		RequiredAcks: requiredAcks,
+		Topic:        topic,
+		WriteTimeout: 3 * time.Second,
+		Transport:    createKafkaRoundTripper(config),
+	}
+	kafkaWriterStats.add(w)
+	return w, nil
+}
+
+// WriteMessages groups messages per topic and sends them with the writer of
+// their topic, which sets the topic itself.
+func (tw topicWriters) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	var topics []string
+	perTopic := make(map[string][]kafka.Message)
+	for _, m := range msgs {
+		if _, exists := perTopic[m.Topic]; !exists {
+			topics = append(topics, m.Topic)
+		}
+		topic := m.Topic
+		m.Topic = ""
+		perTopic[topic] = append(perTopic[topic], m)
+	}
+	for _, topic := range topics {
+		w, exists := tw[topic]
+		if !exists {
+			return fmt.Errorf("no kafka writer for topic %q", topic)
+		}
+		err := w.WriteMessages(ctx, perTopic[topic]...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw topicWriters) Close() error {
+	var errs []error
+	for _, w := range tw {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
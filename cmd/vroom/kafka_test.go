@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/ilyakaznacheev/cleanenv"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/schema"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestKafkaWriterConfigs(t *testing.T) {
+	t.Setenv("SENTRY_KAFKA_WRITER_PROFILE_CHUNKS_BROKERS", "chunks:9092")
+	t.Setenv("SENTRY_KAFKA_WRITER_PROFILE_CHUNKS_COMPRESSION", "zstd")
+	t.Setenv("SENTRY_KAFKA_WRITER_PROFILES_KEY", "none")
+
+	var config ServiceConfig
+	err := cleanenv.ReadEnv(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := config.kafkaWriterConfigs()
+
+	want := KafkaWriterConfig{
+		Brokers:     []string{"chunks:9092"},
+		Compression: "zstd",
+		BatchSize:   10,
+		BatchBytes:  20 * MiB,
+		Key:         kafkaKeyProfilerID,
+	}
+	if diff := testutil.Diff(configs[config.ProfileChunksKafkaTopic], want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+	want = KafkaWriterConfig{
+		Brokers:     config.ProfilingKafkaBrokers,
+		Compression: "lz4",
+		BatchSize:   10,
+		BatchBytes:  20 * MiB,
+		Key:         kafkaKeyNone,
+	}
+	if diff := testutil.Diff(configs[config.ProfilesKafkaTopic], want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
+
+func TestKafkaWriterConfigKey(t *testing.T) {
+	keys := kafkaMessageKeys{ProjectID: 42, ProfilerID: "profiler"}
+	tests := []struct {
+		name string
+		key  string
+		keys kafkaMessageKeys
+		want []byte
+	}{
+		{name: "none", key: kafkaKeyNone, keys: keys, want: nil},
+		{name: "project", key: kafkaKeyProjectID, keys: keys, want: []byte("42")},
+		{name: "profiler", key: kafkaKeyProfilerID, keys: keys, want: []byte("profiler")},
+		{
+			name: "profiler falls back to project",
+			key:  kafkaKeyProfilerID,
+			keys: kafkaMessageKeys{ProjectID: 42},
+			want: []byte("42"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := KafkaWriterConfig{Key: test.key}.key(test.keys)
+			if diff := testutil.Diff(got, test.want); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewKafkaWriterRejectsUnknownSettings(t *testing.T) {
+	for _, wc := range []KafkaWriterConfig{
+		{Compression: "brotli"},
+		{RequiredAcks: "some"},
+	} {
+		if _, err := newKafkaWriter(ServiceConfig{}, "topic", wc, false); err == nil {
+			t.Fatalf("expected an error for %+v", wc)
+		}
+	}
+}
+
+func TestNewKafkaWriterSyncWritesLinger(t *testing.T) {
+	tests := []struct {
+		name       string
+		wc         KafkaWriterConfig
+		syncWrites bool
+		want       time.Duration
+	}{
+		{name: "async", wc: KafkaWriterConfig{}, want: 0},
+		{name: "sync", wc: KafkaWriterConfig{}, syncWrites: true, want: syncWritesLinger},
+		{name: "sync with linger", wc: KafkaWriterConfig{Linger: time.Second}, syncWrites: true, want: time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := newKafkaWriter(ServiceConfig{}, "topic", test.wc, test.syncWrites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.BatchTimeout != test.want {
+				t.Fatalf("expected a batch timeout of %s, got %s", test.want, w.BatchTimeout)
+			}
+		})
+	}
+}
+
+func TestNewEnvironmentRejectsUnknownKey(t *testing.T) {
+	t.Setenv("SENTRY_BUCKET_PROFILES", "file://"+t.TempDir())
+	t.Setenv("SENTRY_KAFKA_WRITER_PROFILE_CHUNKS_KEY", "profile_id")
+	_, err := newEnvironment(false)
+	if err == nil {
+		t.Fatal("expected an error for an unknown message key")
+	}
+}
+
+func TestTopicWriters(t *testing.T) {
+	chunks := &kafkaWriterRecorder{}
+	profiles := &kafkaWriterRecorder{}
+	tw := topicWriters{
+		"chunks":   chunks,
+		"profiles": profiles,
+	}
+
+	err := tw.WriteMessages(
+		context.Background(),
+		kafka.Message{Topic: "chunks", Value: []byte("1")},
+		kafka.Message{Topic: "profiles", Value: []byte("2")},
+		kafka.Message{Topic: "chunks", Value: []byte("3")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(chunks.messages, []kafka.Message{{Value: []byte("1")}, {Value: []byte("3")}}); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+	if diff := testutil.Diff(profiles.messages, []kafka.Message{{Value: []byte("2")}}); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+
+	err = tw.WriteMessages(context.Background(), kafka.Message{Topic: "unknown"})
+	if err == nil {
+		t.Fatal("expected an error for a topic without a writer")
+	}
+}
+
+func TestProducedMessagesMatchSchemas(t *testing.T) {
+	profileBody, err := os.ReadFile("../../test/data/node.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only sampled profiles produce a profile message.
+	profileBody = bytes.Replace(profileBody, []byte("{"), []byte(`{"sampled":true,`), 1)
+	tests := []struct {
+		name     string
+		ingest   func(*environment, context.Context, []byte, ingestOptions) error
+		body     []byte
+		sketches bool
+		schemas  []schema.Name
+	}{
+		{
+			name:    "profile",
+			ingest:  (*environment).ingestProfile,
+			body:    profileBody,
+			schemas: []schema.Name{schema.Profile, schema.Functions},
+		},
+		{
+			name:    "chunk",
+			ingest:  (*environment).ingestChunk,
+			body:    consumerTestChunk(t),
+			schemas: []schema.Name{schema.ProfileChunk, schema.Functions},
+		},
+		{
+			name:     "profile with sketches",
+			ingest:   (*environment).ingestProfile,
+			body:     profileBody,
+			sketches: true,
+			schemas:  []schema.Name{schema.Profile, schema.Functions},
+		},
+		{
+			name:     "chunk with sketches",
+			ingest:   (*environment).ingestChunk,
+			body:     consumerTestChunk(t),
+			sketches: true,
+			schemas:  []schema.Name{schema.ProfileChunk, schema.Functions},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &kafkaWriterRecorder{}
+			env := &environment{
+				storage:           fileBlobBucket,
+				profilingWriter:   writer,
+				occurrencesWriter: &kafkaWriterRecorder{},
+				config: ServiceConfig{
+					CallTreesKafkaTopic:        "profiles-call-tree",
+					ProfileChunksKafkaTopic:    "snuba-profile-chunks",
+					ProfilesKafkaTopic:         "processed-profiles",
+					FunctionsDurationsSketches: test.sketches,
+					FunctionsThreadMetrics:     test.sketches,
+				},
+			}
+			ctx := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone())
+			err := test.ingest(env, ctx, test.body, ingestOptions{AcceptStored: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []schema.Name
+			for _, m := range writer.messages {
+				name, version, ok := schema.FromHeaders(m.Headers)
+				if !ok {
+					t.Fatalf("expected a schema header on message for topic %q", m.Topic)
+				}
+				if name == schema.Functions && test.sketches && version != schema.FunctionsSketchesVersion {
+					t.Fatalf("expected functions with sketches to be sent with version %d, got: %d", schema.FunctionsSketchesVersion, version)
+				}
+				if err := schema.Validate(name, version, m.Value); err != nil {
+					t.Fatalf("invalid %s message: %v", name, err)
+				}
+				names = append(names, name)
+			}
+			if diff := testutil.Diff(names, test.schemas); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidatingWriter(t *testing.T) {
+	recorder := &kafkaWriterRecorder{}
+	w := withValidation(recorder, 1)
+	invalid := kafka.Message{
+		Topic:   "test-validating-writer",
+		Value:   []byte(`{"profile_id":"a"}`),
+		Headers: schema.Headers(schema.Profile),
+	}
+
+	err := w.WriteMessages(context.Background(), invalid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.messages) != 1 {
+		t.Fatal("expected invalid messages to be written anyway")
+	}
+	if v := promtestutil.ToFloat64(kafkaMessagesInvalid.WithLabelValues(string(schema.Profile))); v != 1 {
+		t.Fatalf("expected 1 invalid message, got: %v", v)
+	}
+	if withValidation(recorder, 0) != KafkaWriter(recorder) {
+		t.Fatal("expected no validation with a sample rate of 0")
+	}
+}
+
+func TestFunctionsThreadMetrics(t *testing.T) {
+	body, err := json.Marshal(chunk.SampleChunk{
+		ID:             uuid.New().String(),
+		ProfilerID:     uuid.New().String(),
+		Platform:       platform.Python,
+		OrganizationID: 1,
+		ProjectID:      1,
+		Version:        "2",
+		Profile: chunk.SampleData{
+			Frames: []frame.Frame{
+				{Function: "main", InApp: &testutil.True, Platform: platform.Python},
+				{Function: "work", InApp: &testutil.True, Platform: platform.Python},
+			},
+			Stacks: [][]int{{1, 0}},
+			Samples: []chunk.Sample{
+				{StackID: 0, Timestamp: 1.0},
+				{StackID: 0, Timestamp: 1.01},
+				{StackID: 0, Timestamp: 1.02},
+			},
+		},
+		Measurements: json.RawMessage("null"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enabled := range []bool{false, true} {
+		writer := &kafkaWriterRecorder{}
+		env := &environment{
+			storage:           fileBlobBucket,
+			profilingWriter:   writer,
+			occurrencesWriter: &kafkaWriterRecorder{},
+			config: ServiceConfig{
+				CallTreesKafkaTopic:     "profiles-call-tree",
+				ProfileChunksKafkaTopic: "snuba-profile-chunks",
+				FunctionsThreadMetrics:  enabled,
+			},
+		}
+		ctx := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone())
+		err := env.ingestChunk(ctx, body, ingestOptions{AcceptStored: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m struct {
+			Functions []struct {
+				Function string                     `json:"function"`
+				Threads  map[string]json.RawMessage `json:"threads"`
+			} `json:"functions"`
+		}
+		for _, message := range writer.messages {
+			if message.Topic == env.config.CallTreesKafkaTopic {
+				err = json.Unmarshal(message.Value, &m)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if len(m.Functions) == 0 {
+			t.Fatal("expected functions to be sent")
+		}
+		for _, f := range m.Functions {
+			if sent := len(f.Threads) > 0; sent != enabled {
+				t.Fatalf("expected thread metrics sent: %v, got: %v for %s", enabled, sent, f.Function)
+			}
+		}
+	}
+}
+
+func TestAddDurationsSketches(t *testing.T) {
+	functions := []nodetree.CallTreeFunction{
+		{
+			DurationsNS: []uint64{10, 20, 30},
+			Threads: map[string]nodetree.FunctionThreadMetrics{
+				"main":     {DurationsNS: []uint64{10, 20}},
+				"worker-*": {DurationsNS: []uint64{30}},
+			},
+		},
+	}
+	addDurationsSketches(functions)
+
+	f := functions[0]
+	if got := f.Durations.Count(); got != 3 {
+		t.Fatalf("expected 3 durations in the sketch, got: %d", got)
+	}
+	for name, want := range map[string]uint64{"main": 2, "worker-*": 1} {
+		if got := f.Threads[name].Durations.Count(); got != want {
+			t.Fatalf("expected %d durations in the sketch of %s, got: %d", want, name, got)
+		}
+	}
+	if f.DurationsNS != nil || f.Threads["main"].DurationsNS != nil {
+		t.Fatal("expected the durations to be replaced by sketches")
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,7 +19,6 @@ import (
 	sentryhttp "github.com/getsentry/sentry-go/http"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/julienschmidt/httprouter"
-	"github.com/segmentio/kafka-go"
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/azureblob"
 	_ "gocloud.dev/blob/fileblob"
@@ -26,21 +28,36 @@ import (
 
 	"github.com/getsentry/vroom/internal/httputil"
 	"github.com/getsentry/vroom/internal/logutil"
+	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/regression"
 	"github.com/getsentry/vroom/internal/storageutil"
 )
 
 type environment struct {
 	config ServiceConfig
 
-	occurrencesWriter KafkaWriter
-	profilingWriter   KafkaWriter
+	occurrenceOptions      occurrence.Options
+	occurrencesWriter      KafkaWriter
+	occurrencesRateLimiter *occurrence.RateLimiter
+	profilingWriter        KafkaWriter
+	// profilingWriterConfigs holds the settings of the writer of each
+	// profiling topic, messages of other topics are keyed by project.
+	profilingWriterConfigs map[string]KafkaWriterConfig
+	regressionDetector     *regression.Detector
+
+	// sink replaces Kafka for every topic when set.
+	sink KafkaWriter
 
 	storage *blob.Bucket
+
+	// outboxAttempts counts, per outbox entry, the passes it failed to be
+	// published in because of the entry itself.
+	outboxAttempts map[string]int
 }
 
 var (
-	release  string
-	readJobs chan storageutil.ReadJob
+	release       string
+	readScheduler *storageutil.ReadScheduler
 )
 
 const (
@@ -48,12 +65,19 @@ const (
 	MiB       = 1024 * KiB
 )
 
-func newEnvironment() (*environment, error) {
+// newEnvironment sets up the environment. With syncWrites, or if configured,
+// writing to Kafka only returns once brokers acknowledged the messages. The
+// outbox always needs profiling messages to be written that way.
+func newEnvironment(syncWrites bool) (*environment, error) {
 	var e environment
 	err := cleanenv.ReadEnv(&e.config)
 	if err != nil {
 		return nil, err
 	}
+	syncWrites = syncWrites || e.config.KafkaSyncDelivery
+	if len(e.config.SpansKafkaBrokers) > 0 {
+		slog.Warn("SENTRY_KAFKA_BROKERS_SPANS is deprecated and ignored, set the brokers of each topic instead")
+	}
 
 	ctx := context.Background()
 	e.storage, err = blob.OpenBucket(ctx, e.config.BucketURL)
@@ -61,30 +85,59 @@ func newEnvironment() (*environment, error) {
 		return nil, err
 	}
 
-	e.occurrencesWriter = &kafka.Writer{
-		Addr:         kafka.TCP(e.config.OccurrencesKafkaBrokers...),
-		Async:        true,
-		Balancer:     kafka.CRC32Balancer{},
-		BatchSize:    100,
-		ReadTimeout:  3 * time.Second,
-		Topic:        e.config.OccurrencesKafkaTopic,
-		WriteTimeout: 3 * time.Second,
-		Transport:    createKafkaRoundTripper(e.config),
-	}
-	e.profilingWriter = &kafka.Writer{
-		Addr:         kafka.TCP(e.config.ProfilingKafkaBrokers...),
-		Async:        true,
-		Balancer:     kafka.CRC32Balancer{},
-		BatchBytes:   20 * MiB,
-		BatchSize:    10,
-		Compression:  kafka.Lz4,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		Transport:    createKafkaRoundTripper(e.config),
+	e.sink, err = newMessageSink(e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	e.occurrencesWriter, err = e.newMessageWriter(
+		e.config.OccurrencesKafkaTopic,
+		e.config.OccurrencesKafkaWriter.withDefaults(KafkaWriterConfig{
+			Brokers:   e.config.OccurrencesKafkaBrokers,
+			BatchSize: 100,
+		}),
+		syncWrites,
+	)
+	if err != nil {
+		return nil, err
 	}
+	e.occurrenceOptions = e.config.occurrenceOptions()
+	e.occurrencesRateLimiter = occurrence.NewRateLimiter(
+		e.config.OccurrencesRateLimit,
+		e.config.OccurrencesRateLimitWindow,
+	)
+	if e.config.FunctionRegressionsEnabled {
+		e.regressionDetector = regression.NewDetector(regression.DefaultOptions())
+	}
+	// Outbox entries are cleared once their messages were written, which
+	// only means they were delivered if brokers acknowledged the writes.
+	profilingSyncWrites := syncWrites || e.outboxEnabled()
+	profilingWriters := make(topicWriters)
+	e.profilingWriterConfigs = e.config.kafkaWriterConfigs()
+	for topic, wc := range e.profilingWriterConfigs {
+		err = wc.checkKey()
+		if err != nil {
+			return nil, err
+		}
+		if e.outboxEnabled() && e.sink == nil && strings.EqualFold(wc.RequiredAcks, "none") {
+			return nil, fmt.Errorf("the outbox needs acknowledged writes but required acks are none for topic %q", topic)
+		}
+		profilingWriters[topic], err = e.newMessageWriter(topic, wc, profilingSyncWrites)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.profilingWriter = withValidation(profilingWriters, e.config.KafkaMessagesValidationSampleRate)
+	e.occurrencesWriter = withValidation(e.occurrencesWriter, e.config.KafkaMessagesValidationSampleRate)
 	return &e, nil
 }
 
+// readQueue returns a queue for the read jobs of a request, limited to the
+// number of jobs a request can have on the read pool at once.
+func (e *environment) readQueue(priority storageutil.Priority) *storageutil.ReadQueue {
+	return readScheduler.Queue(priority, e.config.ReadPoolMaxJobsPerRequest)
+}
+
 func (e *environment) shutdown() {
 	err := e.storage.Close()
 	if err != nil {
@@ -98,6 +151,12 @@ func (e *environment) shutdown() {
 	if err != nil {
 		sentry.CaptureException(err)
 	}
+	if e.sink != nil {
+		err = e.sink.Close()
+		if err != nil {
+			sentry.CaptureException(err)
+		}
+	}
 	sentry.Flush(5 * time.Second)
 }
 
@@ -137,18 +196,50 @@ func (e *environment) newRouter() (*httprouter.Router, error) {
 			"/organizations/:organization_id/flamegraph",
 			e.postFlamegraph,
 		},
+		{
+			http.MethodPost,
+			"/organizations/:organization_id/functions",
+			e.postFunctions,
+		},
+		{
+			http.MethodPost,
+			"/organizations/:organization_id/functions/compare",
+			e.postFunctionsComparison,
+		},
+		{
+			http.MethodPost,
+			"/organizations/:organization_id/functions/callgraph",
+			e.postFunctionCallGraph,
+		},
 		{http.MethodGet, "/health", e.getHealth},
 		{http.MethodPost, "/chunk", e.postChunk},
 		{http.MethodPost, "/profile", e.postProfile},
 		{http.MethodPost, "/regressed", e.postRegressed},
+		{http.MethodGet, "/metrics", metricsHandler().ServeHTTP},
+	}
+
+	// Ingestion routes have their own limit, profiles and chunks are larger
+	// than any other payload.
+	maxBodySizes := map[string]int64{
+		"/chunk":   e.config.ChunkMaxBodySize,
+		"/profile": e.config.ProfileMaxBodySize,
 	}
 
 	router := httprouter.New()
 
 	for _, route := range routes {
 		handlerFunc := httputil.AnonymizeTransactionName(route.handler)
+		// Only POST routes read a body.
+		if route.method == http.MethodPost {
+			maxBodySize, exists := maxBodySizes[route.path]
+			if !exists {
+				maxBodySize = e.config.MaxBodySize
+			}
+			handlerFunc = httputil.LimitBody(handlerFunc, maxBodySize)
+		}
 		handlerFunc = httputil.DecompressPayload(handlerFunc)
 		handler := compress(handlerFunc)
+		handler = instrumentRoute(route.path, handler)
 
 		router.Handler(route.method, route.path, handler)
 	}
@@ -157,9 +248,21 @@ func (e *environment) newRouter() (*httprouter.Router, error) {
 }
 
 func main() {
+	consumerMode := flag.Bool(
+		"consumer",
+		false,
+		"ingest profiles and chunks from Kafka instead of serving HTTP requests",
+	)
+	replayMode := flag.Bool(
+		"replay-dead-letters",
+		false,
+		"run the stored dead letters through the ingestion pipeline again and exit",
+	)
+	flag.Parse()
+
 	logutil.ConfigureLogger()
 
-	env, err := newEnvironment()
+	env, err := newEnvironment(*consumerMode || *replayMode)
 	if err != nil {
 		log.Fatal("error setting up environment", err)
 	}
@@ -189,6 +292,57 @@ func main() {
 		log.Fatal("can't initialize sentry", err)
 	}
 
+	slog.Info("vroom started")
+
+	registerMetrics()
+
+	readScheduler = storageutil.NewReadScheduler(env.config.WorkerPoolSize, 10*env.config.WorkerPoolSize)
+
+	regressionsCtx, stopRegressions := context.WithCancel(context.Background())
+	var regressionsWG sync.WaitGroup
+	if env.regressionDetector != nil {
+		regressionsWG.Add(1)
+		go func() {
+			defer regressionsWG.Done()
+			env.detectRegressions(regressionsCtx)
+		}()
+	}
+
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	var outboxWG sync.WaitGroup
+	if env.outboxEnabled() {
+		outboxWG.Add(1)
+		go func() {
+			defer outboxWG.Done()
+			env.runOutboxPublisher(outboxCtx)
+		}()
+	}
+
+	switch {
+	case *replayMode:
+		env.replay()
+	case *consumerMode:
+		env.consume()
+	default:
+		env.serve()
+	}
+
+	// Stop looking for regressions before we stop the read workers
+	stopRegressions()
+	regressionsWG.Wait()
+
+	// Stop publishing the outbox before we close the Kafka writers
+	stopOutbox()
+	outboxWG.Wait()
+
+	// Shutdown the rest of the environment once we stopped ingesting
+	readScheduler.Close()
+	env.shutdown()
+	slog.Info("vroom graceful shutdown")
+}
+
+// serve handles HTTP requests until the process is asked to stop.
+func (env *environment) serve() {
 	router, err := env.newRouter()
 	if err != nil {
 		sentry.CaptureException(err)
@@ -218,13 +372,6 @@ func main() {
 		close(waitForShutdown)
 	}()
 
-	slog.Info("vroom started")
-
-	readJobs = make(chan storageutil.ReadJob, 10*env.config.WorkerPoolSize)
-	for i := 0; i < env.config.WorkerPoolSize; i++ {
-		go storageutil.ReadWorker(readJobs)
-	}
-
 	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		sentry.CaptureException(err)
@@ -232,11 +379,40 @@ func main() {
 	}
 
 	<-waitForShutdown
+}
 
-	// Shutdown the rest of the environment after the HTTP connections are closed
-	close(readJobs)
-	env.shutdown()
-	slog.Info("vroom graceful shutdown")
+// replay runs the stored dead letters through the ingestion pipeline again.
+func (env *environment) replay() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	replayed, failed, err := env.replayDeadLetters(ctx)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error("dead letters replay failed", "err", err)
+	}
+	slog.Info("dead letters replayed", "replayed", replayed, "failed", failed)
+}
+
+// consume ingests messages from Kafka until the process is asked to stop or
+// the consumer fails.
+func (env *environment) consume() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c, err := env.newConsumer()
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error("consumer failed to start", "err", err)
+		return
+	}
+	defer c.close()
+
+	err = c.run(ctx)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error("consumer failed", "err", err)
+	}
 }
 
 func (e *environment) getHealth(w http.ResponseWriter, _ *http.Request) {
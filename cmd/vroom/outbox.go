@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/segmentio/kafka-go"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+type (
+	// outboxEntry holds the Kafka messages to send once an object is
+	// stored. It's written before the object and deleted once the messages
+	// were delivered, so messages are never lost when Kafka is unreachable.
+	outboxEntry struct {
+		Messages []outboxMessage `json:"messages"`
+	}
+
+	// outboxEntryError is a failure to publish an entry caused by the entry
+	// itself, which won't go away by trying again.
+	outboxEntryError struct {
+		err error
+	}
+
+	// outboxLease marks the publishing pass of an interval as taken.
+	outboxLease struct {
+		Acquired time.Time `json:"acquired"`
+	}
+
+	outboxMessage struct {
+		Topic   string         `json:"topic"`
+		Key     []byte         `json:"key,omitempty"`
+		Value   []byte         `json:"value"`
+		Headers []kafka.Header `json:"headers,omitempty"`
+	}
+)
+
+// outboxMaxAttempts is the number of passes an entry can fail to be published
+// in before it's moved aside.
+const outboxMaxAttempts = 5
+
+func (e *outboxEntryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *outboxEntryError) Unwrap() error {
+	return e.err
+}
+
+func newOutboxEntry(messages []kafka.Message) outboxEntry {
+	e := outboxEntry{Messages: make([]outboxMessage, 0, len(messages))}
+	for _, m := range messages {
+		e.Messages = append(e.Messages, outboxMessage{
+			Topic:   m.Topic,
+			Key:     m.Key,
+			Value:   m.Value,
+			Headers: m.Headers,
+		})
+	}
+	return e
+}
+
+func (e outboxEntry) KafkaMessages() []kafka.Message {
+	messages := make([]kafka.Message, 0, len(e.Messages))
+	for _, m := range e.Messages {
+		messages = append(messages, kafka.Message{
+			Topic:   m.Topic,
+			Key:     m.Key,
+			Value:   m.Value,
+			Headers: m.Headers,
+		})
+	}
+	return messages
+}
+
+func (env *environment) outboxEnabled() bool {
+	return env.config.OutboxPrefix != ""
+}
+
+func (env *environment) outboxPath(objectName string) string {
+	return path.Join(env.config.OutboxPrefix, objectName)
+}
+
+// store writes an object along with the messages to send once it's stored,
+// and reports whether this call wrote it. An object already stored is not
+// reported as a duplicate while messages for it are still pending, so a retry
+// sends them again.
+func (env *environment) store(
+	ctx context.Context,
+	hub *sentry.Hub,
+	objectName string,
+	d interface{},
+	messages []kafka.Message,
+	opts ingestOptions,
+) (bool, error) {
+	// pending is set when messages of a previous attempt are still waiting
+	// to be delivered.
+	var pending bool
+	if env.outboxEnabled() {
+		s := sentry.StartSpan(ctx, "gcs.write")
+		s.Description = "Write outbox entry to GCS"
+		err := storageutil.CompressedWrite(ctx, env.storage, env.outboxPath(objectName), newOutboxEntry(messages))
+		s.Finish()
+		if err != nil {
+			if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+				return false, storageWriteError(hub, err, opts)
+			}
+			pending = true
+		}
+	}
+
+	s := sentry.StartSpan(ctx, "gcs.write")
+	s.Description = "Write profile to GCS"
+	err := storageutil.CompressedWrite(ctx, env.storage, objectName, d)
+	s.Finish()
+	if err == nil {
+		return true, nil
+	}
+	if pending && gcerrors.Code(err) == gcerrors.FailedPrecondition {
+		return false, nil
+	}
+	err = storageWriteError(hub, err, opts)
+	if err != nil && env.outboxEnabled() && !pending {
+		// The object wasn't stored by this call, its messages shouldn't be sent.
+		env.clearOutbox(ctx, hub, objectName)
+	}
+	return false, err
+}
+
+// publish sends the messages of a stored object downstream and clears its
+// outbox entry once they were delivered. Without an outbox, the object is
+// removed if they couldn't be, so a retry isn't rejected as a duplicate.
+func (env *environment) publish(
+	ctx context.Context,
+	hub *sentry.Hub,
+	objectName string,
+	stored bool,
+	messages []kafka.Message,
+) error {
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Send messages to Kafka"
+	err := env.profilingWriter.WriteMessages(ctx, messages...)
+	s.Finish()
+	if err != nil {
+		if hub != nil {
+			hub.CaptureException(err)
+		}
+		if stored && !env.outboxEnabled() {
+			env.removeStored(ctx, hub, objectName)
+		}
+		return newIngestError(ingestErrorPublish, ingestStagePublish, err)
+	}
+	if objectName != "" && env.outboxEnabled() {
+		env.clearOutbox(ctx, hub, objectName)
+	}
+	return nil
+}
+
+// publishFunctions sends the functions messages of a profile or a chunk. They
+// only feed the functions aggregations so, like before the outbox, they're
+// sent once the object was published and a failure is reported without
+// failing the ingestion.
+func (env *environment) publishFunctions(ctx context.Context, hub *sentry.Hub, messages []kafka.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Send functions to Kafka"
+	err := env.profilingWriter.WriteMessages(ctx, messages...)
+	s.Finish()
+	if err != nil && hub != nil {
+		hub.CaptureException(err)
+	}
+}
+
+func (env *environment) clearOutbox(ctx context.Context, hub *sentry.Hub, objectName string) {
+	err := env.storage.Delete(ctx, env.outboxPath(objectName))
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound && hub != nil {
+		hub.CaptureException(err)
+	}
+}
+
+// publishPending sends the messages of outbox entries older than minAge,
+// which were left behind by requests failing to deliver them. Entries whose
+// object was never stored are dropped. An entry failing to be published is
+// reported and skipped, and it's moved aside once it failed because of its
+// own content in outboxMaxAttempts passes.
+func (env *environment) publishPending(ctx context.Context, minAge time.Duration) (int, error) {
+	var published int
+	attempts := make(map[string]int)
+	prefix := env.config.OutboxPrefix + "/"
+	it := env.storage.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return published, err
+		}
+		if obj.IsDir || time.Since(obj.ModTime) < minAge {
+			continue
+		}
+		objectName := strings.TrimPrefix(obj.Key, prefix)
+		sent, err := env.publishOutboxEntry(ctx, obj.Key, objectName)
+		if sent {
+			published++
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return published, ctx.Err()
+		}
+		sentry.CaptureException(err)
+		slog.Error("error publishing outbox entry", "key", obj.Key, "err", err)
+		var ee *outboxEntryError
+		if !errors.As(err, &ee) {
+			if n, exists := env.outboxAttempts[obj.Key]; exists {
+				attempts[obj.Key] = n
+			}
+			continue
+		}
+		attempts[obj.Key] = env.outboxAttempts[obj.Key] + 1
+		if attempts[obj.Key] >= outboxMaxAttempts {
+			env.moveOutboxEntryAside(ctx, obj.Key, objectName)
+			delete(attempts, obj.Key)
+		}
+	}
+	env.outboxAttempts = attempts
+	return published, nil
+}
+
+// publishOutboxEntry sends the messages of an outbox entry and deletes it.
+// It reports whether the messages were sent, even if the entry couldn't be
+// deleted after.
+func (env *environment) publishOutboxEntry(ctx context.Context, key, objectName string) (bool, error) {
+	stored, err := env.storage.Exists(ctx, objectName)
+	if err != nil {
+		return false, err
+	}
+	if !stored {
+		return false, env.storage.Delete(ctx, key)
+	}
+	var e outboxEntry
+	err = storageutil.UnmarshalCompressed(ctx, env.storage, key, &e)
+	if err != nil {
+		if errors.Is(err, storageutil.ErrObjectNotFound) {
+			// The request storing the object delivered its messages.
+			return false, nil
+		}
+		if gcerrors.Code(err) == gcerrors.Unknown && ctx.Err() == nil {
+			// The entry was read but can't be decoded.
+			return false, &outboxEntryError{err: err}
+		}
+		return false, err
+	}
+	err = env.profilingWriter.WriteMessages(ctx, e.KafkaMessages()...)
+	if err != nil {
+		if !isTransientPublishError(err) {
+			return false, &outboxEntryError{err: err}
+		}
+		return false, err
+	}
+	return true, env.storage.Delete(ctx, key)
+}
+
+// moveOutboxEntryAside keeps an entry we failed to publish too many times
+// under the poisoned prefix, out of the way of the next passes.
+func (env *environment) moveOutboxEntryAside(ctx context.Context, key, objectName string) {
+	dst := env.poisonedOutboxPath(objectName)
+	err := env.storage.Copy(ctx, dst, key, nil)
+	if err == nil {
+		err = env.storage.Delete(ctx, key)
+	}
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error("error moving outbox entry aside", "key", key, "err", err)
+		return
+	}
+	slog.Warn("outbox entry moved aside", "key", key, "destination", dst)
+}
+
+func (env *environment) poisonedOutboxPath(objectName string) string {
+	return path.Join(env.config.OutboxPrefix+"-poisoned", objectName)
+}
+
+// isTransientPublishError reports whether a failure to write messages is
+// likely caused by Kafka being unreachable rather than by the messages.
+func isTransientPublishError(err error) bool {
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, e := range writeErrors {
+			if e != nil && isTransientPublishError(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	var kafkaErr kafka.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &kafkaErr):
+		return kafkaErr.Temporary()
+	}
+	return false
+}
+
+// acquireOutboxLease claims the publishing pass of the current interval so
+// only one replica publishes pending entries at a time. Exclusion relies on
+// conditional writes, which only GCS enforces, and a pass can outlast its
+// interval, so messages are delivered at least once: consumers have to
+// tolerate the same message being sent again, like they do when a request
+// is retried.
+func (env *environment) acquireOutboxLease(ctx context.Context, now time.Time) (bool, error) {
+	prefix := env.config.OutboxPrefix + "-leases/"
+	slot := strconv.FormatInt(now.Truncate(env.config.OutboxPublishInterval).Unix(), 10)
+	key := prefix + slot
+	exists, err := env.storage.Exists(ctx, key)
+	if err != nil || exists {
+		return false, err
+	}
+	err = storageutil.CompressedWrite(ctx, env.storage, key, outboxLease{Acquired: now.UTC()})
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.FailedPrecondition {
+			return false, nil
+		}
+		return false, err
+	}
+	// Leases of previous passes aren't needed anymore.
+	it := env.storage.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := it.Next(ctx)
+		if err != nil {
+			break
+		}
+		if obj.Key == key {
+			continue
+		}
+		err = env.storage.Delete(ctx, obj.Key)
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			slog.Warn("error deleting outbox lease", "key", obj.Key, "err", err)
+		}
+	}
+	return true, nil
+}
+
+// runOutboxPublisher publishes pending outbox entries periodically until
+// the context is canceled.
+func (env *environment) runOutboxPublisher(ctx context.Context) {
+	t := time.NewTicker(env.config.OutboxPublishInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			acquired, err := env.acquireOutboxLease(ctx, time.Now())
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				sentry.CaptureException(err)
+				slog.Error("error acquiring outbox lease", "err", err)
+				continue
+			}
+			if !acquired {
+				continue
+			}
+			published, err := env.publishPending(ctx, env.config.OutboxPublishInterval)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				sentry.CaptureException(err)
+				slog.Error("error publishing outbox entries", "err", err)
+			}
+			if published > 0 {
+				slog.Info("outbox entries published", "published", published)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+func TestPostChunkKeepsPendingMessagesInOutbox(t *testing.T) {
+	ctx := context.Background()
+	body := consumerTestChunk(t)
+	c := chunk.New(new(chunk.SampleChunk))
+	if err := c.UnmarshalJSON(body); err != nil {
+		t.Fatal(err)
+	}
+	writer := &kafkaWriterRecorder{err: errors.New("write failed")}
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: writer,
+		config: ServiceConfig{
+			CallTreesKafkaTopic:     "profiles-call-tree",
+			ProfileChunksKafkaTopic: "snuba-profile-chunks",
+			OutboxPrefix:            "outbox-pending",
+		},
+	}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	env.postChunk(w, req)
+	if code := w.Result().StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code 503. Found: %d", code)
+	}
+	for _, objectName := range []string{c.StoragePath(), env.outboxPath(c.StoragePath())} {
+		exists, err := fileBlobBucket.Exists(ctx, objectName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("expected %s to be stored", objectName)
+		}
+	}
+
+	// Once Kafka is reachable again, the publisher sends pending messages.
+	writer.err = nil
+	published, err := env.publishPending(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != 1 {
+		t.Fatalf("expected 1 outbox entry published, got: %d", published)
+	}
+	if len(writer.messages) != 1 || writer.messages[0].Topic != env.config.ProfileChunksKafkaTopic {
+		t.Fatalf("unexpected messages: %v", writer.messages)
+	}
+	exists, err := fileBlobBucket.Exists(ctx, env.outboxPath(c.StoragePath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the outbox entry to be cleared")
+	}
+}
+
+func TestPublishPendingDropsEntriesWithoutObject(t *testing.T) {
+	ctx := context.Background()
+	writer := &kafkaWriterRecorder{}
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: writer,
+		config: ServiceConfig{
+			OutboxPrefix: "outbox-orphan",
+		},
+	}
+	entry := newOutboxEntry([]kafka.Message{{Topic: "snuba-profile-chunks", Value: []byte("{}")}})
+	err := storageutil.CompressedWrite(ctx, fileBlobBucket, env.outboxPath("1/1/missing/chunk"), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := env.publishPending(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != 0 || writer.calls != 0 {
+		t.Fatalf("expected no message to be published, got: %d", writer.calls)
+	}
+	exists, err := fileBlobBucket.Exists(ctx, env.outboxPath("1/1/missing/chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the outbox entry to be dropped")
+	}
+}
+
+// storeOutboxTestEntry stores an object along with an outbox entry holding a
+// message for a topic.
+func storeOutboxTestEntry(t *testing.T, env *environment, objectName, topic string) {
+	ctx := context.Background()
+	err := storageutil.CompressedWrite(ctx, fileBlobBucket, objectName, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := newOutboxEntry([]kafka.Message{{Topic: topic, Value: []byte("{}")}})
+	err = storageutil.CompressedWrite(ctx, fileBlobBucket, env.outboxPath(objectName), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishPendingMovesPoisonedEntriesAside(t *testing.T) {
+	ctx := context.Background()
+	writer := &topicFailingWriter{topic: "too-large"}
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: writer,
+		config: ServiceConfig{
+			OutboxPrefix: "outbox-poisoned-test",
+		},
+	}
+	storeOutboxTestEntry(t, &env, "1/1/poisoned/chunk", "too-large")
+	storeOutboxTestEntry(t, &env, "1/1/valid/chunk", "snuba-profile-chunks")
+
+	for i := 0; i < outboxMaxAttempts; i++ {
+		published, err := env.publishPending(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The failing entry doesn't prevent the next ones to be published.
+		if i == 0 && published != 1 {
+			t.Fatalf("expected 1 outbox entry published, got: %d", published)
+		}
+		exists, err := fileBlobBucket.Exists(ctx, env.outboxPath("1/1/poisoned/chunk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < outboxMaxAttempts-1; exists != want {
+			t.Fatalf("expected the entry in the outbox to be %v after %d attempts, got: %v", want, i+1, exists)
+		}
+	}
+	exists, err := fileBlobBucket.Exists(ctx, env.poisonedOutboxPath("1/1/poisoned/chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the entry to be moved aside")
+	}
+}
+
+func TestPublishPendingKeepsEntriesWhileKafkaIsUnreachable(t *testing.T) {
+	ctx := context.Background()
+	env := environment{
+		storage: fileBlobBucket,
+		profilingWriter: &kafkaWriterRecorder{
+			err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		},
+		config: ServiceConfig{
+			OutboxPrefix: "outbox-unreachable",
+		},
+	}
+	storeOutboxTestEntry(t, &env, "1/1/unreachable/chunk", "snuba-profile-chunks")
+
+	for i := 0; i < outboxMaxAttempts; i++ {
+		_, err := env.publishPending(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists, err := fileBlobBucket.Exists(ctx, env.outboxPath("1/1/unreachable/chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the entry to be kept in the outbox")
+	}
+}
+
+func TestAcquireOutboxLease(t *testing.T) {
+	ctx := context.Background()
+	env := environment{
+		storage: fileBlobBucket,
+		config: ServiceConfig{
+			OutboxPrefix:          "outbox-lease",
+			OutboxPublishInterval: time.Minute,
+		},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	for _, test := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{now: now, want: true},
+		{now: now.Add(20 * time.Second), want: false},
+		{now: now.Add(time.Minute), want: true},
+	} {
+		acquired, err := env.acquireOutboxLease(ctx, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired != test.want {
+			t.Fatalf("expected the lease acquired at %v to be %v", test.now, test.want)
+		}
+	}
+	exists, err := fileBlobBucket.Exists(ctx, "outbox-lease-leases/"+strconv.FormatInt(now.Truncate(time.Minute).Unix(), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the lease of the previous pass to be deleted")
+	}
+}
+
+func TestOutboxRequiresAcknowledgedWrites(t *testing.T) {
+	t.Setenv("SENTRY_BUCKET_PROFILES", "file://"+t.TempDir())
+	t.Setenv("SENTRY_OUTBOX_PREFIX", "outbox")
+	t.Setenv("SENTRY_KAFKA_WRITER_PROFILES_REQUIRED_ACKS", "none")
+	_, err := newEnvironment(false)
+	if err == nil {
+		t.Fatal("expected an error for the outbox without acknowledged writes")
+	}
+}
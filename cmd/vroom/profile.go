@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 	"strconv"
 
@@ -12,12 +11,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/segmentio/kafka-go"
-	"gocloud.dev/gcerrors"
 	"google.golang.org/api/googleapi"
 
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/httputil"
+	"github.com/getsentry/vroom/internal/jsonutil"
 	"github.com/getsentry/vroom/internal/metrics"
+	"github.com/getsentry/vroom/internal/nodetree"
 	"github.com/getsentry/vroom/internal/occurrence"
 	"github.com/getsentry/vroom/internal/profile"
+	"github.com/getsentry/vroom/internal/schema"
 	"github.com/getsentry/vroom/internal/storageutil"
 )
 
@@ -32,24 +35,54 @@ func (env *environment) postProfile(w http.ResponseWriter, r *http.Request) {
 
 	s := sentry.StartSpan(ctx, "processing")
 	s.Description = "Read HTTP body"
-	body, err := io.ReadAll(r.Body)
+	body, err := httputil.ReadBody(r)
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
 		return
 	}
 	defer r.Body.Close()
 
+	err = env.ingestProfile(ctx, body, ingestOptions{})
+	if err != nil {
+		env.storeDeadLetter(ctx, deadLetterProfile, body, err)
+		writeIngestError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestProfile stores a profile and sends the messages derived from it
+// downstream.
+func (env *environment) ingestProfile(ctx context.Context, body []byte, opts ingestOptions) (err error) {
+	ctx, hub := ingestHub(ctx)
+
+	var payloadPlatform string
+	defer func() {
+		observeIngestion(ingestKindProfile, payloadPlatform, len(body), err)
+	}()
+
+	// The version tells us how to decode the profile, we read it without
+	// decoding the whole payload so it's only decoded once.
+	fields, err := jsonutil.Peek(body, "platform", "version")
+	if err != nil {
+		hub.CaptureException(err)
+		return newIngestError(ingestErrorInvalid, ingestStageUnmarshal, err)
+	}
+	// Errors decoding the payload are tagged with its platform too.
+	payloadPlatform = fields["platform"]
+	hub.Scope().SetTag("platform", payloadPlatform)
+
 	var p profile.Profile
-	s = sentry.StartSpan(ctx, "json.unmarshal")
+	s := sentry.StartSpan(ctx, "json.unmarshal")
 	s.Description = "Unmarshal profile"
-	err = json.Unmarshal(body, &p)
+	err = p.UnmarshalVersion(body, fields["version"])
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		return newIngestError(ingestErrorInvalid, ingestStageUnmarshal, err)
 	}
 
 	orgID := p.OrganizationID()
@@ -80,112 +113,52 @@ func (env *environment) postProfile(w http.ResponseWriter, r *http.Request) {
 		p.SetProfileID(unsampledProfileID)
 	}
 
-	if p.IsSampled() {
-		s = sentry.StartSpan(ctx, "gcs.write")
-		s.Description = "Write profile to GCS"
-		err = storageutil.CompressedWrite(ctx, env.storage, p.StoragePath(), p)
-		s.Finish()
-		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-				// These are transient errors, we'll retry.
-				w.WriteHeader(http.StatusTooManyRequests)
-			} else {
-				if code := gcerrors.Code(err); code == gcerrors.FailedPrecondition {
-					// This indicates a duplicate, we won't retry.
-					w.WriteHeader(http.StatusPreconditionFailed)
-				} else {
-					if hub != nil {
-						hub.CaptureException(err)
-					}
-					// These errors won't be retried.
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-			}
-			return
-		}
-	}
-
 	s = sentry.StartSpan(ctx, "processing")
 	s.Description = "Generate call trees"
 	callTrees, err := p.CallTrees()
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return newIngestError(ingestErrorInternal, ingestStageCallTrees, err)
 	}
 
+	var functionsMessages []kafka.Message
+	var functionsDataset []nodetree.CallTreeFunction
 	if len(callTrees) > 0 {
-		// if the profile was not sampled we skip find_occurrences since we're only
-		// interested in extracting data to improve functions aggregations not in
-		// using it for finding occurrences of an issue
-		if p.IsSampled() {
-			s = sentry.StartSpan(ctx, "processing")
-			s.Description = "Find occurrences"
-			occurrences := occurrence.Find(p, callTrees)
-			s.Finish()
-
-			// Filter in-place occurrences without a type.
-			var i int
-			for _, o := range occurrences {
-				if o.Type != occurrence.NoneType {
-					occurrences[i] = o
-					i++
-				}
-			}
-			occurrences = occurrences[:i]
-			s = sentry.StartSpan(ctx, "processing")
-			s.Description = "Build Kafka message batch"
-			occurrenceMessages, err := occurrence.GenerateKafkaMessageBatch(occurrences)
-			s.Finish()
-			if err != nil {
-				// Report the error but don't fail profile insertion
-				hub.CaptureException(err)
-			} else {
-				s = sentry.StartSpan(ctx, "processing")
-				s.Description = "Send occurrences to Kafka"
-				err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
-				s.Finish()
-				if err != nil {
-					// Report the error but don't fail profile insertion
-					hub.CaptureException(err)
-				}
-			}
-		}
-
 		// Prepare call trees Kafka message
 		s = sentry.StartSpan(ctx, "processing")
 		s.Description = "Extract functions"
-		functions := metrics.ExtractFunctionsFromCallTrees(callTrees, minDepth)
+		functions := metrics.ExtractFunctionsFromCallTrees(callTrees, minDepth, &p)
 		// Cap but don't filter out system frames.
 		// Necessary until front end changes are in place.
-		functionsDataset := metrics.CapAndFilterFunctions(functions, maxUniqueFunctionsPerProfile, false)
+		functionsDataset = metrics.CapAndFilterFunctions(functions, maxUniqueFunctionsPerProfile, false)
 		s.Finish()
 
+		if !env.config.FunctionsThreadMetrics {
+			dropThreadMetrics(functionsDataset)
+		}
+		if env.config.FunctionsDurationsSketches {
+			addDurationsSketches(functionsDataset)
+		}
+
 		s = sentry.StartSpan(ctx, "json.marshal")
 		s.Description = "Marshal functions Kafka message"
 		b, err := json.Marshal(buildFunctionsKafkaMessage(p, functionsDataset))
 		s.Finish()
 		if err != nil {
 			hub.CaptureException(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return newIngestError(ingestErrorInternal, ingestStageMarshal, err)
 		}
-		s = sentry.StartSpan(ctx, "processing")
-		s.Description = "Send functions to Kafka"
-		err = env.profilingWriter.WriteMessages(ctx, kafka.Message{
-			Topic: env.config.CallTreesKafkaTopic,
-			Value: b,
-		})
-		s.Finish()
 		hub.Scope().SetContext("Call functions payload", map[string]interface{}{
 			"Size": len(b),
 		})
-		if err != nil {
-			hub.CaptureException(err)
-		}
+		functionsMessages = append(functionsMessages, env.functionsMessage(
+			b,
+			kafkaMessageKeys{ProjectID: p.ProjectID()},
+		))
 	}
 
+	// Unsampled profiles aren't stored, only their functions are sent.
 	if p.IsSampled() {
 		// Prepare profile Kafka message
 		s = sentry.StartSpan(ctx, "processing")
@@ -194,28 +167,85 @@ func (env *environment) postProfile(w http.ResponseWriter, r *http.Request) {
 		s.Finish()
 		if err != nil {
 			hub.CaptureException(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return newIngestError(ingestErrorInternal, ingestStageMarshal, err)
 		}
-
-		s = sentry.StartSpan(ctx, "processing")
-		s.Description = "Send profile metadata to Kafka"
-		err = env.profilingWriter.WriteMessages(ctx, kafka.Message{
-			Topic: env.config.ProfilesKafkaTopic,
-			Value: b,
-		})
-		s.Finish()
 		hub.Scope().SetContext("Profile metadata Kafka payload", map[string]interface{}{
 			"Size": len(b),
 		})
+		// The message is built before we store the profile so it can be kept
+		// in the outbox until it's delivered.
+		messages := []kafka.Message{env.kafkaMessage(
+			env.config.ProfilesKafkaTopic,
+			schema.Profile,
+			b,
+			kafkaMessageKeys{ProjectID: p.ProjectID()},
+		)}
+
+		objectName := p.StoragePath()
+		stored, err := env.store(ctx, hub, objectName, p, messages, opts)
+		if err != nil {
+			return err
+		}
+		err = env.publish(ctx, hub, objectName, stored, messages)
+		if err != nil {
+			return err
+		}
+	}
+
+	env.publishFunctions(ctx, hub, functionsMessages)
+
+	// if the profile was not sampled we skip find_occurrences since we're only
+	// interested in extracting data to improve functions aggregations not in
+	// using it for finding occurrences of an issue
+	if len(callTrees) > 0 && p.IsSampled() {
+		s = sentry.StartSpan(ctx, "processing")
+		s.Description = "Find occurrences"
+		occurrences := occurrence.Find(p, callTrees, env.occurrenceOptions)
+		s.Finish()
+
+		// Filter in-place occurrences without a type.
+		var i int
+		for _, o := range occurrences {
+			if o.Type != occurrence.NoneType {
+				occurrences[i] = o
+				i++
+			}
+		}
+		occurrences = occurrences[:i]
+		occurrences = env.occurrencesRateLimiter.Filter(occurrences)
+		s = sentry.StartSpan(ctx, "processing")
+		s.Description = "Build Kafka message batch"
+		occurrenceMessages, err := occurrence.GenerateKafkaMessageBatch(occurrences)
+		s.Finish()
 		if err != nil {
+			// Report the error but don't fail profile insertion
 			hub.CaptureException(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		} else {
+			s = sentry.StartSpan(ctx, "processing")
+			s.Description = "Send occurrences to Kafka"
+			err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
+			s.Finish()
+			if err != nil {
+				// Report the error but don't fail profile insertion
+				hub.CaptureException(err)
+			} else {
+				env.occurrencesRateLimiter.Emitted(occurrences)
+				countOccurrences(occurrences)
+			}
 		}
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if len(functionsDataset) > 0 {
+		env.regressionDetector.Add(
+			p.OrganizationID(),
+			p.ProjectID(),
+			p.Timestamp(),
+			examples.ExampleMetadata{ProjectID: p.ProjectID(), ProfileID: p.ID()},
+			functionsDataset,
+		)
+	}
+
+	return nil
 }
 
 func (env *environment) getRawProfile(w http.ResponseWriter, r *http.Request) {
@@ -362,6 +392,11 @@ func (env *environment) getProfile(w http.ResponseWriter, r *http.Request) {
 
 	hub.Scope().SetTag("platform", string(p.Platform()))
 
+	if qs.Get("collapse_inline_frames") == "true" {
+		hub.Scope().SetTag("collapse_inline_frames", "true")
+		p.CollapseInlineFrames()
+	}
+
 	s = sentry.StartSpan(ctx, "json.marshal")
 	defer s.Finish()
 
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/platform"
+)
+
+const metricsNamespace = "vroom"
+
+const (
+	ingestKindProfile = "profile"
+	ingestKindChunk   = "chunk"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, per route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent handling HTTP requests, per route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ingestedPayloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ingested_payloads_total",
+		Help:      "Profiles and chunks run through the ingestion pipeline, per result.",
+	}, []string{"kind", "platform", "result"})
+	ingestedPayloadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ingested_payload_size_bytes",
+		Help:      "Size of the profiles and chunks ingested, once decompressed.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"kind", "platform"})
+
+	occurrencesProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "occurrences_produced_total",
+		Help:      "Occurrences written to Kafka, per category.",
+	}, []string{"category"})
+
+	kafkaMessagesDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_delivered_total",
+		Help:      "Messages delivered to Kafka, per topic.",
+	}, []string{"topic"})
+	kafkaMessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_failed_total",
+		Help:      "Messages we failed to write to Kafka, per topic.",
+	}, []string{"topic"})
+	kafkaMessagesInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_invalid_total",
+		Help:      "Messages not matching their schema, per schema.",
+	}, []string{"schema"})
+
+	kafkaWriterStats = newKafkaWriterCollector()
+)
+
+// knownPlatforms are the platforms used as label values, payloads of any
+// other platform are counted together so they can't grow the number of
+// series.
+var knownPlatforms = map[platform.Platform]struct{}{
+	platform.Android:    {},
+	platform.Cocoa:      {},
+	platform.Java:       {},
+	platform.JavaScript: {},
+	platform.Node:       {},
+	platform.PHP:        {},
+	platform.Python:     {},
+	platform.Rust:       {},
+}
+
+// registerMetrics registers the collector reading the stats of the Kafka
+// writers. It's only called once, by main.
+func registerMetrics() {
+	prometheus.MustRegister(kafkaWriterStats)
+}
+
+// metricsHandler serves the metrics of the default registry, in the
+// OpenMetrics format to scrapers asking for it.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		}),
+	)
+}
+
+// instrumentRoute counts the requests to a route and how long they took.
+func instrumentRoute(route string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerDuration(
+		httpRequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequestsTotal.MustCurryWith(labels), next),
+	)
+}
+
+// observeIngestion records the result of running a payload through the
+// ingestion pipeline, along with its size.
+func observeIngestion(kind, payloadPlatform string, size int, err error) {
+	label := "other"
+	if _, exists := knownPlatforms[platform.Platform(payloadPlatform)]; exists {
+		label = payloadPlatform
+	}
+	result := "ok"
+	var ie *ingestError
+	switch {
+	case errors.As(err, &ie):
+		result = ie.kind.String()
+	case err != nil:
+		result = "error"
+	}
+	ingestedPayloads.WithLabelValues(kind, label, result).Inc()
+	ingestedPayloadSize.WithLabelValues(kind, label).Observe(float64(size))
+}
+
+// countOccurrences counts the occurrences written to Kafka per category.
+func countOccurrences(occurrences []*occurrence.Occurrence) {
+	for _, o := range occurrences {
+		occurrencesProduced.WithLabelValues(string(o.Category())).Inc()
+	}
+}
+
+type (
+	// kafkaStatsReader is implemented by *kafka.Writer. Each call of Stats
+	// returns the counters accumulated since the previous one.
+	kafkaStatsReader interface {
+		Stats() kafka.WriterStats
+	}
+
+	// kafkaWriterCollector exports the stats of our Kafka writers per topic.
+	// Writers reset their counters when they're read so the collector keeps
+	// the totals.
+	kafkaWriterCollector struct {
+		mu      sync.Mutex
+		writers []kafkaStatsReader
+		totals  map[string]*kafkaWriterTotals
+	}
+
+	kafkaWriterTotals struct {
+		writes   int64
+		messages int64
+		bytes    int64
+		errors   int64
+		retries  int64
+	}
+)
+
+var (
+	kafkaWriterWritesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "kafka_writer", "writes_total"),
+		"Batches written to Kafka, per topic.",
+		[]string{"topic"}, nil,
+	)
+	kafkaWriterMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "kafka_writer", "messages_total"),
+		"Messages written to Kafka, per topic.",
+		[]string{"topic"}, nil,
+	)
+	kafkaWriterBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "kafka_writer", "bytes_total"),
+		"Bytes written to Kafka, per topic.",
+		[]string{"topic"}, nil,
+	)
+	kafkaWriterErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "kafka_writer", "errors_total"),
+		"Errors writing to Kafka, per topic.",
+		[]string{"topic"}, nil,
+	)
+	kafkaWriterRetriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "kafka_writer", "retries_total"),
+		"Writes to Kafka retried, per topic.",
+		[]string{"topic"}, nil,
+	)
+)
+
+func newKafkaWriterCollector() *kafkaWriterCollector {
+	return &kafkaWriterCollector{totals: make(map[string]*kafkaWriterTotals)}
+}
+
+// add starts collecting the stats of a writer.
+func (c *kafkaWriterCollector) add(w kafkaStatsReader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writers = append(c.writers, w)
+}
+
+func (c *kafkaWriterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- kafkaWriterWritesDesc
+	ch <- kafkaWriterMessagesDesc
+	ch <- kafkaWriterBytesDesc
+	ch <- kafkaWriterErrorsDesc
+	ch <- kafkaWriterRetriesDesc
+}
+
+func (c *kafkaWriterCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.writers {
+		s := w.Stats()
+		t, exists := c.totals[s.Topic]
+		if !exists {
+			t = &kafkaWriterTotals{}
+			c.totals[s.Topic] = t
+		}
+		t.writes += s.Writes
+		t.messages += s.Messages
+		t.bytes += s.Bytes
+		t.errors += s.Errors
+		t.retries += s.Retries
+	}
+	for topic, t := range c.totals {
+		ch <- prometheus.MustNewConstMetric(kafkaWriterWritesDesc, prometheus.CounterValue, float64(t.writes), topic)
+		ch <- prometheus.MustNewConstMetric(kafkaWriterMessagesDesc, prometheus.CounterValue, float64(t.messages), topic)
+		ch <- prometheus.MustNewConstMetric(kafkaWriterBytesDesc, prometheus.CounterValue, float64(t.bytes), topic)
+		ch <- prometheus.MustNewConstMetric(kafkaWriterErrorsDesc, prometheus.CounterValue, float64(t.errors), topic)
+		ch <- prometheus.MustNewConstMetric(kafkaWriterRetriesDesc, prometheus.CounterValue, float64(t.retries), topic)
+	}
+}
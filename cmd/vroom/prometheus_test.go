@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeStatsReader returns the stats it holds once, like a Kafka writer
+// resetting its counters when they're read.
+type fakeStatsReader struct {
+	stats kafka.WriterStats
+}
+
+func (f *fakeStatsReader) Stats() kafka.WriterStats {
+	s := f.stats
+	f.stats = kafka.WriterStats{Topic: s.Topic}
+	return s
+}
+
+func TestKafkaWriterCollector(t *testing.T) {
+	c := newKafkaWriterCollector()
+	profiles := &fakeStatsReader{kafka.WriterStats{Topic: "profiles", Writes: 1, Messages: 2, Bytes: 100}}
+	c.add(profiles)
+	c.add(&fakeStatsReader{kafka.WriterStats{Topic: "profiles", Writes: 1, Messages: 1, Bytes: 50, Errors: 1}})
+	c.add(&fakeStatsReader{kafka.WriterStats{Topic: "occurrences", Writes: 1, Messages: 3, Retries: 2}})
+
+	// Stats are read twice, the totals are kept across reads.
+	_ = testutil.CollectAndCount(c)
+	profiles.stats = kafka.WriterStats{Topic: "profiles", Writes: 1, Messages: 1, Bytes: 10}
+
+	expected := `
+# HELP vroom_kafka_writer_messages_total Messages written to Kafka, per topic.
+# TYPE vroom_kafka_writer_messages_total counter
+vroom_kafka_writer_messages_total{topic="occurrences"} 3
+vroom_kafka_writer_messages_total{topic="profiles"} 4
+# HELP vroom_kafka_writer_bytes_total Bytes written to Kafka, per topic.
+# TYPE vroom_kafka_writer_bytes_total counter
+vroom_kafka_writer_bytes_total{topic="occurrences"} 0
+vroom_kafka_writer_bytes_total{topic="profiles"} 160
+# HELP vroom_kafka_writer_errors_total Errors writing to Kafka, per topic.
+# TYPE vroom_kafka_writer_errors_total counter
+vroom_kafka_writer_errors_total{topic="occurrences"} 0
+vroom_kafka_writer_errors_total{topic="profiles"} 1
+# HELP vroom_kafka_writer_retries_total Writes to Kafka retried, per topic.
+# TYPE vroom_kafka_writer_retries_total counter
+vroom_kafka_writer_retries_total{topic="occurrences"} 2
+vroom_kafka_writer_retries_total{topic="profiles"} 0
+# HELP vroom_kafka_writer_writes_total Batches written to Kafka, per topic.
+# TYPE vroom_kafka_writer_writes_total counter
+vroom_kafka_writer_writes_total{topic="occurrences"} 1
+vroom_kafka_writer_writes_total{topic="profiles"} 3
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObserveIngestion(t *testing.T) {
+	tests := []struct {
+		name            string
+		payloadPlatform string
+		err             error
+		wantPlatform    string
+		wantResult      string
+	}{
+		{
+			name:            "ingested",
+			payloadPlatform: "python",
+			wantPlatform:    "python",
+			wantResult:      "ok",
+		},
+		{
+			name:            "ingestion error",
+			payloadPlatform: "cocoa",
+			err:             newIngestError(ingestErrorDuplicate, ingestStageStorage, errors.New("duplicate")),
+			wantPlatform:    "cocoa",
+			wantResult:      "duplicate",
+		},
+		{
+			name:            "unknown platform",
+			payloadPlatform: "not-a-platform",
+			err:             errors.New("error"),
+			wantPlatform:    "other",
+			wantResult:      "error",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := ingestedPayloads.WithLabelValues(ingestKindChunk, test.wantPlatform, test.wantResult)
+			before := testutil.ToFloat64(counter)
+			observeIngestion(ingestKindChunk, test.payloadPlatform, 1024, test.err)
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Fatalf("expected the counter to be incremented once, got: %v", got)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	body := consumerTestChunk(t)
+	env := environment{
+		storage:         fileBlobBucket,
+		profilingWriter: &kafkaWriterRecorder{},
+		config: ServiceConfig{
+			ChunkMaxBodySize: int64(len(body) - 1),
+		},
+	}
+	router, err := env.newRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chunk", bytes.NewBuffer(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, got: %d", w.Code)
+	}
+	want := `vroom_http_requests_total{code="413",method="post",route="/chunk"}`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("expected %s in the metrics, got: %s", want, w.Body.String())
+	}
+}
+
+func TestCountKafkaDeliveries(t *testing.T) {
+	topic := "test-count-kafka-deliveries"
+	completion := countKafkaDeliveries(topic)
+	completion([]kafka.Message{{}, {}}, nil)
+	completion([]kafka.Message{{}}, errors.New("write failed"))
+
+	if v := testutil.ToFloat64(kafkaMessagesDelivered.WithLabelValues(topic)); v != 2 {
+		t.Fatalf("expected 2 delivered messages, got: %v", v)
+	}
+	if v := testutil.ToFloat64(kafkaMessagesFailed.WithLabelValues(topic)); v != 1 {
+		t.Fatalf("expected 1 failed message, got: %v", v)
+	}
+}
@@ -3,10 +3,50 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/httputil"
 	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+type (
+	regressedFunctionStatus string
+
+	regressedFunctionResult struct {
+		Status regressedFunctionStatus `json:"status"`
+		Reason string                  `json:"reason,omitempty"`
+	}
+
+	// processedRegressedFunction is the outcome of processing the regressed
+	// function at index i of a batch.
+	processedRegressedFunction struct {
+		i          int
+		occurrence *occurrence.Occurrence
+		result     regressedFunctionResult
+	}
+
+	postRegressedResponse struct {
+		Occurrences int                            `json:"occurrences"`
+		Emitted     []occurrence.RegressedFunction `json:"emitted"`
+		// Results has one entry per regressed function received, in the same order.
+		Results []regressedFunctionResult `json:"results"`
+	}
+)
+
+const (
+	regressedFunctionEmitted regressedFunctionStatus = "emitted"
+	regressedFunctionSkipped regressedFunctionStatus = "skipped"
+	regressedFunctionFailed  regressedFunctionStatus = "failed"
+
+	// Maximum amount of time we spend generating occurrences for a batch.
+	regressedFunctionsDeadline = 10 * time.Second
+	// Maximum number of regressed functions of a batch processed at once.
+	maxRegressedFunctionsWorkers = 8
 )
 
 func (env *environment) postRegressed(w http.ResponseWriter, r *http.Request) {
@@ -16,50 +56,97 @@ func (env *environment) postRegressed(w http.ResponseWriter, r *http.Request) {
 	regressedFunctions, err := decodeRegressedFunctionPayload(ctx, r)
 	if err != nil {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(httputil.BodyErrorStatusCode(err))
 		return
 	}
 
+	s := sentry.StartSpan(ctx, "processing")
+	s.Description = "Generating occurrences for payload"
+	processCtx, cancel := context.WithTimeout(ctx, regressedFunctionsDeadline)
+	// The caller waits on the response, don't let bulk reads starve ours.
+	queue := env.readQueue(storageutil.PriorityInteractive)
+	indices := make(chan int)
+	// Buffered so workers never block on results nobody waits for anymore.
+	processed := make(chan processedRegressedFunction, len(regressedFunctions))
+	for w := 0; w < min(len(regressedFunctions), maxRegressedFunctionsWorkers); w++ {
+		go func() {
+			for i := range indices {
+				processed <- env.processRegressedFunction(processCtx, hub, queue, i, regressedFunctions[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(indices)
+		for i := range regressedFunctions {
+			select {
+			case indices <- i:
+			case <-processCtx.Done():
+				return
+			}
+		}
+	}()
+
+	generated := make([]*occurrence.Occurrence, len(regressedFunctions))
+	results := make([]regressedFunctionResult, len(regressedFunctions))
+collect:
+	for range regressedFunctions {
+		select {
+		case p := <-processed:
+			generated[p.i] = p.occurrence
+			results[p.i] = p.result
+		case <-processCtx.Done():
+			// Regressed functions we didn't get to in time fail so the
+			// caller retries them.
+			for i := range results {
+				if results[i].Status == "" {
+					results[i] = regressedFunctionResult{Status: regressedFunctionFailed, Reason: processCtx.Err().Error()}
+				}
+			}
+			break collect
+		}
+	}
+	cancel()
+	s.Finish()
+
 	emitted := []occurrence.RegressedFunction{}
 	occurrences := []*occurrence.Occurrence{}
-	for _, regressedFunction := range regressedFunctions {
-		s := sentry.StartSpan(ctx, "processing")
-		s.Description = "Generating occurrence for payload"
-		occurrence, err := occurrence.ProcessRegressedFunction(ctx, env.storage, regressedFunction, readJobs)
-		s.Finish()
-		if err != nil {
-			hub.CaptureException(err)
-			continue
-		} else if occurrence == nil {
+	for i, o := range generated {
+		if o == nil {
 			continue
 		}
-		emitted = append(emitted, regressedFunction)
-		occurrences = append(occurrences, occurrence)
-	}
-
-	s := sentry.StartSpan(ctx, "json.marshal")
-	data := struct {
-		Occurrences int                            `json:"occurrences"`
-		Emitted     []occurrence.RegressedFunction `json:"emitted"`
-	}{Occurrences: len(occurrences), Emitted: emitted}
-	b, err := json.Marshal(data)
-	s.Finish()
-	if err != nil {
-		hub.CaptureException(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		emitted = append(emitted, regressedFunctions[i])
+		occurrences = append(occurrences, o)
 	}
 
+	statusCode := http.StatusOK
 	occurrenceMessages, err := occurrence.GenerateKafkaMessageBatch(occurrences)
-	if err != nil {
+	if err == nil {
+		s = sentry.StartSpan(ctx, "processing")
+		s.Description = "Send occurrences to Kafka"
+		err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
+		s.Finish()
+	}
+	if err == nil {
+		countOccurrences(occurrences)
+	} else {
 		hub.CaptureException(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		// Nothing was sent, let the caller retry all of them.
+		for i := range results {
+			if results[i].Status == regressedFunctionEmitted {
+				results[i] = regressedFunctionResult{Status: regressedFunctionFailed, Reason: err.Error()}
+			}
+		}
+		emitted = []occurrence.RegressedFunction{}
+		occurrences = nil
+		statusCode = http.StatusInternalServerError
 	}
 
-	s = sentry.StartSpan(ctx, "processing")
-	s.Description = "Send occurrences to Kafka"
-	err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
+	s = sentry.StartSpan(ctx, "json.marshal")
+	b, err := json.Marshal(postRegressedResponse{
+		Occurrences: len(occurrences),
+		Emitted:     emitted,
+		Results:     results,
+	})
 	s.Finish()
 	if err != nil {
 		hub.CaptureException(err)
@@ -68,10 +155,38 @@ func (env *environment) postRegressed(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(statusCode)
 	_, _ = w.Write(b)
 }
 
+// processRegressedFunction generates the occurrence of a regressed function
+// and reports the outcome.
+func (env *environment) processRegressedFunction(
+	ctx context.Context,
+	hub *sentry.Hub,
+	queue *storageutil.ReadQueue,
+	i int,
+	regressedFunction occurrence.RegressedFunction,
+) processedRegressedFunction {
+	p := processedRegressedFunction{i: i}
+	o, err := occurrence.ProcessRegressedFunction(ctx, env.storage, regressedFunction, queue)
+	switch {
+	case err == nil && o != nil:
+		p.occurrence = o
+		p.result = regressedFunctionResult{Status: regressedFunctionEmitted}
+	case err == nil:
+		p.result = regressedFunctionResult{Status: regressedFunctionSkipped, Reason: "no occurrence generated"}
+	case errors.Is(err, frame.ErrFrameNotFound):
+		p.result = regressedFunctionResult{Status: regressedFunctionSkipped, Reason: "frame not found"}
+	case errors.Is(err, storageutil.ErrObjectNotFound):
+		p.result = regressedFunctionResult{Status: regressedFunctionSkipped, Reason: "object not found"}
+	default:
+		hub.CaptureException(err)
+		p.result = regressedFunctionResult{Status: regressedFunctionFailed, Reason: err.Error()}
+	}
+	return p
+}
+
 func decodeRegressedFunctionPayload(ctx context.Context, r *http.Request) ([]occurrence.RegressedFunction, error) {
 	s := sentry.StartSpan(ctx, "processing")
 	s.Description = "Decoding payload"
@@ -81,3 +196,45 @@ func decodeRegressedFunctionPayload(ctx context.Context, r *http.Request) ([]occ
 	err := json.NewDecoder(r.Body).Decode(&regressedFunctions)
 	return regressedFunctions, err
 }
+
+// detectRegressions periodically looks for regressions in the functions we
+// ingested and emits an occurrence for each of them.
+func (env *environment) detectRegressions(ctx context.Context) {
+	ticker := time.NewTicker(env.config.FunctionRegressionsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			env.emitRegressions(ctx, env.regressionDetector.Detect(now))
+		}
+	}
+}
+
+func (env *environment) emitRegressions(ctx context.Context, regressedFunctions []occurrence.RegressedFunction) {
+	occurrences := make([]*occurrence.Occurrence, 0, len(regressedFunctions))
+	queue := env.readQueue(storageutil.PriorityBackground)
+	for _, regressedFunction := range regressedFunctions {
+		o, err := occurrence.ProcessRegressedFunction(ctx, env.storage, regressedFunction, queue)
+		if err != nil {
+			sentry.CaptureException(err)
+			continue
+		}
+		occurrences = append(occurrences, o)
+	}
+	if len(occurrences) == 0 {
+		return
+	}
+	occurrenceMessages, err := occurrence.GenerateKafkaMessageBatch(occurrences)
+	if err != nil {
+		sentry.CaptureException(err)
+		return
+	}
+	err = env.occurrencesWriter.WriteMessages(ctx, occurrenceMessages...)
+	if err != nil {
+		sentry.CaptureException(err)
+		return
+	}
+	countOccurrences(occurrences)
+}
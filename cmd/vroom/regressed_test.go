@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/storageutil"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestPostRegressedResults(t *testing.T) {
+	readScheduler = storageutil.NewReadScheduler(1, 10)
+	defer readScheduler.Close()
+
+	env := environment{
+		storage:           fileBlobBucket,
+		occurrencesWriter: KafkaWriterMock{},
+	}
+	payload, err := json.Marshal([]occurrence.RegressedFunction{
+		{
+			OrganizationID: 1,
+			ProjectID:      1,
+			ProfileID:      "missing-profile",
+		},
+		{
+			OrganizationID: 1,
+			ProjectID:      1,
+			Example: examples.ExampleMetadata{
+				ProfilerID: "missing-profiler",
+				ChunkID:    "missing-chunk",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/regressed", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+	env.postRegressed(w, req)
+	resp := w.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200. Found: %d", resp.StatusCode)
+	}
+
+	var body postRegressedResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []regressedFunctionResult{
+		{Status: regressedFunctionSkipped, Reason: "object not found"},
+		{Status: regressedFunctionSkipped, Reason: "object not found"},
+	}
+	if diff := testutil.Diff(body.Results, want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
+
+func TestPostRegressedFailsPastDeadline(t *testing.T) {
+	readScheduler = storageutil.NewReadScheduler(1, 10)
+	defer readScheduler.Close()
+
+	env := environment{
+		storage:           fileBlobBucket,
+		occurrencesWriter: KafkaWriterMock{},
+	}
+	regressedFunctions := make([]occurrence.RegressedFunction, 2*maxRegressedFunctionsWorkers)
+	for i := range regressedFunctions {
+		regressedFunctions[i] = occurrence.RegressedFunction{
+			OrganizationID: 1,
+			ProjectID:      1,
+			ProfileID:      "missing-profile",
+		}
+	}
+	payload, err := json.Marshal(regressedFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/regressed", bytes.NewBuffer(payload)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	env.postRegressed(w, req)
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	var body postRegressedResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Results) != len(regressedFunctions) {
+		t.Fatalf("Expected %d results. Found: %d", len(regressedFunctions), len(body.Results))
+	}
+	for i, result := range body.Results {
+		if result.Status == regressedFunctionEmitted || result.Reason == "" {
+			t.Fatalf("Expected regressed function %d to be skipped or failed with a reason. Found: %+v", i, result)
+		}
+	}
+}
+
+var regressedTestFrames = []frame.Frame{
+	{Function: "main", InApp: &testutil.True, Platform: platform.Python},
+	{Function: "regressed", InApp: &testutil.True, Platform: platform.Python},
+	{Function: "parse", InApp: &testutil.True, Platform: platform.Python},
+}
+
+// storeRegressedTestChunk stores a chunk of a profiler where the regressed
+// function runs for 10ms then calls parse for 10ms per sample, and returns
+// its example.
+func storeRegressedTestChunk(t *testing.T, profilerID string, parseSamples int) examples.ExampleMetadata {
+	c := chunk.SampleChunk{
+		ID:             uuid.New().String(),
+		ProfilerID:     profilerID,
+		Platform:       platform.Python,
+		OrganizationID: 1,
+		ProjectID:      1,
+		Version:        "2",
+		Profile: chunk.SampleData{
+			Frames: regressedTestFrames,
+			Stacks: [][]int{{1, 0}, {2, 1, 0}},
+		},
+		Measurements: json.RawMessage("null"),
+	}
+	c.Profile.Samples = append(c.Profile.Samples, chunk.Sample{StackID: 0, Timestamp: 1.0})
+	for i := 1; i <= parseSamples; i++ {
+		c.Profile.Samples = append(c.Profile.Samples, chunk.Sample{StackID: 1, Timestamp: 1.0 + float64(i)*0.01})
+	}
+	c.Profile.Samples = append(c.Profile.Samples, chunk.Sample{StackID: 0, Timestamp: 1.0 + float64(parseSamples+1)*0.01})
+
+	err := storageutil.CompressedWrite(
+		context.Background(),
+		fileBlobBucket,
+		chunk.StoragePath(c.OrganizationID, c.ProjectID, c.ProfilerID, c.ID),
+		chunk.New(&c),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return examples.ExampleMetadata{ProfilerID: c.ProfilerID, ChunkID: c.ID}
+}
+
+func TestPostRegressedBreakdown(t *testing.T) {
+	readScheduler = storageutil.NewReadScheduler(1, 10)
+	defer readScheduler.Close()
+
+	profilerID := uuid.New().String()
+	before := storeRegressedTestChunk(t, profilerID, 1)
+	after := storeRegressedTestChunk(t, profilerID, 3)
+	regressed := occurrence.RegressedFunction{
+		OrganizationID: 1,
+		ProjectID:      1,
+		Example:        after,
+		Fingerprint:    regressedTestFrames[1].Fingerprint(),
+	}
+
+	tests := []struct {
+		name           string
+		breakpoint     time.Time
+		examplesBefore []examples.ExampleMetadata
+		wantBreakdown  bool
+	}{
+		{
+			name:           "with examples before the breakpoint",
+			examplesBefore: []examples.ExampleMetadata{before},
+			wantBreakdown:  true,
+		},
+		{
+			name:          "with chunks of the profiler stored before the breakpoint",
+			breakpoint:    time.Now().Add(time.Hour),
+			wantBreakdown: true,
+		},
+		{
+			name:       "without chunks stored before the breakpoint",
+			breakpoint: time.Unix(0, 0),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &kafkaWriterRecorder{}
+			env := environment{
+				storage:           fileBlobBucket,
+				occurrencesWriter: writer,
+			}
+			regressedFunction := regressed
+			regressedFunction.ExamplesBefore = test.examplesBefore
+			regressedFunction.Breakpoint = uint64(max(test.breakpoint.Unix(), 0))
+			payload, err := json.Marshal([]occurrence.RegressedFunction{regressedFunction})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/regressed", bytes.NewBuffer(payload))
+			w := httptest.NewRecorder()
+			env.postRegressed(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code 200. Found: %d", w.Code)
+			}
+			if len(writer.messages) != 1 {
+				t.Fatalf("expected 1 occurrence, got: %d", len(writer.messages))
+			}
+
+			var o struct {
+				EvidenceData struct {
+					CalleeBreakdown []struct {
+						Function string `json:"function"`
+						BeforeNS uint64 `json:"before_ns"`
+						AfterNS  uint64 `json:"after_ns"`
+					} `json:"callee_breakdown"`
+				} `json:"evidence_data"`
+			}
+			err = json.Unmarshal(writer.messages[0].Value, &o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			breakdown := o.EvidenceData.CalleeBreakdown
+			if !test.wantBreakdown {
+				if len(breakdown) != 0 {
+					t.Fatalf("expected no breakdown, got: %+v", breakdown)
+				}
+				return
+			}
+			if len(breakdown) != 1 || breakdown[0].Function != "parse" {
+				t.Fatalf("expected a breakdown for parse, got: %+v", breakdown)
+			}
+			if breakdown[0].AfterNS <= breakdown[0].BeforeNS {
+				t.Fatalf("expected parse to be slower, got: %+v", breakdown[0])
+			}
+		})
+	}
+}
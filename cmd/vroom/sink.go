@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Sinks messages can be written to instead of Kafka, so vroom can run
+// without a broker.
+const (
+	messageSinkKafka  = "kafka"
+	messageSinkFile   = "file"
+	messageSinkStdout = "stdout"
+	messageSinkMemory = "memory"
+	messageSinkNoop   = "noop"
+)
+
+var errMissingTopic = errors.New("message sink: message without a topic")
+
+type (
+	// sinkRecord is how a message is written by the file and stdout sinks,
+	// one JSON object per line.
+	sinkRecord struct {
+		Topic   string            `json:"topic"`
+		Key     string            `json:"key,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Value   json.RawMessage   `json:"value"`
+	}
+
+	// fileSink appends messages to a NDJSON file per topic, named after the
+	// topic, in a directory.
+	fileSink struct {
+		dir string
+
+		mu    sync.Mutex
+		files map[string]*os.File
+	}
+
+	// streamSink writes messages of every topic to a single stream.
+	streamSink struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+
+	// memorySink keeps messages in memory so tests can inspect them.
+	memorySink struct {
+		mu       sync.Mutex
+		messages []kafka.Message
+	}
+
+	noopSink struct{}
+
+	// topicSink writes messages of a topic to a sink shared by every topic,
+	// the way a Kafka writer sets the topic of the messages it writes.
+	topicSink struct {
+		sink  KafkaWriter
+		topic string
+	}
+)
+
+// newMessageSink returns the sink configured to replace Kafka, or nil if
+// messages are written to Kafka.
+func newMessageSink(c ServiceConfig) (KafkaWriter, error) {
+	switch c.MessageSink {
+	case "", messageSinkKafka:
+		return nil, nil
+	case messageSinkFile:
+		if c.MessageSinkPath == "" {
+			return nil, errors.New("message sink: a path is required for the file sink")
+		}
+		err := os.MkdirAll(c.MessageSinkPath, 0o755)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{dir: c.MessageSinkPath, files: make(map[string]*os.File)}, nil
+	case messageSinkStdout:
+		return &streamSink{w: os.Stdout}, nil
+	case messageSinkMemory:
+		return &memorySink{}, nil
+	case messageSinkNoop:
+		return noopSink{}, nil
+	default:
+		return nil, fmt.Errorf("message sink: unknown sink %q", c.MessageSink)
+	}
+}
+
+// newMessageWriter returns the writer of a topic, either a Kafka writer or
+// one writing to the configured sink.
+func (env *environment) newMessageWriter(
+	topic string,
+	wc KafkaWriterConfig,
+	syncWrites bool,
+) (KafkaWriter, error) {
+	if env.sink != nil {
+		return topicSink{sink: env.sink, topic: topic}, nil
+	}
+	return newKafkaWriter(env.config, topic, wc, syncWrites)
+}
+
+func newSinkRecord(m kafka.Message) sinkRecord {
+	r := sinkRecord{
+		Topic: m.Topic,
+		Key:   string(m.Key),
+		Value: m.Value,
+	}
+	// Values which aren't JSON, like dead-lettered payloads, are written as
+	// strings to keep one record per line.
+	if !json.Valid(m.Value) {
+		r.Value, _ = json.Marshal(string(m.Value))
+	}
+	if len(m.Headers) > 0 {
+		r.Headers = make(map[string]string, len(m.Headers))
+		for _, h := range m.Headers {
+			r.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return r
+}
+
+func writeSinkRecords(w io.Writer, msgs []kafka.Message) error {
+	e := json.NewEncoder(w)
+	for _, m := range msgs {
+		if m.Topic == "" {
+			return errMissingTopic
+		}
+		err := e.Encode(newSinkRecord(m))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		if m.Topic == "" {
+			return errMissingTopic
+		}
+		f, exists := s.files[m.Topic]
+		if !exists {
+			var err error
+			f, err = os.OpenFile(
+				filepath.Join(s.dir, filepath.Base(m.Topic)+".ndjson"),
+				os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+				0o644,
+			)
+			if err != nil {
+				return err
+			}
+			s.files[m.Topic] = f
+		}
+		err := writeSinkRecords(f, []kafka.Message{m})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for topic, f := range s.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(s.files, topic)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *streamSink) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeSinkRecords(s.w, msgs)
+}
+
+func (s *streamSink) Close() error {
+	return nil
+}
+
+func (s *memorySink) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		if m.Topic == "" {
+			return errMissingTopic
+		}
+	}
+	s.messages = append(s.messages, msgs...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+// Messages returns the messages written to a topic, or to every topic if
+// topic is empty, in the order they were written.
+func (s *memorySink) Messages(topic string) []kafka.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []kafka.Message
+	for _, m := range s.messages {
+		if topic == "" || m.Topic == topic {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// Reset drops the messages written so far.
+func (s *memorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+func (noopSink) WriteMessages(context.Context, ...kafka.Message) error {
+	return nil
+}
+
+func (noopSink) Close() error {
+	return nil
+}
+
+func (s topicSink) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	withTopic := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		m.Topic = s.topic
+		withTopic = append(withTopic, m)
+	}
+	err := s.sink.WriteMessages(ctx, withTopic...)
+	countKafkaDeliveries(s.topic)(withTopic, err)
+	return err
+}
+
+// Close doesn't close the shared sink, the environment does once every
+// writer is closed.
+func (topicSink) Close() error {
+	return nil
+}
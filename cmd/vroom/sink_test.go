@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestNewMessageSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ServiceConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "kafka", config: ServiceConfig{MessageSink: messageSinkKafka}, wantNil: true},
+		{name: "stdout", config: ServiceConfig{MessageSink: messageSinkStdout}},
+		{name: "memory", config: ServiceConfig{MessageSink: messageSinkMemory}},
+		{name: "noop", config: ServiceConfig{MessageSink: messageSinkNoop}},
+		{
+			name:   "file",
+			config: ServiceConfig{MessageSink: messageSinkFile, MessageSinkPath: t.TempDir()},
+		},
+		{name: "file without path", config: ServiceConfig{MessageSink: messageSinkFile}, wantErr: true},
+		{name: "unknown", config: ServiceConfig{MessageSink: "pigeon"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink, err := newMessageSink(test.config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %v, got: %v", test.wantErr, err)
+			}
+			if !test.wantErr && (sink == nil) != test.wantNil {
+				t.Fatalf("expected no sink: %v, got: %v", test.wantNil, sink)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newMessageSink(ServiceConfig{MessageSink: messageSinkFile, MessageSinkPath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.WriteMessages(
+		context.Background(),
+		kafka.Message{Topic: "chunks", Key: []byte("1"), Value: []byte(`{"a":1}`)},
+		kafka.Message{
+			Topic:   "dead-letter",
+			Value:   []byte("{"),
+			Headers: []kafka.Header{{Key: "vroom-error", Value: []byte("invalid")}},
+		},
+		kafka.Message{Topic: "chunks", Value: []byte(`{"a":2}`)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"chunks.ndjson": `{"topic":"chunks","key":"1","value":{"a":1}}` + "\n" +
+			`{"topic":"chunks","value":{"a":2}}` + "\n",
+		"dead-letter.ndjson": `{"topic":"dead-letter","headers":{"vroom-error":"invalid"},"value":"{"}` + "\n",
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := testutil.Diff(string(b), content); diff != "" {
+			t.Fatalf("Result mismatch: got - want +\n%s", diff)
+		}
+	}
+}
+
+func TestStreamSink(t *testing.T) {
+	var b bytes.Buffer
+	sink := topicSink{sink: &streamSink{w: &b}, topic: "profiles"}
+	err := sink.WriteMessages(context.Background(), kafka.Message{Value: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(b.String(), `{"topic":"profiles","value":{}}`+"\n"); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+	err = (&streamSink{w: &b}).WriteMessages(context.Background(), kafka.Message{Value: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("expected an error for a message without a topic")
+	}
+}
+
+func TestEnvironmentWithMemorySink(t *testing.T) {
+	t.Setenv("SENTRY_MESSAGE_SINK", messageSinkMemory)
+	t.Setenv("SENTRY_BUCKET_PROFILES", "file://"+t.TempDir())
+	env, err := newEnvironment(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.shutdown()
+
+	req := httptest.NewRequest("POST", "/", bytes.NewBuffer(consumerTestChunk(t)))
+	w := httptest.NewRecorder()
+	env.postChunk(w, req)
+	if code := w.Result().StatusCode; code != http.StatusNoContent {
+		t.Fatalf("Expected status code 204. Found: %d", code)
+	}
+
+	sink := env.sink.(*memorySink)
+	if n := len(sink.Messages(env.config.ProfileChunksKafkaTopic)); n != 1 {
+		t.Fatalf("expected 1 chunk message, got: %d", n)
+	}
+	if n := len(sink.Messages(env.config.CallTreesKafkaTopic)); n != 1 {
+		t.Fatalf("expected 1 functions message, got: %d", n)
+	}
+	sink.Reset()
+	if n := len(sink.Messages("")); n != 0 {
+		t.Fatalf("expected no message after a reset, got: %d", n)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/getsentry/vroom/internal/clientsdk"
 	"github.com/getsentry/vroom/internal/debugmeta"
 	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/measurements"
 	"github.com/getsentry/vroom/internal/nodetree"
 	"github.com/getsentry/vroom/internal/options"
 	"github.com/getsentry/vroom/internal/platform"
@@ -121,6 +122,26 @@ func (c AndroidChunk) GetOptions() options.Options {
 	return c.Options
 }
 
+func (c AndroidChunk) GetMeasurements() (map[string]measurements.MeasurementV2, error) {
+	return unmarshalMeasurements(c.Measurements)
+}
+
+func (c AndroidChunk) MainThreadID() string {
+	threadID := c.Profile.ActiveThreadID()
+	if threadID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(threadID, 10)
+}
+
+func (c AndroidChunk) ThreadName(threadID string) string {
+	tid, err := strconv.ParseUint(threadID, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return c.Profile.ThreadName(tid)
+}
+
 func (c AndroidChunk) GetFrameWithFingerprint(target uint32) (frame.Frame, error) {
 	for _, m := range c.Profile.Methods {
 		f := m.Frame()
@@ -133,3 +154,8 @@ func (c AndroidChunk) GetFrameWithFingerprint(target uint32) (frame.Frame, error
 
 func (c *AndroidChunk) Normalize() {
 }
+
+// CollapseInlineFrames does nothing since Android chunks don't have inline
+// frames.
+func (c *AndroidChunk) CollapseInlineFrames() {
+}
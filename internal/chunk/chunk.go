@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/jsonutil"
+	"github.com/getsentry/vroom/internal/measurements"
 	"github.com/getsentry/vroom/internal/nodetree"
 	"github.com/getsentry/vroom/internal/options"
 	"github.com/getsentry/vroom/internal/platform"
@@ -23,7 +25,10 @@ type (
 		GetRetentionDays() int
 		GetOptions() options.Options
 		GetFrameWithFingerprint(uint32) (frame.Frame, error)
+		GetMeasurements() (map[string]measurements.MeasurementV2, error)
 		CallTrees(activeThreadID *string) (map[string][]*nodetree.Node, error)
+		MainThreadID() string
+		ThreadName(threadID string) string
 
 		DurationMS() uint64
 		EndTimestamp() float64
@@ -33,6 +38,7 @@ type (
 		StoragePath() string
 
 		Normalize()
+		CollapseInlineFrames()
 	}
 
 	Chunk struct {
@@ -46,17 +52,20 @@ func New(c chunkInterface) Chunk {
 	}
 }
 
-type version struct {
-	Version string `json:"version"`
-}
+const mainThreadName = "main"
 
 func (c *Chunk) UnmarshalJSON(b []byte) error {
-	var v version
-	err := json.Unmarshal(b, &v)
+	fields, err := jsonutil.Peek(b, "version")
 	if err != nil {
 		return err
 	}
-	switch v.Version {
+	return c.UnmarshalVersion(b, fields["version"])
+}
+
+// UnmarshalVersion unmarshals a chunk in the format of a version, when the
+// version was already read from the payload.
+func (c *Chunk) UnmarshalVersion(b []byte, version string) error {
+	switch version {
 	case "":
 		c.chunk = new(AndroidChunk)
 	default:
@@ -83,6 +92,15 @@ func StoragePath(OrganizationID uint64, ProjectID uint64, ProfilerID string, ID
 	)
 }
 
+func unmarshalMeasurements(b json.RawMessage) (map[string]measurements.MeasurementV2, error) {
+	m := make(map[string]measurements.MeasurementV2)
+	if len(b) == 0 {
+		return m, nil
+	}
+	err := json.Unmarshal(b, &m)
+	return m, err
+}
+
 func (c Chunk) GetEnvironment() string {
 	return c.chunk.GetEnvironment()
 }
@@ -127,6 +145,18 @@ func (c Chunk) GetFrameWithFingerprint(f uint32) (frame.Frame, error) {
 	return c.chunk.GetFrameWithFingerprint(f)
 }
 
+func (c Chunk) GetMeasurements() (map[string]measurements.MeasurementV2, error) {
+	return c.chunk.GetMeasurements()
+}
+
+func (c Chunk) MainThreadID() string {
+	return c.chunk.MainThreadID()
+}
+
+func (c Chunk) ThreadName(threadID string) string {
+	return c.chunk.ThreadName(threadID)
+}
+
 func (c Chunk) CallTrees(activeThreadID *string) (map[string][]*nodetree.Node, error) {
 	return c.chunk.CallTrees(activeThreadID)
 }
@@ -153,3 +183,9 @@ func (c Chunk) StoragePath() string {
 func (c *Chunk) Normalize() {
 	c.chunk.Normalize()
 }
+
+// CollapseInlineFrames attributes the time spent in inline frames to the frame
+// they were inlined into.
+func (c *Chunk) CollapseInlineFrames() {
+	c.chunk.CollapseInlineFrames()
+}
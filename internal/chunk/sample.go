@@ -10,6 +10,7 @@ import (
 	"github.com/getsentry/vroom/internal/clientsdk"
 	"github.com/getsentry/vroom/internal/debugmeta"
 	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/measurements"
 	"github.com/getsentry/vroom/internal/nodetree"
 	"github.com/getsentry/vroom/internal/options"
 	"github.com/getsentry/vroom/internal/platform"
@@ -83,6 +84,10 @@ func (c *SampleChunk) Normalize() {
 	}
 }
 
+func (c *SampleChunk) CollapseInlineFrames() {
+	sample.CollapseInlineFrames(c.Profile.Stacks, c.Profile.Frames)
+}
+
 // CallTrees generates call trees from samples.
 func (c SampleChunk) CallTrees(activeThreadID *string) (map[string][]*nodetree.Node, error) {
 	sort.SliceStable(c.Profile.Samples, func(i, j int) bool {
@@ -253,6 +258,24 @@ func (c SampleChunk) GetOptions() options.Options {
 	return c.Options
 }
 
+func (c SampleChunk) GetMeasurements() (map[string]measurements.MeasurementV2, error) {
+	return unmarshalMeasurements(c.Measurements)
+}
+
+// MainThreadID returns the ID of the thread named main, if any.
+func (c SampleChunk) MainThreadID() string {
+	for threadID, m := range c.Profile.ThreadMetadata {
+		if m.Name == mainThreadName {
+			return threadID
+		}
+	}
+	return ""
+}
+
+func (c SampleChunk) ThreadName(threadID string) string {
+	return c.Profile.ThreadMetadata[threadID].Name
+}
+
 func (c SampleChunk) GetFrameWithFingerprint(target uint32) (frame.Frame, error) {
 	for _, f := range c.Profile.Frames {
 		if f.Fingerprint() == target {
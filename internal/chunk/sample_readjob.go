@@ -20,7 +20,10 @@ type (
 		ThreadID       *string
 		Start          uint64
 		End            uint64
-		Result         chan<- storageutil.ReadJobResult
+		// CollapseInlineFrames attributes the time spent in inline frames to
+		// the frame they were inlined into.
+		CollapseInlineFrames bool
+		Result               chan<- storageutil.ReadJobResult
 	}
 
 	ReadJobResult struct {
@@ -42,6 +45,9 @@ func (job ReadJob) Read() {
 		StoragePath(job.OrganizationID, job.ProjectID, job.ProfilerID, job.ChunkID),
 		&chunk,
 	)
+	if err == nil && job.CollapseInlineFrames {
+		chunk.CollapseInlineFrames()
+	}
 
 	job.Result <- ReadJobResult{
 		Err:           err,
@@ -85,6 +91,10 @@ func (job CallTreesReadJob) Read() {
 		return
 	}
 
+	if job.CollapseInlineFrames {
+		chunk.CollapseInlineFrames()
+	}
+
 	callTrees, err := chunk.CallTrees(job.ThreadID)
 
 	job.Result <- CallTreesReadJobResult{
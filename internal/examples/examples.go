@@ -51,6 +51,19 @@ type (
 		Count       uint64            `json:"count"`
 		Worst       ExampleMetadata   `json:"worst"`
 		Examples    []ExampleMetadata `json:"examples"`
+		// Threads breaks down the metrics by thread name.
+		Threads []FunctionThreadMetrics `json:"threads,omitempty"`
+	}
+
+	FunctionThreadMetrics struct {
+		Name        string  `json:"name"`
+		P75         uint64  `json:"p75"`
+		P95         uint64  `json:"p95"`
+		P99         uint64  `json:"p99"`
+		Avg         float64 `json:"avg"`
+		Sum         uint64  `json:"sum"`
+		SumSelfTime uint64  `json:"-"`
+		Count       uint64  `json:"count"`
 	}
 )
 
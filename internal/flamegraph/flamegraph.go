@@ -64,9 +64,11 @@ func addCallTreeToFlamegraph(flamegraphTree *[]*nodetree.Node, callTree []*nodet
 			currentNode.SampleCount += node.SampleCount
 			currentNode.DurationNS += node.DurationNS
 			currentNode.SelfTimeNS += node.SelfTimeNS
-			currentNode.DurationsNS = append(currentNode.DurationsNS, node.DurationsNS...)
+			currentNode.Durations = node.MergeDurationsInto(currentNode.Durations)
 		} else {
 			currentNode = node.ShallowCopyWithoutChildren()
+			currentNode.Durations = node.MergeDurationsInto(nil)
+			currentNode.DurationsNS = nil
 			*flamegraphTree = append(*flamegraphTree, currentNode)
 		}
 		addCallTreeToFlamegraph(&currentNode.Children, node.Children, annotate)
@@ -189,12 +191,9 @@ func toSpeedscope(
 	}
 
 	for i, frameInfo := range fd.frameInfos {
-		sort.Slice(frameInfo.DurationsNS, func(i, j int) bool {
-			return frameInfo.DurationsNS[i] < frameInfo.DurationsNS[j]
-		})
-		frameInfo.P75Duration, _ = metrics.Quantile(frameInfo.DurationsNS, 0.75)
-		frameInfo.P95Duration, _ = metrics.Quantile(frameInfo.DurationsNS, 0.95)
-		frameInfo.P99Duration, _ = metrics.Quantile(frameInfo.DurationsNS, 0.99)
+		frameInfo.P75Duration, _ = frameInfo.Durations.Quantile(0.75)
+		frameInfo.P95Duration, _ = frameInfo.Durations.Quantile(0.95)
+		frameInfo.P99Duration, _ = frameInfo.Durations.Quantile(0.99)
 		fd.frameInfos[i] = frameInfo
 	}
 
@@ -242,7 +241,7 @@ func (f *flamegraph) visitCalltree(node *nodetree.Node, currentStack *[]int) {
 		f.frameInfos[i].Weight += node.DurationNS
 		f.frameInfos[i].SumDuration += node.DurationNS
 		f.frameInfos[i].SumSelfTime += node.SelfTimeNS
-		f.frameInfos[i].DurationsNS = append(f.frameInfos[i].DurationsNS, node.DurationsNS...)
+		f.frameInfos[i].Durations = node.MergeDurationsInto(f.frameInfos[i].Durations)
 	} else {
 		frame := node.ToFrame()
 		sfr := speedscope.Frame{
@@ -264,7 +263,7 @@ func (f *flamegraph) visitCalltree(node *nodetree.Node, currentStack *[]int) {
 			Weight:      node.DurationNS,
 			SumDuration: node.DurationNS,
 			SumSelfTime: node.SelfTimeNS,
-			DurationsNS: node.DurationsNS,
+			Durations:   node.MergeDurationsInto(nil),
 		})
 	}
 
@@ -365,90 +364,39 @@ func GetFlamegraphFromCandidates(
 	organizationID uint64,
 	transactionProfileCandidates []examples.TransactionProfileCandidate,
 	continuousProfileCandidates []examples.ContinuousProfileCandidate,
-	jobs chan storageutil.ReadJob,
+	collapseInlineFrames bool,
+	queue *storageutil.ReadQueue,
 	ma *metrics.Aggregator,
 	span *sentry.Span,
 ) (speedscope.Output, error) {
-	hub := sentry.GetHubFromContext(ctx)
-
-	results := make(chan storageutil.ReadJobResult)
-	defer close(results)
-
-	go func() {
-		dispatchSpan := span.StartChild("dispatch candidates")
-		dispatchSpan.SetData("transaction_candidates", len(transactionProfileCandidates))
-		dispatchSpan.SetData("continuous_candidates", len(continuousProfileCandidates))
-
-		for _, candidate := range transactionProfileCandidates {
-			jobs <- profile.CallTreesReadJob{
-				Ctx:            ctx,
-				OrganizationID: organizationID,
-				ProjectID:      candidate.ProjectID,
-				ProfileID:      candidate.ProfileID,
-				Storage:        storage,
-				Result:         results,
-			}
-		}
-
-		for _, candidate := range continuousProfileCandidates {
-			jobs <- chunk.CallTreesReadJob{
-				Ctx:            ctx,
-				OrganizationID: organizationID,
-				ProjectID:      candidate.ProjectID,
-				ProfilerID:     candidate.ProfilerID,
-				ChunkID:        candidate.ChunkID,
-				TransactionID:  candidate.TransactionID,
-				ThreadID:       candidate.ThreadID,
-				Start:          candidate.Start,
-				End:            candidate.End,
-				Storage:        storage,
-				Result:         results,
-			}
-		}
-
-		dispatchSpan.Finish()
-	}()
+	numCandidates := len(transactionProfileCandidates) + len(continuousProfileCandidates)
+	// Buffered so reads can still send their result once we stopped
+	// collecting them.
+	results := make(chan storageutil.ReadJobResult, numCandidates)
+
+	go dispatchCandidates(
+		ctx,
+		storage,
+		organizationID,
+		transactionProfileCandidates,
+		continuousProfileCandidates,
+		collapseInlineFrames,
+		queue,
+		results,
+		span,
+	)
 
 	var flamegraphTree []*nodetree.Node
 
 	flamegraphSpan := span.StartChild("processing candidates")
 
-	numCandidates := len(transactionProfileCandidates) + len(continuousProfileCandidates)
-
-	for i := 0; i < numCandidates; i++ {
-		res := <-results
-
-		err := res.Error()
-		if err != nil {
-			if errors.Is(err, storageutil.ErrObjectNotFound) {
-				continue
-			}
-			if errors.Is(err, context.DeadlineExceeded) {
-				// Since we set an artificially lower timeout
-				// (10s < 15s), if we exceeded the deadline
-				// we stopped downloading chunks, but we
-				// still have time to compute the flamegraph
-				// with the chunks we downloaded so far
-				// and return it.
-				continue
-			}
-			if hub != nil {
-				hub.CaptureException(err)
-			}
-			continue
-		}
-
-		if result, ok := res.(profile.CallTreesReadJobResult); ok {
+	err := collectResults(ctx, results, numCandidates, func(res storageutil.ReadJobResult) error {
+		switch result := res.(type) {
+		case profile.CallTreesReadJobResult:
 			transactionProfileSpan := span.StartChild("calltree")
 			transactionProfileSpan.Description = "transaction profile"
 
-			start, end := result.Profile.StartAndEndEpoch()
-			example := examples.NewExampleFromProfileID(
-				result.Profile.ProjectID(),
-				result.Profile.ID(),
-				start,
-				end,
-			)
+			example := profileExample(result)
 			annotate := annotateWithProfileExample(example)
 
 			for _, callTree := range result.CallTrees {
@@ -457,33 +405,16 @@ func GetFlamegraphFromCandidates(
 			// if metrics aggregator is not null, while we're at it,
 			// compute the metrics as well
 			if ma != nil {
-				functions := metrics.CapAndFilterFunctions(metrics.ExtractFunctionsFromCallTrees(result.CallTrees, ma.MinDepth), int(ma.MaxUniqueFunctions), true)
+				functions := metrics.CapAndFilterFunctions(metrics.ExtractFunctionsFromCallTrees(result.CallTrees, ma.MinDepth, result.Profile), int(ma.MaxUniqueFunctions), true)
 				ma.AddFunctions(functions, example)
 			}
 
 			transactionProfileSpan.Finish()
-		} else if result, ok := res.(chunk.CallTreesReadJobResult); ok {
+		case chunk.CallTreesReadJobResult:
 			chunkProfileSpan := span.StartChild("calltree")
 			chunkProfileSpan.Description = "continuous profile"
 
-			for threadID, callTree := range result.CallTrees {
-				if result.Start > 0 && result.End > 0 {
-					interval := examples.Interval{
-						Start: result.Start,
-						End:   result.End,
-					}
-					callTree = sliceCallTree(&callTree, &[]examples.Interval{interval})
-				}
-
-				example := examples.NewExampleFromProfilerChunk(
-					result.Chunk.GetProjectID(),
-					result.Chunk.GetProfilerID(),
-					result.Chunk.GetID(),
-					result.TransactionID,
-					&threadID,
-					result.Start,
-					result.End,
-				)
+			forEachChunkThread(result, func(threadID string, callTree []*nodetree.Node, example examples.ExampleMetadata) {
 				annotate := annotateWithProfileExample(example)
 
 				addCallTreeToFlamegraph(&flamegraphTree, callTree, annotate)
@@ -491,15 +422,19 @@ func GetFlamegraphFromCandidates(
 				// if metrics aggregator is not null, while we're at it,
 				// compute the metrics as well
 				if ma != nil {
-					functions := metrics.CapAndFilterFunctions(metrics.ExtractFunctionsFromCallTreesForThread(callTree, ma.MinDepth), int(ma.MaxUniqueFunctions), true)
+					functions := metrics.CapAndFilterFunctions(metrics.ExtractFunctionsFromCallTreesForThread(callTree, threadID, metrics.ThreadGroup(result.Chunk, threadID), ma.MinDepth), int(ma.MaxUniqueFunctions), true)
 					ma.AddFunctions(functions, example)
 				}
-			}
+			})
 			chunkProfileSpan.Finish()
-		} else {
+		default:
 			// This should never happen
-			return speedscope.Output{}, errors.New("unexpected result from storage")
+			return errUnexpectedResult
 		}
+		return nil
+	})
+	if err != nil {
+		return speedscope.Output{}, err
 	}
 
 	flamegraphSpan.Finish()
@@ -514,3 +449,281 @@ func GetFlamegraphFromCandidates(
 	}
 	return sp, nil
 }
+
+// dispatchCandidates sends a job to read the call trees of each candidate.
+func dispatchCandidates(
+	ctx context.Context,
+	storage *blob.Bucket,
+	organizationID uint64,
+	transactionProfileCandidates []examples.TransactionProfileCandidate,
+	continuousProfileCandidates []examples.ContinuousProfileCandidate,
+	collapseInlineFrames bool,
+	queue *storageutil.ReadQueue,
+	results chan storageutil.ReadJobResult,
+	span *sentry.Span,
+) {
+	dispatchSpan := span.StartChild("dispatch candidates")
+	dispatchSpan.SetData("transaction_candidates", len(transactionProfileCandidates))
+	dispatchSpan.SetData("continuous_candidates", len(continuousProfileCandidates))
+
+	// Candidates we couldn't enqueue before the context was done are
+	// reported as failed, results are expected for every candidate.
+	for _, candidate := range transactionProfileCandidates {
+		err := queue.Enqueue(ctx, profile.CallTreesReadJob{
+			Ctx:                  ctx,
+			OrganizationID:       organizationID,
+			ProjectID:            candidate.ProjectID,
+			ProfileID:            candidate.ProfileID,
+			CollapseInlineFrames: collapseInlineFrames,
+			Storage:              storage,
+			Result:               results,
+		})
+		if err != nil {
+			results <- profile.CallTreesReadJobResult{Err: err}
+		}
+	}
+
+	for _, candidate := range continuousProfileCandidates {
+		err := queue.Enqueue(ctx, chunk.CallTreesReadJob{
+			Ctx:                  ctx,
+			OrganizationID:       organizationID,
+			ProjectID:            candidate.ProjectID,
+			ProfilerID:           candidate.ProfilerID,
+			ChunkID:              candidate.ChunkID,
+			TransactionID:        candidate.TransactionID,
+			ThreadID:             candidate.ThreadID,
+			Start:                candidate.Start,
+			End:                  candidate.End,
+			CollapseInlineFrames: collapseInlineFrames,
+			Storage:              storage,
+			Result:               results,
+		})
+		if err != nil {
+			results <- chunk.CallTreesReadJobResult{Err: err}
+		}
+	}
+
+	dispatchSpan.Finish()
+}
+
+var errUnexpectedResult = errors.New("unexpected result from storage")
+
+// collectResults receives the results of the n candidates dispatched and calls
+// onResult with each one read successfully. It stops at the first error
+// returned by onResult, results needs room for the ones left so their reads
+// don't block.
+func collectResults(
+	ctx context.Context,
+	results <-chan storageutil.ReadJobResult,
+	n int,
+	onResult func(res storageutil.ReadJobResult) error,
+) error {
+	hub := sentry.GetHubFromContext(ctx)
+	for i := 0; i < n; i++ {
+		res := <-results
+
+		err := res.Error()
+		if err != nil {
+			switch {
+			case errors.Is(err, storageutil.ErrObjectNotFound):
+			case errors.Is(err, context.Canceled):
+				// The request was canceled, nobody is waiting
+				// for the result anymore.
+			case errors.Is(err, context.DeadlineExceeded):
+				// Since we set an artificially lower timeout
+				// (10s < 15s), if we exceeded the deadline
+				// we stopped downloading chunks, but we
+				// still have time to compute the result
+				// with the chunks we downloaded so far
+				// and return it.
+			default:
+				if hub != nil {
+					hub.CaptureException(err)
+				}
+			}
+			continue
+		}
+
+		err = onResult(res)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileExample returns the example pointing to a profile read.
+func profileExample(result profile.CallTreesReadJobResult) examples.ExampleMetadata {
+	start, end := result.Profile.StartAndEndEpoch()
+	return examples.NewExampleFromProfileID(
+		result.Profile.ProjectID(),
+		result.Profile.ID(),
+		start,
+		end,
+	)
+}
+
+// forEachChunkThread calls fn with the call trees of each thread of a chunk
+// read, sliced to the interval of the candidate, and their example.
+func forEachChunkThread(
+	result chunk.CallTreesReadJobResult,
+	fn func(threadID string, callTree []*nodetree.Node, example examples.ExampleMetadata),
+) {
+	for threadID, callTree := range result.CallTrees {
+		if result.Start > 0 && result.End > 0 {
+			interval := examples.Interval{
+				Start: result.Start,
+				End:   result.End,
+			}
+			callTree = sliceCallTree(&callTree, &[]examples.Interval{interval})
+		}
+		example := examples.NewExampleFromProfilerChunk(
+			result.Chunk.GetProjectID(),
+			result.Chunk.GetProfilerID(),
+			result.Chunk.GetID(),
+			result.TransactionID,
+			&threadID,
+			result.Start,
+			result.End,
+		)
+		fn(threadID, callTree, example)
+	}
+}
+
+// GetMetricsFromCandidates aggregates function metrics from the candidates
+// without building a flamegraph.
+func GetMetricsFromCandidates(
+	ctx context.Context,
+	storage *blob.Bucket,
+	organizationID uint64,
+	transactionProfileCandidates []examples.TransactionProfileCandidate,
+	continuousProfileCandidates []examples.ContinuousProfileCandidate,
+	queue *storageutil.ReadQueue,
+	ma *metrics.Aggregator,
+	span *sentry.Span,
+) ([]examples.FunctionMetrics, error) {
+	err := AggregateMetricsFromCandidates(
+		ctx,
+		storage,
+		organizationID,
+		transactionProfileCandidates,
+		continuousProfileCandidates,
+		queue,
+		ma,
+		span,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ma.ToMetrics(), nil
+}
+
+// AggregateMetricsFromCandidates adds the functions of the candidates to the
+// aggregator.
+func AggregateMetricsFromCandidates(
+	ctx context.Context,
+	storage *blob.Bucket,
+	organizationID uint64,
+	transactionProfileCandidates []examples.TransactionProfileCandidate,
+	continuousProfileCandidates []examples.ContinuousProfileCandidate,
+	queue *storageutil.ReadQueue,
+	ma *metrics.Aggregator,
+	span *sentry.Span,
+) error {
+	numCandidates := len(transactionProfileCandidates) + len(continuousProfileCandidates)
+	// Buffered so reads can still send their result once we stopped
+	// collecting them.
+	results := make(chan storageutil.ReadJobResult, numCandidates)
+
+	go dispatchCandidates(
+		ctx,
+		storage,
+		organizationID,
+		transactionProfileCandidates,
+		continuousProfileCandidates,
+		false,
+		queue,
+		results,
+		span,
+	)
+
+	metricsSpan := span.StartChild("processing candidates")
+
+	err := collectResults(ctx, results, numCandidates, func(res storageutil.ReadJobResult) error {
+		switch result := res.(type) {
+		case profile.CallTreesReadJobResult:
+			functions := ma.SelectFunctions(metrics.ExtractFunctionsFromCallTrees(result.CallTrees, ma.MinDepth, result.Profile))
+			ma.AddFunctions(functions, profileExample(result))
+		case chunk.CallTreesReadJobResult:
+			forEachChunkThread(result, func(threadID string, callTree []*nodetree.Node, example examples.ExampleMetadata) {
+				functions := ma.SelectFunctions(metrics.ExtractFunctionsFromCallTreesForThread(callTree, threadID, metrics.ThreadGroup(result.Chunk, threadID), ma.MinDepth))
+				ma.AddFunctions(functions, example)
+			})
+		default:
+			// This should never happen
+			return errUnexpectedResult
+		}
+		return nil
+	})
+
+	metricsSpan.Finish()
+
+	return err
+}
+
+// GetCallGraphFromCandidates aggregates the callers and callees of a function
+// from the candidates.
+func GetCallGraphFromCandidates(
+	ctx context.Context,
+	storage *blob.Bucket,
+	organizationID uint64,
+	transactionProfileCandidates []examples.TransactionProfileCandidate,
+	continuousProfileCandidates []examples.ContinuousProfileCandidate,
+	queue *storageutil.ReadQueue,
+	cga *metrics.CallGraphAggregator,
+	span *sentry.Span,
+) (metrics.CallGraph, error) {
+	numCandidates := len(transactionProfileCandidates) + len(continuousProfileCandidates)
+	// Buffered so reads can still send their result once we stopped
+	// collecting them.
+	results := make(chan storageutil.ReadJobResult, numCandidates)
+
+	go dispatchCandidates(
+		ctx,
+		storage,
+		organizationID,
+		transactionProfileCandidates,
+		continuousProfileCandidates,
+		false,
+		queue,
+		results,
+		span,
+	)
+
+	callGraphSpan := span.StartChild("processing candidates")
+
+	err := collectResults(ctx, results, numCandidates, func(res storageutil.ReadJobResult) error {
+		switch result := res.(type) {
+		case profile.CallTreesReadJobResult:
+			example := profileExample(result)
+			for _, callTree := range result.CallTrees {
+				cga.AddCallTrees(callTree, example)
+			}
+		case chunk.CallTreesReadJobResult:
+			forEachChunkThread(result, func(_ string, callTree []*nodetree.Node, example examples.ExampleMetadata) {
+				cga.AddCallTrees(callTree, example)
+			})
+		default:
+			// This should never happen
+			return errUnexpectedResult
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics.CallGraph{}, err
+	}
+
+	callGraphSpan.Finish()
+
+	return cga.ToCallGraph(), nil
+}
@@ -500,7 +500,7 @@ func TestFlamegraphAggregation(t *testing.T) {
 			}
 
 			options := cmp.Options{
-				cmpopts.IgnoreFields(speedscope.FrameInfo{}, "DurationsNS"),
+				cmpopts.IgnoreFields(speedscope.FrameInfo{}, "Durations"),
 			}
 
 			speedscope := toSpeedscope(context.TODO(), ft, 10, 99)
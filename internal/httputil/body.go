@@ -0,0 +1,44 @@
+package httputil
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+)
+
+// LimitBody rejects requests with a body larger than maxSize bytes, once
+// decompressed, with a 413. Bodies of an unknown size are limited as they're
+// read, reading past the limit fails with an *http.MaxBytesError.
+func LimitBody(next http.Handler, maxSize int64) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxSize > 0 {
+			if r.ContentLength > maxSize {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReadBody reads the body of a request in a buffer of its size, when it's
+// known, so the body isn't copied as the buffer grows.
+func ReadBody(r *http.Request) ([]byte, error) {
+	var b bytes.Buffer
+	if r.ContentLength > 0 {
+		b.Grow(int(r.ContentLength) + bytes.MinRead)
+	}
+	_, err := b.ReadFrom(r.Body)
+	return b.Bytes(), err
+}
+
+// BodyErrorStatusCode returns the status code to respond with when a request
+// body couldn't be read or decoded.
+func BodyErrorStatusCode(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,124 @@
+package httputil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// echoBody responds with the body it read, or with the status code matching
+// the error reading it.
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	b, err := ReadBody(r)
+	if err != nil {
+		w.WriteHeader(BodyErrorStatusCode(err))
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+func compress(t *testing.T, encoding string, b []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	default:
+		return b
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressPayload(t *testing.T) {
+	payload := []byte(`{"platform":"python"}`)
+	tests := []struct {
+		encoding   string
+		body       []byte
+		wantStatus int
+	}{
+		{encoding: "", wantStatus: http.StatusOK},
+		{encoding: "br", wantStatus: http.StatusOK},
+		{encoding: "gzip", wantStatus: http.StatusOK},
+		{encoding: "zstd", wantStatus: http.StatusOK},
+		{encoding: "gzip", body: []byte("not gzip"), wantStatus: http.StatusBadRequest},
+		{encoding: "deflate", wantStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+			body := test.body
+			if body == nil {
+				body = compress(t, test.encoding, payload)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+			w := httptest.NewRecorder()
+			DecompressPayload(http.HandlerFunc(echoBody)).ServeHTTP(w, req)
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("expected status code %d, got: %d", test.wantStatus, w.Code)
+			}
+			if w.Code == http.StatusOK && !bytes.Equal(w.Body.Bytes(), payload) {
+				t.Fatalf("expected body %s, got: %s", payload, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), 100)
+	tests := []struct {
+		name       string
+		encoding   string
+		maxSize    int64
+		wantStatus int
+	}{
+		{name: "under the limit", maxSize: 100, wantStatus: http.StatusOK},
+		{name: "no limit", maxSize: 0, wantStatus: http.StatusOK},
+		{name: "content length over the limit", maxSize: 99, wantStatus: http.StatusRequestEntityTooLarge},
+		{
+			// The compressed body is smaller than the limit, the limit
+			// applies to the decompressed one.
+			name:       "decompressed body over the limit",
+			encoding:   "gzip",
+			maxSize:    50,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compress(t, test.encoding, payload)))
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+			w := httptest.NewRecorder()
+			handler := DecompressPayload(LimitBody(http.HandlerFunc(echoBody), test.maxSize))
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("expected status code %d, got: %d", test.wantStatus, w.Code)
+			}
+		})
+	}
+}
@@ -1,19 +1,47 @@
 package httputil
 
 import (
+	"compress/gzip"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 // DecompressPayload adds a reader of the right type in case you need to decompress the body.
+// Payloads in an encoding we don't know are passed through unchanged.
 func DecompressPayload(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		if r.Header.Get("Content-Encoding") == "br" {
-			r.Body = io.NopCloser(brotli.NewReader(r.Body))
+		var body io.ReadCloser
+		switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+		case "", "identity":
+		case "br":
+			body = io.NopCloser(brotli.NewReader(r.Body))
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr.IOReadCloser()
+		}
+		if body != nil {
+			defer body.Close()
+			r.Body = body
+			// The size of the decompressed body isn't known.
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
 		}
 
 		next.ServeHTTP(w, r)
@@ -0,0 +1,214 @@
+// Package jsonutil holds helpers to read JSON payloads without decoding them
+// entirely.
+package jsonutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNotAnObject = errors.New("jsonutil: not a JSON object")
+
+// Peek returns the string values of top-level fields of a JSON object
+// without decoding the rest of it, so a payload can be inspected before it's
+// decoded into the right type. Fields missing from the object, or null, are
+// missing from the result.
+//
+// It stops reading once every field was found and skips other values
+// without validating them, decoding the payload is what validates it.
+func Peek(b []byte, fields ...string) (map[string]string, error) {
+	raw, err := PeekRaw(b, fields...)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, r := range raw {
+		if r[0] != '"' {
+			return nil, fmt.Errorf("jsonutil: field %q is not a string", key)
+		}
+		var v string
+		err := json.Unmarshal(r, &v)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+// PeekRaw is like Peek but returns the encoded values of the fields,
+// whatever their type, so fields holding numbers can be read too. The values
+// point into b.
+func PeekRaw(b []byte, fields ...string) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage, len(fields))
+	s := scanner{b: b}
+	s.skipSpaces()
+	if !s.consume('{') {
+		return nil, ErrNotAnObject
+	}
+	s.skipSpaces()
+	if s.consume('}') {
+		return values, nil
+	}
+	for {
+		s.skipSpaces()
+		key, err := s.key()
+		if err != nil {
+			return nil, err
+		}
+		s.skipSpaces()
+		if !s.consume(':') {
+			return nil, s.syntaxError()
+		}
+		s.skipSpaces()
+		start := s.i
+		err = s.skipValue()
+		if err != nil {
+			return nil, err
+		}
+		if wanted(fields, key) && s.b[start] != 'n' {
+			values[key] = s.b[start:s.i]
+			if len(values) == len(fields) {
+				return values, nil
+			}
+		}
+		s.skipSpaces()
+		if s.consume(',') {
+			continue
+		}
+		if s.consume('}') {
+			return values, nil
+		}
+		return nil, s.syntaxError()
+	}
+}
+
+func wanted(fields []string, key string) bool {
+	for _, f := range fields {
+		if f == key {
+			return true
+		}
+	}
+	return false
+}
+
+type scanner struct {
+	b []byte
+	i int
+}
+
+func (s *scanner) peek() byte {
+	if s.i >= len(s.b) {
+		return 0
+	}
+	return s.b[s.i]
+}
+
+func (s *scanner) consume(c byte) bool {
+	if s.peek() != c {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *scanner) skipSpaces() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) syntaxError() error {
+	if s.i >= len(s.b) {
+		return io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("jsonutil: invalid character %q at offset %d", s.b[s.i], s.i)
+}
+
+// key reads an object key. Keys are only unescaped when they need to be.
+func (s *scanner) key() (string, error) {
+	if s.peek() != '"' {
+		return "", s.syntaxError()
+	}
+	start := s.i
+	err := s.skipString()
+	if err != nil {
+		return "", err
+	}
+	raw := s.b[start+1 : s.i-1]
+	for _, c := range raw {
+		if c == '\\' {
+			var k string
+			err := json.Unmarshal(s.b[start:s.i], &k)
+			return k, err
+		}
+	}
+	return string(raw), nil
+}
+
+// skipString moves past a string, starting at its opening quote.
+func (s *scanner) skipString() error {
+	s.i++
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case '\\':
+			s.i += 2
+		case '"':
+			s.i++
+			return nil
+		default:
+			s.i++
+		}
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// skipValue moves past a value of any type.
+func (s *scanner) skipValue() error {
+	switch s.peek() {
+	case '"':
+		return s.skipString()
+	case '{', '[':
+		var depth int
+		for s.i < len(s.b) {
+			switch s.b[s.i] {
+			case '"':
+				err := s.skipString()
+				if err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					s.i++
+					return nil
+				}
+			}
+			s.i++
+		}
+		return io.ErrUnexpectedEOF
+	default:
+		start := s.i
+		for s.i < len(s.b) {
+			switch s.b[s.i] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				if s.i == start {
+					return s.syntaxError()
+				}
+				return nil
+			}
+			s.i++
+		}
+		return io.ErrUnexpectedEOF
+	}
+}
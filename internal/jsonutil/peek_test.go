@@ -0,0 +1,95 @@
+package jsonutil
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestPeek(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name:    "fields after nested values",
+			payload: `{"profile":{"samples":[{"a":"}"},[1,2]]},"n":-1.5e3,"ok":true,"platform":"python","version":"2"}`,
+			want:    map[string]string{"platform": "python", "version": "2"},
+		},
+		{
+			name:    "escaped strings",
+			payload: ` { "a\"b" : "x\\\"" , "platform" : "python", "version": "1" } `,
+			want:    map[string]string{"platform": "python", "version": "1"},
+		},
+		{
+			name:    "missing field",
+			payload: `{"platform":"android","version":null}`,
+			want:    map[string]string{"platform": "android"},
+		},
+		{
+			name:    "empty object",
+			payload: `{}`,
+			want:    map[string]string{},
+		},
+		{
+			name:    "stops once every field was found",
+			payload: `{"version":"2","platform":"node",`,
+			want:    map[string]string{"platform": "node", "version": "2"},
+		},
+		{
+			name:    "not an object",
+			payload: `["platform","python"]`,
+			wantErr: ErrNotAnObject,
+		},
+		{
+			name:    "truncated",
+			payload: `{"profile":{"samples":[`,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Peek([]byte(test.payload), "platform", "version")
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected error %v, got: %v", test.wantErr, err)
+			}
+			if diff := testutil.Diff(got, test.want); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPeekInvalid(t *testing.T) {
+	for _, payload := range []string{
+		`{"version":2}`,
+		`{"a" 1}`,
+		`{"a":1 "b":2}`,
+		`{"a":,"version":"1"}`,
+		`{a:1}`,
+	} {
+		if _, err := Peek([]byte(payload), "platform", "version"); err == nil {
+			t.Fatalf("expected an error for %s", payload)
+		}
+	}
+}
+
+func TestPeekRaw(t *testing.T) {
+	payload := `{"organization_id":1,"profile":{"samples":[]},"platform":"cocoa","project_id":null}`
+	got, err := PeekRaw([]byte(payload), "organization_id", "platform", "project_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]json.RawMessage{
+		"organization_id": json.RawMessage(`1`),
+		"platform":        json.RawMessage(`"cocoa"`),
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
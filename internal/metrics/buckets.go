@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"math"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+// FunctionsBucket holds the functions running during a time bucket, from
+// StartNS included to EndNS excluded.
+type FunctionsBucket struct {
+	StartNS   uint64
+	EndNS     uint64
+	Functions []nodetree.CallTreeFunction
+}
+
+// ExtractFunctionsFromCallTreesPerBucket splits the call trees in fixed
+// windows of bucketSizeNS, aligned on multiples of it, and collects the
+// functions of each window. Nodes overlapping several windows are split
+// between them. Windows without any function are skipped.
+func ExtractFunctionsFromCallTreesPerBucket[T comparable](
+	callTrees map[T][]*nodetree.Node,
+	minDepth uint,
+	threads ThreadNamer[T],
+	bucketSizeNS uint64,
+) []FunctionsBucket {
+	var startNS, endNS uint64
+	first := true
+	for _, callTreesForThread := range callTrees {
+		for _, root := range callTreesForThread {
+			if first || root.StartNS < startNS {
+				startNS = root.StartNS
+			}
+			if first || root.EndNS > endNS {
+				endNS = root.EndNS
+			}
+			first = false
+		}
+	}
+	if first || bucketSizeNS == 0 {
+		return nil
+	}
+
+	var buckets []FunctionsBucket
+	for bucketStart := startNS - startNS%bucketSizeNS; bucketStart < endNS; bucketStart += bucketSizeNS {
+		bucketEnd := bucketStart + bucketSizeNS
+		bucketCallTrees := make(map[T][]*nodetree.Node, len(callTrees))
+		for tid, callTreesForThread := range callTrees {
+			if clipped := clipCallTrees(callTreesForThread, bucketStart, bucketEnd); len(clipped) > 0 {
+				bucketCallTrees[tid] = clipped
+			}
+		}
+		functions := ExtractFunctionsFromCallTrees(bucketCallTrees, minDepth, threads)
+		if len(functions) == 0 {
+			continue
+		}
+		buckets = append(buckets, FunctionsBucket{
+			StartNS:   bucketStart,
+			EndNS:     bucketEnd,
+			Functions: functions,
+		})
+	}
+	return buckets
+}
+
+// clipCallTrees returns copies of the nodes restricted to the interval
+// between startNS and endNS. Sample counts are scaled to the time left.
+func clipCallTrees(nodes []*nodetree.Node, startNS, endNS uint64) []*nodetree.Node {
+	var clipped []*nodetree.Node
+	for _, n := range nodes {
+		start := max(n.StartNS, startNS)
+		end := min(n.EndNS, endNS)
+		if end <= start {
+			continue
+		}
+		c := n.ShallowCopyWithoutChildren()
+		c.StartNS = start
+		c.EndNS = end
+		c.DurationNS = end - start
+		c.DurationsNS = []uint64{c.DurationNS}
+		if c.DurationNS < n.DurationNS {
+			ratio := float64(c.DurationNS) / float64(n.DurationNS)
+			c.SampleCount = int(math.Ceil(float64(n.SampleCount) * ratio))
+		}
+		c.Children = clipCallTrees(n.Children, startNS, endNS)
+		clipped = append(clipped, c)
+	}
+	return clipped
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+func TestExtractFunctionsFromCallTreesPerBucket(t *testing.T) {
+	second := uint64(time.Second)
+	root := nodetree.NodeFromFrame(frame.Frame{Function: "a", Package: "pkg"}, 5*second, 25*second, 0)
+	root.SampleCount = 20
+	child := nodetree.NodeFromFrame(frame.Frame{Function: "b", Package: "pkg"}, 10*second, 12*second, 0)
+	child.SampleCount = 2
+	root.Children = []*nodetree.Node{child}
+
+	buckets := ExtractFunctionsFromCallTreesPerBucket(
+		map[string][]*nodetree.Node{"1": {root}},
+		0,
+		nil,
+		10*second,
+	)
+
+	type bucketFunction struct {
+		startNS     uint64
+		function    string
+		durationNS  uint64
+		sampleCount int
+	}
+	want := []bucketFunction{
+		{startNS: 0, function: "a", durationNS: 5 * second, sampleCount: 5},
+		{startNS: 10 * second, function: "a", durationNS: 10 * second, sampleCount: 10},
+		{startNS: 10 * second, function: "b", durationNS: 2 * second, sampleCount: 2},
+		{startNS: 20 * second, function: "a", durationNS: 5 * second, sampleCount: 5},
+	}
+	var got []bucketFunction
+	for _, b := range buckets {
+		if b.EndNS != b.StartNS+10*second {
+			t.Fatalf("expected a 10s bucket, got %d to %d", b.StartNS, b.EndNS)
+		}
+		for _, f := range b.Functions {
+			got = append(got, bucketFunction{
+				startNS:     b.StartNS,
+				function:    f.Function,
+				durationNS:  f.SumDurationNS,
+				sampleCount: f.SampleCount,
+			})
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d functions, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("function %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// The original call tree is left untouched.
+	if root.DurationNS != 20*second || root.SampleCount != 20 {
+		t.Fatalf("call tree was modified: %+v", root)
+	}
+}
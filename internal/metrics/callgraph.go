@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/quantile"
+)
+
+type (
+	// CallGraphFunction holds the metrics of a function in a call graph.
+	// For callers, durations are the ones of the function we build the call
+	// graph for when called by them. For callees, durations are their own
+	// when called by it.
+	CallGraphFunction struct {
+		Name        string                     `json:"name"`
+		Package     string                     `json:"package"`
+		Fingerprint uint32                     `json:"fingerprint"`
+		InApp       bool                       `json:"in_app"`
+		P75         uint64                     `json:"p75"`
+		P95         uint64                     `json:"p95"`
+		P99         uint64                     `json:"p99"`
+		Avg         float64                    `json:"avg"`
+		Sum         uint64                     `json:"sum"`
+		Count       uint64                     `json:"count"`
+		Examples    []examples.ExampleMetadata `json:"examples"`
+	}
+
+	CallGraph struct {
+		Function CallGraphFunction   `json:"function"`
+		Callers  []CallGraphFunction `json:"callers"`
+		Callees  []CallGraphFunction `json:"callees"`
+	}
+
+	// CallGraphAggregator aggregates the callers and callees of the function
+	// matching Fingerprint across call trees.
+	CallGraphAggregator struct {
+		Fingerprint      uint32
+		MaxNumOfExamples uint
+
+		function callGraphNode
+		callers  map[uint32]*callGraphNode
+		callees  map[uint32]*callGraphNode
+	}
+
+	callGraphNode struct {
+		name        string
+		pkg         string
+		fingerprint uint32
+		inApp       bool
+		durations   *quantile.Sketch
+		sum         uint64
+		count       uint64
+		examples    []examples.ExampleMetadata
+	}
+)
+
+func NewCallGraphAggregator(fingerprint uint32, maxNumOfExamples uint) CallGraphAggregator {
+	return CallGraphAggregator{
+		Fingerprint:      fingerprint,
+		MaxNumOfExamples: maxNumOfExamples,
+		callers:          make(map[uint32]*callGraphNode),
+		callees:          make(map[uint32]*callGraphNode),
+	}
+}
+
+// AddCallTrees walks the call trees and records the callers and callees of
+// every node matching the fingerprint.
+func (cga *CallGraphAggregator) AddCallTrees(callTrees []*nodetree.Node, example examples.ExampleMetadata) {
+	for _, root := range callTrees {
+		cga.visit(root, nil, example)
+	}
+}
+
+func (cga *CallGraphAggregator) visit(n, parent *nodetree.Node, example examples.ExampleMetadata) {
+	if n.Frame.Fingerprint() == cga.Fingerprint {
+		cga.function.add(n, n.DurationNS, n.SampleCount, example, cga.MaxNumOfExamples)
+		if parent != nil {
+			caller := cga.node(cga.callers, parent)
+			caller.add(parent, n.DurationNS, n.SampleCount, example, cga.MaxNumOfExamples)
+		}
+		for _, c := range n.Children {
+			callee := cga.node(cga.callees, c)
+			callee.add(c, c.DurationNS, c.SampleCount, example, cga.MaxNumOfExamples)
+		}
+	}
+	for _, c := range n.Children {
+		cga.visit(c, n, example)
+	}
+}
+
+func (cga *CallGraphAggregator) node(nodes map[uint32]*callGraphNode, n *nodetree.Node) *callGraphNode {
+	fingerprint := n.Frame.Fingerprint()
+	cgn, exists := nodes[fingerprint]
+	if !exists {
+		cgn = &callGraphNode{}
+		nodes[fingerprint] = cgn
+	}
+	return cgn
+}
+
+func (cgn *callGraphNode) add(
+	n *nodetree.Node,
+	durationNS uint64,
+	sampleCount int,
+	example examples.ExampleMetadata,
+	maxNumOfExamples uint,
+) {
+	if cgn.durations == nil {
+		cgn.name = n.Frame.Function
+		cgn.pkg = n.Frame.ModuleOrPackage()
+		cgn.fingerprint = n.Frame.Fingerprint()
+		cgn.inApp = n.IsApplication
+		cgn.durations = quantile.New()
+	}
+	cgn.durations.Add(durationNS)
+	cgn.sum += durationNS
+	cgn.count += uint64(sampleCount)
+	if uint(len(cgn.examples)) >= maxNumOfExamples {
+		return
+	}
+	for _, e := range cgn.examples {
+		if e == example {
+			return
+		}
+	}
+	cgn.examples = append(cgn.examples, example)
+}
+
+func (cgn *callGraphNode) toCallGraphFunction() CallGraphFunction {
+	p75, _ := cgn.durations.Quantile(0.75)
+	p95, _ := cgn.durations.Quantile(0.95)
+	p99, _ := cgn.durations.Quantile(0.99)
+	var avg float64
+	if c := cgn.durations.Count(); c > 0 {
+		avg = float64(cgn.sum) / float64(c)
+	}
+	return CallGraphFunction{
+		Name:        cgn.name,
+		Package:     cgn.pkg,
+		Fingerprint: cgn.fingerprint,
+		InApp:       cgn.inApp,
+		P75:         p75,
+		P95:         p95,
+		P99:         p99,
+		Avg:         avg,
+		Sum:         cgn.sum,
+		Count:       cgn.count,
+		Examples:    cgn.examples,
+	}
+}
+
+// ToCallGraph returns the callers and callees sorted by the time they account
+// for.
+func (cga *CallGraphAggregator) ToCallGraph() CallGraph {
+	function := cga.function.toCallGraphFunction()
+	function.Fingerprint = cga.Fingerprint
+	return CallGraph{
+		Function: function,
+		Callers:  sortedCallGraphFunctions(cga.callers),
+		Callees:  sortedCallGraphFunctions(cga.callees),
+	}
+}
+
+func sortedCallGraphFunctions(nodes map[uint32]*callGraphNode) []CallGraphFunction {
+	functions := make([]CallGraphFunction, 0, len(nodes))
+	for _, n := range nodes {
+		functions = append(functions, n.toCallGraphFunction())
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Sum != functions[j].Sum {
+			return functions[i].Sum > functions[j].Sum
+		}
+		return functions[i].Fingerprint < functions[j].Fingerprint
+	})
+	return functions
+}
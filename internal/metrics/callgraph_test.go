@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func newCallGraphTestNode(function string, start, end uint64, children ...*nodetree.Node) *nodetree.Node {
+	n := nodetree.NodeFromFrame(frame.Frame{Function: function, Package: "pkg"}, start, end, 0)
+	n.Children = children
+	return n
+}
+
+func TestCallGraphAggregator(t *testing.T) {
+	target := frame.Frame{Function: "target", Package: "pkg"}
+	fingerprint := func(function string) uint32 {
+		return frame.Frame{Function: function, Package: "pkg"}.Fingerprint()
+	}
+
+	cga := NewCallGraphAggregator(target.Fingerprint(), 1)
+	cga.AddCallTrees([]*nodetree.Node{
+		newCallGraphTestNode("main", 0, 100,
+			newCallGraphTestNode("target", 0, 60,
+				newCallGraphTestNode("b", 0, 40),
+				newCallGraphTestNode("c", 40, 50),
+			),
+		),
+	}, examples.ExampleMetadata{ProfileID: "1"})
+	cga.AddCallTrees([]*nodetree.Node{
+		newCallGraphTestNode("worker", 0, 30,
+			newCallGraphTestNode("target", 0, 20,
+				newCallGraphTestNode("b", 0, 20),
+			),
+		),
+	}, examples.ExampleMetadata{ProfileID: "2"})
+
+	want := CallGraph{
+		Function: CallGraphFunction{
+			Name:        "target",
+			Package:     "pkg",
+			Fingerprint: target.Fingerprint(),
+			InApp:       true,
+			P75:         60,
+			P95:         60,
+			P99:         60,
+			Avg:         40,
+			Sum:         80,
+			Count:       2,
+			Examples:    []examples.ExampleMetadata{{ProfileID: "1"}},
+		},
+		Callers: []CallGraphFunction{
+			{
+				Name:        "main",
+				Package:     "pkg",
+				Fingerprint: fingerprint("main"),
+				InApp:       true,
+				P75:         60,
+				P95:         60,
+				P99:         60,
+				Avg:         60,
+				Sum:         60,
+				Count:       1,
+				Examples:    []examples.ExampleMetadata{{ProfileID: "1"}},
+			},
+			{
+				Name:        "worker",
+				Package:     "pkg",
+				Fingerprint: fingerprint("worker"),
+				InApp:       true,
+				P75:         20,
+				P95:         20,
+				P99:         20,
+				Avg:         20,
+				Sum:         20,
+				Count:       1,
+				Examples:    []examples.ExampleMetadata{{ProfileID: "2"}},
+			},
+		},
+		Callees: []CallGraphFunction{
+			{
+				Name:        "b",
+				Package:     "pkg",
+				Fingerprint: fingerprint("b"),
+				InApp:       true,
+				P75:         40,
+				P95:         40,
+				P99:         40,
+				Avg:         30,
+				Sum:         60,
+				Count:       2,
+				Examples:    []examples.ExampleMetadata{{ProfileID: "1"}},
+			},
+			{
+				Name:        "c",
+				Package:     "pkg",
+				Fingerprint: fingerprint("c"),
+				InApp:       true,
+				P75:         10,
+				P95:         10,
+				P99:         10,
+				Avg:         10,
+				Sum:         10,
+				Count:       1,
+				Examples:    []examples.ExampleMetadata{{ProfileID: "1"}},
+			},
+		},
+	}
+
+	if diff := testutil.Diff(cga.ToCallGraph(), want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
+
+func TestCallGraphAggregatorWithoutMatch(t *testing.T) {
+	cga := NewCallGraphAggregator(42, 5)
+	cga.AddCallTrees([]*nodetree.Node{
+		newCallGraphTestNode("main", 0, 100),
+	}, examples.ExampleMetadata{ProfileID: "1"})
+
+	got := cga.ToCallGraph()
+	if got.Function.Count != 0 || len(got.Callers) != 0 || len(got.Callees) != 0 {
+		t.Fatalf("expected an empty call graph, got %+v", got)
+	}
+	if got.Function.Fingerprint != 42 {
+		t.Fatalf("expected fingerprint 42, got %d", got.Function.Fingerprint)
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/getsentry/vroom/internal/examples"
+)
+
+type (
+	FunctionStats struct {
+		P75   uint64 `json:"p75"`
+		P95   uint64 `json:"p95"`
+		P99   uint64 `json:"p99"`
+		Sum   uint64 `json:"sum"`
+		Count uint64 `json:"count"`
+	}
+
+	FunctionStatsDelta struct {
+		P75   int64 `json:"p75"`
+		P95   int64 `json:"p95"`
+		P99   int64 `json:"p99"`
+		Sum   int64 `json:"sum"`
+		Count int64 `json:"count"`
+	}
+
+	// FunctionComparison holds the metrics of a function for 2 sets of
+	// profiles and how they changed from the first one to the second one.
+	// A function missing from one of the sets has no stats for it, and no
+	// delta.
+	FunctionComparison struct {
+		Name        string              `json:"name"`
+		Package     string              `json:"package"`
+		Fingerprint uint32              `json:"fingerprint"`
+		InApp       bool                `json:"in_app"`
+		Before      *FunctionStats      `json:"before"`
+		After       *FunctionStats      `json:"after"`
+		Delta       *FunctionStatsDelta `json:"delta"`
+	}
+)
+
+func newFunctionStats(m examples.FunctionMetrics) *FunctionStats {
+	return &FunctionStats{
+		P75:   m.P75,
+		P95:   m.P95,
+		P99:   m.P99,
+		Sum:   m.Sum,
+		Count: m.Count,
+	}
+}
+
+// CompareAggregators compares the functions aggregated for 2 sets of
+// profiles. Functions are matched over all the functions of both sets, and
+// only then capped to the maxUniqueFunctions changing the most.
+func CompareAggregators(before, after *Aggregator, maxUniqueFunctions uint) []FunctionComparison {
+	comparisons := CompareFunctions(before.allMetrics(), after.allMetrics())
+	if len(comparisons) > int(maxUniqueFunctions) {
+		comparisons = comparisons[:maxUniqueFunctions]
+	}
+	return comparisons
+}
+
+// CompareFunctions matches functions by fingerprint and returns them sorted
+// by their largest p95 increase first. A function missing from a set ranks
+// as if its p95 was 0 there.
+func CompareFunctions(before, after []examples.FunctionMetrics) []FunctionComparison {
+	comparisons := make(map[uint32]*FunctionComparison, len(before)+len(after))
+	for _, m := range before {
+		comparisons[m.Fingerprint] = &FunctionComparison{
+			Name:        m.Name,
+			Package:     m.Package,
+			Fingerprint: m.Fingerprint,
+			InApp:       m.InApp,
+			Before:      newFunctionStats(m),
+		}
+	}
+	for _, m := range after {
+		c, exists := comparisons[m.Fingerprint]
+		if !exists {
+			c = &FunctionComparison{
+				Name:        m.Name,
+				Package:     m.Package,
+				Fingerprint: m.Fingerprint,
+				InApp:       m.InApp,
+			}
+			comparisons[m.Fingerprint] = c
+		}
+		c.After = newFunctionStats(m)
+	}
+	list := make([]FunctionComparison, 0, len(comparisons))
+	for _, c := range comparisons {
+		if c.Before != nil && c.After != nil {
+			c.Delta = &FunctionStatsDelta{
+				P75:   int64(c.After.P75) - int64(c.Before.P75),
+				P95:   int64(c.After.P95) - int64(c.Before.P95),
+				P99:   int64(c.After.P99) - int64(c.Before.P99),
+				Sum:   int64(c.After.Sum) - int64(c.Before.Sum),
+				Count: int64(c.After.Count) - int64(c.Before.Count),
+			}
+		}
+		list = append(list, *c)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		di, dj := list[i].p95Increase(), list[j].p95Increase()
+		if di != dj {
+			return di > dj
+		}
+		return list[i].Fingerprint < list[j].Fingerprint
+	})
+	return list
+}
+
+func (c FunctionComparison) p95Increase() int64 {
+	var before, after int64
+	if c.Before != nil {
+		before = int64(c.Before.P95)
+	}
+	if c.After != nil {
+		after = int64(c.After.P95)
+	}
+	return after - before
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestCompareFunctions(t *testing.T) {
+	before := []examples.FunctionMetrics{
+		{Name: "a", Fingerprint: 1, P75: 10, P95: 20, P99: 30, Sum: 100, Count: 5},
+		{Name: "b", Fingerprint: 2, P75: 10, P95: 20, P99: 30, Sum: 100, Count: 5},
+	}
+	after := []examples.FunctionMetrics{
+		{Name: "a", Fingerprint: 1, P75: 10, P95: 15, P99: 30, Sum: 90, Count: 6},
+		{Name: "b", Fingerprint: 2, P75: 20, P95: 50, P99: 60, Sum: 300, Count: 6},
+		{Name: "c", Fingerprint: 3, P75: 5, P95: 10, P99: 10, Sum: 10, Count: 1},
+	}
+	want := []FunctionComparison{
+		{
+			Name:        "b",
+			Fingerprint: 2,
+			Before:      &FunctionStats{P75: 10, P95: 20, P99: 30, Sum: 100, Count: 5},
+			After:       &FunctionStats{P75: 20, P95: 50, P99: 60, Sum: 300, Count: 6},
+			Delta:       &FunctionStatsDelta{P75: 10, P95: 30, P99: 30, Sum: 200, Count: 1},
+		},
+		{
+			Name:        "c",
+			Fingerprint: 3,
+			After:       &FunctionStats{P75: 5, P95: 10, P99: 10, Sum: 10, Count: 1},
+		},
+		{
+			Name:        "a",
+			Fingerprint: 1,
+			Before:      &FunctionStats{P75: 10, P95: 20, P99: 30, Sum: 100, Count: 5},
+			After:       &FunctionStats{P75: 10, P95: 15, P99: 30, Sum: 90, Count: 6},
+			Delta:       &FunctionStatsDelta{P95: -5, Sum: -10, Count: 1},
+		},
+	}
+	if diff := testutil.Diff(CompareFunctions(before, after), want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
+
+func TestCompareAggregatorsCapsAfterMatching(t *testing.T) {
+	newFunction := func(name string, fingerprint uint32, durationNS uint64) nodetree.CallTreeFunction {
+		return nodetree.CallTreeFunction{
+			Function:      name,
+			Fingerprint:   fingerprint,
+			DurationsNS:   []uint64{durationNS},
+			SumDurationNS: durationNS,
+			SumSelfTimeNS: durationNS,
+			SampleCount:   1,
+		}
+	}
+	before := NewAggregator(1, 1, 0)
+	before.AddFunctions([]nodetree.CallTreeFunction{
+		newFunction("a", 1, 50),
+		newFunction("b", 2, 10),
+	}, examples.ExampleMetadata{ProfileID: "1"})
+	after := NewAggregator(1, 1, 0)
+	after.AddFunctions([]nodetree.CallTreeFunction{
+		newFunction("a", 1, 40),
+		newFunction("b", 2, 30),
+		newFunction("c", 3, 5),
+	}, examples.ExampleMetadata{ProfileID: "2"})
+
+	comparisons := CompareAggregators(&before, &after, 2)
+	want := []FunctionComparison{
+		{
+			Name:        "b",
+			Fingerprint: 2,
+			Before:      &FunctionStats{P75: 10, P95: 10, P99: 10, Sum: 10, Count: 1},
+			After:       &FunctionStats{P75: 30, P95: 30, P99: 30, Sum: 30, Count: 1},
+			Delta:       &FunctionStatsDelta{P75: 20, P95: 20, P99: 20, Sum: 20},
+		},
+		{
+			Name:        "c",
+			Fingerprint: 3,
+			After:       &FunctionStats{P75: 5, P95: 5, P99: 5, Sum: 5, Count: 1},
+		},
+	}
+	if diff := testutil.Diff(comparisons, want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
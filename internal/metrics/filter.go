@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+type (
+	FunctionsFilter struct {
+		InApp   *bool  `json:"in_app,omitempty"`
+		Package string `json:"package,omitempty"`
+		// Name matches functions containing it.
+		Name string `json:"name,omitempty"`
+	}
+
+	SortKey string
+)
+
+const (
+	SortBySumSelfTime SortKey = "sum_self_time"
+	SortBySum         SortKey = "sum"
+	SortByAvg         SortKey = "avg"
+	SortByCount       SortKey = "count"
+	SortByP75         SortKey = "p75"
+	SortByP95         SortKey = "p95"
+	SortByP99         SortKey = "p99"
+)
+
+var ErrInvalidSortKey = errors.New("invalid sort key")
+
+func (f FunctionsFilter) Match(fn nodetree.CallTreeFunction) bool {
+	if f.InApp != nil && *f.InApp != fn.InApp {
+		return false
+	}
+	if f.Package != "" && f.Package != fn.Package {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(fn.Function, f.Name) {
+		return false
+	}
+	return true
+}
+
+func (k SortKey) Validate() error {
+	switch k {
+	case "", SortBySumSelfTime, SortBySum, SortByAvg, SortByCount, SortByP75, SortByP95, SortByP99:
+		return nil
+	}
+	return ErrInvalidSortKey
+}
+
+// sortFunctionMetrics sorts metrics in descending order of the key, using
+// the sum of durations to break ties.
+func sortFunctionMetrics(metrics []examples.FunctionMetrics, key SortKey) {
+	value := func(m examples.FunctionMetrics) float64 {
+		switch key {
+		case SortBySum:
+			return float64(m.Sum)
+		case SortByAvg:
+			return m.Avg
+		case SortByCount:
+			return float64(m.Count)
+		case SortByP75:
+			return float64(m.P75)
+		case SortByP95:
+			return float64(m.P95)
+		case SortByP99:
+			return float64(m.P99)
+		default:
+			return float64(m.SumSelfTime)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		vi, vj := value(metrics[i]), value(metrics[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return metrics[i].Sum > metrics[j].Sum
+	})
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"errors"
 	"math"
+	"regexp"
 	"sort"
 	"strconv"
 
@@ -11,6 +12,12 @@ import (
 )
 
 type (
+	// ThreadNamer is implemented by profiles and chunks to name their threads.
+	ThreadNamer[T comparable] interface {
+		ThreadName(threadID T) string
+		MainThreadID() T
+	}
+
 	FunctionsMetadata struct {
 		MaxVal   uint64
 		Worst    examples.ExampleMetadata
@@ -25,9 +32,21 @@ type (
 		MaxNumOfExamples  uint
 		CallTreeFunctions map[uint32]nodetree.CallTreeFunction
 		FunctionsMetadata map[uint32]FunctionsMetadata
+		// Filter selects which functions are aggregated. If nil, only
+		// application functions are.
+		Filter *FunctionsFilter
+		// SortBy is the key used to sort and cap the metrics. If empty, they
+		// are sorted by self time.
+		SortBy SortKey
 	}
 )
 
+// MainThreadGroup is the name functions running on the main thread are
+// grouped under, whatever the platform calls it.
+const MainThreadGroup = "main"
+
+var threadNumbersRegex = regexp.MustCompile(`[0-9]+`)
+
 func NewAggregator(MaxUniqueFunctions uint, MaxNumOfExamples uint, MinDepth uint) Aggregator {
 	return Aggregator{
 		MaxUniqueFunctions: MaxUniqueFunctions,
@@ -42,7 +61,8 @@ func (ma *Aggregator) AddFunctions(functions []nodetree.CallTreeFunction, result
 	for _, f := range functions {
 		if fn, ok := ma.CallTreeFunctions[f.Fingerprint]; ok {
 			fn.SampleCount += f.SampleCount
-			fn.DurationsNS = append(fn.DurationsNS, f.DurationsNS...)
+			fn.Durations = f.MergeDurationsInto(fn.Durations)
+			fn.Threads = mergeThreads(fn.Threads, f.Threads)
 			fn.SumDurationNS += f.SumDurationNS
 			fn.SumSelfTimeNS += f.SumSelfTimeNS
 			funcMetadata := ma.FunctionsMetadata[f.Fingerprint]
@@ -56,7 +76,11 @@ func (ma *Aggregator) AddFunctions(functions []nodetree.CallTreeFunction, result
 			ma.FunctionsMetadata[f.Fingerprint] = funcMetadata
 			ma.CallTreeFunctions[f.Fingerprint] = fn
 		} else {
-			ma.CallTreeFunctions[f.Fingerprint] = f
+			fn := f
+			fn.Durations = f.MergeDurationsInto(nil)
+			fn.DurationsNS = nil
+			fn.Threads = mergeThreads(nil, f.Threads)
+			ma.CallTreeFunctions[f.Fingerprint] = fn
 			ma.FunctionsMetadata[f.Fingerprint] = FunctionsMetadata{
 				MaxVal:   f.SumSelfTimeNS,
 				Worst:    resultMetadata,
@@ -67,15 +91,22 @@ func (ma *Aggregator) AddFunctions(functions []nodetree.CallTreeFunction, result
 }
 
 func (ma *Aggregator) ToMetrics() []examples.FunctionMetrics {
+	metrics := ma.allMetrics()
+	if len(metrics) > int(ma.MaxUniqueFunctions) {
+		metrics = metrics[:ma.MaxUniqueFunctions]
+	}
+	return metrics
+}
+
+// allMetrics returns the metrics of every function aggregated, sorted but not
+// capped.
+func (ma *Aggregator) allMetrics() []examples.FunctionMetrics {
 	metrics := make([]examples.FunctionMetrics, 0, len(ma.CallTreeFunctions))
 
 	for _, f := range ma.CallTreeFunctions {
-		sort.Slice(f.DurationsNS, func(i, j int) bool {
-			return f.DurationsNS[i] < f.DurationsNS[j]
-		})
-		p75, _ := Quantile(f.DurationsNS, 0.75)
-		p95, _ := Quantile(f.DurationsNS, 0.95)
-		p99, _ := Quantile(f.DurationsNS, 0.99)
+		p75, _ := f.Durations.Quantile(0.75)
+		p95, _ := f.Durations.Quantile(0.95)
+		p99, _ := f.Durations.Quantile(0.99)
 		metrics = append(metrics, examples.FunctionMetrics{
 			Name:        f.Function,
 			Package:     f.Package,
@@ -84,26 +115,92 @@ func (ma *Aggregator) ToMetrics() []examples.FunctionMetrics {
 			P75:         p75,
 			P95:         p95,
 			P99:         p99,
-			Avg:         float64(f.SumDurationNS) / float64(len(f.DurationsNS)),
+			Avg:         float64(f.SumDurationNS) / float64(f.Durations.Count()),
 			Sum:         f.SumDurationNS,
 			SumSelfTime: f.SumSelfTimeNS,
 			Count:       uint64(f.SampleCount),
 			Worst:       ma.FunctionsMetadata[f.Fingerprint].Worst,
 			Examples:    ma.FunctionsMetadata[f.Fingerprint].Examples,
+			Threads:     threadsMetrics(f.Threads),
+		})
+	}
+	sortFunctionMetrics(metrics, ma.SortBy)
+	return metrics
+}
+
+// mergeThreads merges the per thread metrics of src into dst, keeping their
+// durations in sketches.
+func mergeThreads(
+	dst map[string]nodetree.FunctionThreadMetrics,
+	src map[string]nodetree.FunctionThreadMetrics,
+) map[string]nodetree.FunctionThreadMetrics {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]nodetree.FunctionThreadMetrics, len(src))
+	}
+	for name, t := range src {
+		thread := dst[name]
+		thread.Durations = t.MergeDurationsInto(thread.Durations)
+		thread.SumDurationNS += t.SumDurationNS
+		thread.SumSelfTimeNS += t.SumSelfTimeNS
+		thread.SampleCount += t.SampleCount
+		dst[name] = thread
+	}
+	return dst
+}
+
+// threadsMetrics returns the metrics of a function per thread name, sorted by
+// self time.
+func threadsMetrics(threads map[string]nodetree.FunctionThreadMetrics) []examples.FunctionThreadMetrics {
+	if len(threads) == 0 {
+		return nil
+	}
+	metrics := make([]examples.FunctionThreadMetrics, 0, len(threads))
+	for name, t := range threads {
+		p75, _ := t.Durations.Quantile(0.75)
+		p95, _ := t.Durations.Quantile(0.95)
+		p99, _ := t.Durations.Quantile(0.99)
+		metrics = append(metrics, examples.FunctionThreadMetrics{
+			Name:        name,
+			P75:         p75,
+			P95:         p95,
+			P99:         p99,
+			Avg:         float64(t.SumDurationNS) / float64(t.Durations.Count()),
+			Sum:         t.SumDurationNS,
+			SumSelfTime: t.SumSelfTimeNS,
+			Count:       uint64(t.SampleCount),
 		})
 	}
 	sort.Slice(metrics, func(i, j int) bool {
 		if metrics[i].SumSelfTime != metrics[j].SumSelfTime {
 			return metrics[i].SumSelfTime > metrics[j].SumSelfTime
 		}
-		return metrics[i].Sum > metrics[j].Sum
+		return metrics[i].Name < metrics[j].Name
 	})
-	if len(metrics) > int(ma.MaxUniqueFunctions) {
-		metrics = metrics[:ma.MaxUniqueFunctions]
-	}
 	return metrics
 }
 
+// SelectFunctions filters and caps the functions extracted from a profile
+// before they are aggregated.
+func (ma *Aggregator) SelectFunctions(functions []nodetree.CallTreeFunction) []nodetree.CallTreeFunction {
+	if ma.Filter == nil {
+		return CapAndFilterFunctions(functions, int(ma.MaxUniqueFunctions), true)
+	}
+	selected := make([]nodetree.CallTreeFunction, 0, min(int(ma.MaxUniqueFunctions), len(functions)))
+	for _, f := range functions {
+		if !ma.Filter.Match(f) {
+			continue
+		}
+		selected = append(selected, f)
+		if len(selected) == int(ma.MaxUniqueFunctions) {
+			break
+		}
+	}
+	return selected
+}
+
 func Quantile(values []uint64, q float64) (uint64, error) {
 	if len(values) == 0 {
 		return 0, errors.New("cannot compute percentile from empty list")
@@ -115,22 +212,40 @@ func Quantile(values []uint64, q float64) (uint64, error) {
 	return values[index], nil
 }
 
+// ThreadGroup returns the name we break down the functions running on a
+// thread by. Numbers are stripped from thread names so threads from the same
+// pool are grouped together.
+func ThreadGroup[T comparable](threads ThreadNamer[T], threadID T) string {
+	if threads == nil {
+		return ""
+	}
+	if threadID == threads.MainThreadID() {
+		return MainThreadGroup
+	}
+	return threadNumbersRegex.ReplaceAllString(threads.ThreadName(threadID), "*")
+}
+
 func ExtractFunctionsFromCallTreesForThread(
 	callTreesForThread []*nodetree.Node,
+	threadID string,
+	threadName string,
 	minDepth uint,
 ) []nodetree.CallTreeFunction {
 	functions := make(map[uint32]nodetree.CallTreeFunction, 0)
 
 	for _, callTree := range callTreesForThread {
-		callTree.CollectFunctions(functions, "", 0, minDepth)
+		callTree.CollectFunctions(functions, threadID, threadName, 0, minDepth)
 	}
 
 	return mergeAndSortFunctions(functions)
 }
 
+// ExtractFunctionsFromCallTrees collects the functions of all threads. If
+// threads is not nil, the metrics are also broken down by thread name.
 func ExtractFunctionsFromCallTrees[T comparable](
 	callTrees map[T][]*nodetree.Node,
 	minDepth uint,
+	threads ThreadNamer[T],
 ) []nodetree.CallTreeFunction {
 	functions := make(map[uint32]nodetree.CallTreeFunction, 0)
 	for tid, callTreesForThread := range callTrees {
@@ -140,8 +255,9 @@ func ExtractFunctionsFromCallTrees[T comparable](
 		} else if t, ok := any(tid).(uint64); ok {
 			threadID = strconv.FormatUint(t, 10)
 		}
+		threadName := ThreadGroup(threads, tid)
 		for _, callTree := range callTreesForThread {
-			callTree.CollectFunctions(functions, threadID, 0, minDepth)
+			callTree.CollectFunctions(functions, threadID, threadName, 0, minDepth)
 		}
 	}
 
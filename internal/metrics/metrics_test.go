@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/frame"
 	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/quantile"
 	"github.com/getsentry/vroom/internal/testutil"
 )
 
@@ -41,14 +43,14 @@ func TestAggregatorAddFunctions(t *testing.T) {
 						Function:      "a",
 						Fingerprint:   0,
 						SumSelfTimeNS: 80,
-						DurationsNS:   []uint64{10, 5, 25, 10, 5, 25},
+						Durations:     quantile.FromValues([]uint64{10, 5, 25, 10, 5, 25}),
 						SumDurationNS: 80,
 					},
 					1: {
 						Function:      "b",
 						Fingerprint:   1,
 						SumSelfTimeNS: 210,
-						DurationsNS:   []uint64{45, 60, 45, 60},
+						Durations:     quantile.FromValues([]uint64{45, 60, 45, 60}),
 						SumDurationNS: 210,
 					},
 				},
@@ -94,7 +96,7 @@ func TestAggregatorToMetrics(t *testing.T) {
 					0: {
 						Function:      "a",
 						Fingerprint:   0,
-						DurationsNS:   []uint64{1, 2, 3, 4, 10, 8, 7, 11, 20},
+						Durations:     quantile.FromValues([]uint64{1, 2, 3, 4, 10, 8, 7, 11, 20}),
 						SumDurationNS: 66,
 						SumSelfTimeNS: 66,
 						SampleCount:   2,
@@ -102,7 +104,7 @@ func TestAggregatorToMetrics(t *testing.T) {
 					1: {
 						Function:      "b",
 						Fingerprint:   1,
-						DurationsNS:   []uint64{1, 2, 3, 4, 10, 8, 7, 11, 20},
+						Durations:     quantile.FromValues([]uint64{1, 2, 3, 4, 10, 8, 7, 11, 20}),
 						SumDurationNS: 66,
 						SumSelfTimeNS: 66,
 						SampleCount:   2,
@@ -162,3 +164,116 @@ func TestAggregatorToMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestAggregatorSelectFunctions(t *testing.T) {
+	inApp := false
+	functions := []nodetree.CallTreeFunction{
+		{Function: "parse", Package: "app", InApp: true},
+		{Function: "read", Package: "libc", InApp: false},
+		{Function: "readAll", Package: "libc", InApp: false},
+		{Function: "write", Package: "libc", InApp: false},
+	}
+	tests := []struct {
+		name   string
+		filter *FunctionsFilter
+		want   []string
+	}{
+		{
+			name: "Only application functions by default",
+			want: []string{"parse"},
+		},
+		{
+			name:   "Filter by in app, package and name",
+			filter: &FunctionsFilter{InApp: &inApp, Package: "libc", Name: "read"},
+			want:   []string{"read", "readAll"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ma := NewAggregator(100, 5, 0)
+			ma.Filter = tt.filter
+			var got []string
+			for _, f := range ma.SelectFunctions(functions) {
+				got = append(got, f.Function)
+			}
+			if diff := testutil.Diff(got, tt.want); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
+
+type testThreads map[uint64]string
+
+func (t testThreads) ThreadName(threadID uint64) string {
+	return t[threadID]
+}
+
+func (t testThreads) MainThreadID() uint64 {
+	return 1
+}
+
+func TestExtractFunctionsByThread(t *testing.T) {
+	newCallTree := func(start, end uint64) []*nodetree.Node {
+		root := nodetree.NodeFromFrame(frame.Frame{Function: "a", Package: "pkg"}, start, end, 1)
+		root.SampleCount = 2
+		return []*nodetree.Node{root}
+	}
+	callTrees := map[uint64][]*nodetree.Node{
+		1: newCallTree(0, 10),
+		2: newCallTree(0, 20),
+		3: newCallTree(0, 30),
+	}
+	threads := testThreads{
+		1: "com.apple.main-thread",
+		2: "pool-1-thread-1",
+		3: "pool-1-thread-2",
+	}
+
+	functions := ExtractFunctionsFromCallTrees(callTrees, 0, threads)
+	if len(functions) != 1 {
+		t.Fatalf("expected 1 function, got %d", len(functions))
+	}
+
+	ma := NewAggregator(100, 5, 0)
+	ma.AddFunctions(functions, examples.ExampleMetadata{ProfileID: "1"})
+	got := ma.ToMetrics()
+	want := []examples.FunctionThreadMetrics{
+		{
+			Name:        "pool-*-thread-*",
+			P75:         30,
+			P95:         30,
+			P99:         30,
+			Avg:         25,
+			Sum:         50,
+			SumSelfTime: 50,
+			Count:       4,
+		},
+		{
+			Name:        "main",
+			P75:         10,
+			P95:         10,
+			P99:         10,
+			Avg:         10,
+			Sum:         10,
+			SumSelfTime: 10,
+			Count:       2,
+		},
+	}
+	if diff := testutil.Diff(got[0].Threads, want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
+
+func TestExtractFunctionsWithoutThreads(t *testing.T) {
+	root := nodetree.NodeFromFrame(frame.Frame{Function: "a", Package: "pkg"}, 0, 10, 1)
+	root.SampleCount = 2
+	functions := ExtractFunctionsFromCallTrees(map[string][]*nodetree.Node{"1": {root}}, 0, nil)
+	if len(functions) != 1 {
+		t.Fatalf("expected 1 function, got %d", len(functions))
+	}
+	if functions[0].Threads != nil {
+		t.Fatalf("expected no thread breakdown, got %v", functions[0].Threads)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/getsentry/vroom/internal/examples"
 	"github.com/getsentry/vroom/internal/frame"
 	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/quantile"
 )
 
 var (
@@ -41,6 +42,7 @@ type (
 		Path          string  `json:"path,omitempty"`
 
 		DurationsNS []uint64                              `json:"-"`
+		Durations   *quantile.Sketch                      `json:"-"`
 		EndNS       uint64                                `json:"-"`
 		Frame       frame.Frame                           `json:"-"`
 		Occurrence  uint32                                `json:"-"`
@@ -125,6 +127,7 @@ func (n *Node) ShallowCopyWithoutChildren() *Node {
 		DurationNS:    n.DurationNS,
 		SelfTimeNS:    n.SelfTimeNS,
 		DurationsNS:   n.DurationsNS,
+		Durations:     n.Durations.Clone(),
 	}
 
 	return &clone
@@ -146,6 +149,12 @@ func (n *Node) SetDuration(t uint64) {
 	n.DurationsNS = []uint64{n.DurationNS}
 }
 
+// MergeDurationsInto records the durations of the node into s and returns it,
+// allocating a new sketch if s is nil and the node has durations.
+func (n *Node) MergeDurationsInto(s *quantile.Sketch) *quantile.Sketch {
+	return mergeDurationsInto(s, n.Durations, n.DurationsNS)
+}
+
 func (n *Node) WriteToHash(h hash.Hash) {
 	if n.Package == "" && n.Name == "" {
 		h.Write([]byte("-"))
@@ -155,17 +164,34 @@ func (n *Node) WriteToHash(h hash.Hash) {
 	}
 }
 
+// CallTreeFunction holds the metrics of a function in a profile. Durations
+// are listed in DurationsNS for a single profile and merged into the
+// Durations sketch once functions from several profiles are aggregated.
+// Messages sent downstream carry one or the other, depending on the version
+// of the functions schema. Threads breaks those metrics down by thread name.
 type CallTreeFunction struct {
-	Fingerprint   uint32   `json:"fingerprint"`
-	Function      string   `json:"function"`
-	Package       string   `json:"package"`
-	InApp         bool     `json:"in_app"`
-	DurationsNS   []uint64 `json:"durations_ns"`
-	SumDurationNS uint64   `json:"-"`
-	SumSelfTimeNS uint64   `json:"-"`
-	SampleCount   int      `json:"-"`
-	ThreadID      string   `json:"thread_id"`
-	MaxDuration   uint64   `json:"-"`
+	Fingerprint   uint32                           `json:"fingerprint"`
+	Function      string                           `json:"function"`
+	Package       string                           `json:"package"`
+	InApp         bool                             `json:"in_app"`
+	DurationsNS   []uint64                         `json:"durations_ns,omitempty"`
+	Durations     *quantile.Sketch                 `json:"durations_sketch,omitempty"`
+	SumDurationNS uint64                           `json:"-"`
+	SumSelfTimeNS uint64                           `json:"-"`
+	SampleCount   int                              `json:"-"`
+	ThreadID      string                           `json:"thread_id"`
+	MaxDuration   uint64                           `json:"-"`
+	Threads       map[string]FunctionThreadMetrics `json:"threads,omitempty"`
+}
+
+// FunctionThreadMetrics holds the metrics of a function on the threads
+// sharing a name.
+type FunctionThreadMetrics struct {
+	DurationsNS   []uint64         `json:"durations_ns,omitempty"`
+	Durations     *quantile.Sketch `json:"durations_sketch,omitempty"`
+	SumDurationNS uint64           `json:"-"`
+	SumSelfTimeNS uint64           `json:"-"`
+	SampleCount   int              `json:"-"`
 }
 
 // `CollectionFunctions` walks the node tree, collects any function with a non zero
@@ -189,6 +215,7 @@ type CallTreeFunction struct {
 func (n *Node) CollectFunctions(
 	results map[uint32]CallTreeFunction,
 	threadID string,
+	threadName string,
 	nodeDepth uint,
 	minDepth uint,
 ) (uint64, uint64) {
@@ -197,7 +224,7 @@ func (n *Node) CollectFunctions(
 
 	// determine the amount of time spent in application vs system functions in the children
 	for _, child := range n.Children {
-		applicationDurationNS, systemDurationNS := child.CollectFunctions(results, threadID, nodeDepth+1, minDepth)
+		applicationDurationNS, systemDurationNS := child.CollectFunctions(results, threadID, threadName, nodeDepth+1, minDepth)
 		childrenApplicationDurationNS += applicationDurationNS
 		childrenSystemDurationNS += systemDurationNS
 	}
@@ -268,6 +295,20 @@ func (n *Node) CollectFunctions(
 			function.SumSelfTimeNS += selfTimeNS
 		}
 
+		// threadName is empty when we don't know the thread, in which case
+		// we skip the breakdown.
+		if threadName != "" {
+			if function.Threads == nil {
+				function.Threads = make(map[string]FunctionThreadMetrics)
+			}
+			thread := function.Threads[threadName]
+			thread.DurationsNS = append(thread.DurationsNS, n.DurationNS)
+			thread.SumDurationNS += n.DurationNS
+			thread.SumSelfTimeNS += selfTimeNS
+			thread.SampleCount += n.SampleCount
+			function.Threads[threadName] = thread
+		}
+
 		results[fingerprint] = function
 	}
 
@@ -276,6 +317,38 @@ func (n *Node) CollectFunctions(
 	return applicationDurationNS, n.DurationNS - applicationDurationNS
 }
 
+// MergeDurationsInto records the durations of the function into s and
+// returns it, allocating a new sketch if s is nil and the function has
+// durations.
+func (f CallTreeFunction) MergeDurationsInto(s *quantile.Sketch) *quantile.Sketch {
+	return mergeDurationsInto(s, f.Durations, f.DurationsNS)
+}
+
+// MergeDurationsInto records the durations of the function on the thread
+// into s and returns it, allocating a new sketch if s is nil and the function
+// has durations.
+func (t FunctionThreadMetrics) MergeDurationsInto(s *quantile.Sketch) *quantile.Sketch {
+	return mergeDurationsInto(s, t.Durations, t.DurationsNS)
+}
+
+func mergeDurationsInto(s *quantile.Sketch, durations *quantile.Sketch, durationsNS []uint64) *quantile.Sketch {
+	if durations.Count() == 0 && len(durationsNS) == 0 {
+		return s
+	}
+	if s == nil {
+		s = quantile.New()
+	}
+	// The sketch, when present, already contains the raw durations.
+	if durations != nil {
+		s.Merge(durations)
+		return s
+	}
+	for _, d := range durationsNS {
+		s.Add(d)
+	}
+	return s
+}
+
 func shouldAggregateFrame(frame frame.Frame) bool {
 	frameFunction := frame.Function
 
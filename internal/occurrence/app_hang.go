@@ -0,0 +1,145 @@
+package occurrence
+
+import (
+	"strings"
+	"time"
+
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/profile"
+)
+
+type (
+	DetectAppHangOptions struct {
+		// Threshold is the minimum amount of time the main thread needs to be
+		// stuck on the same frame for us to consider the app as hanging.
+		Threshold time.Duration
+	}
+)
+
+const (
+	AppHang Category = "app_hang"
+)
+
+var (
+	// Functions that are expected to be on the main thread for the whole
+	// profile and can't be the cause of a hang.
+	appHangFunctionDenyList = map[platform.Platform]map[string]struct{}{
+		platform.Cocoa: {
+			"main": {},
+		},
+	}
+
+	// Prefixes of the functions a thread waits for events in. A main thread
+	// stuck in them is idle, not hanging, whatever the frames above them.
+	appHangIdleFunctionPrefixes = map[platform.Platform][]string{
+		platform.Cocoa: {
+			"mach_msg",
+			"__CFRunLoopServiceMachPort",
+		},
+	}
+)
+
+// findAppHangCause looks for main thread frames active for longer than the
+// platform threshold and reports the deepest application frame blocking it.
+func findAppHangCause(
+	p profile.Profile,
+	callTreesPerThreadID map[uint64][]*nodetree.Node,
+	findOptions Options,
+	occurrences *[]*Occurrence,
+) {
+	threshold, exists := findOptions.AppHangThresholds[p.Platform()]
+	if !exists || threshold <= 0 {
+		return
+	}
+	options := DetectAppHangOptions{Threshold: threshold}
+	callTrees, exists := callTreesPerThreadID[p.Transaction().ActiveThreadID]
+	if !exists {
+		return
+	}
+	nodes := make(map[nodeKey]nodeInfo)
+	for _, root := range callTrees {
+		ni := findAppHangInCallTree(p.Platform(), root, options)
+		if ni == nil {
+			continue
+		}
+		nk := nodeKey{Package: ni.Node.Package, Function: ni.Node.Name}
+		// Keep the longest hang for a given frame.
+		if n, exists := nodes[nk]; exists && n.Node.DurationNS >= ni.Node.DurationNS {
+			continue
+		}
+		nodes[nk] = *ni
+	}
+	for _, ni := range nodes {
+		*occurrences = append(*occurrences, NewOccurrence(p, ni))
+	}
+}
+
+// findAppHangInCallTree follows the frames staying on the stack for longer
+// than the threshold and returns the deepest application frame among them,
+// along with the full blocking stack.
+func findAppHangInCallTree(
+	pf platform.Platform,
+	root *nodetree.Node,
+	options DetectAppHangOptions,
+) *nodeInfo {
+	threshold := uint64(options.Threshold)
+	if root.DurationNS < threshold {
+		return nil
+	}
+	st := []*nodetree.Node{root}
+	for n := root; ; {
+		var next *nodetree.Node
+		for _, c := range n.Children {
+			if c.DurationNS >= threshold && (next == nil || c.DurationNS > next.DurationNS) {
+				next = c
+			}
+		}
+		if next == nil {
+			break
+		}
+		st = append(st, next)
+		n = next
+	}
+	if isIdleFunction(pf, st[len(st)-1].Frame.Function) {
+		return nil
+	}
+	var culprit *nodetree.Node
+	for i := len(st) - 1; i >= 0; i-- {
+		n := st[i]
+		if !n.IsApplication || n.Frame.Function == "" {
+			continue
+		}
+		if _, denied := appHangFunctionDenyList[pf][n.Frame.Function]; denied {
+			continue
+		}
+		culprit = n
+		break
+	}
+	if culprit == nil {
+		return nil
+	}
+	stackTrace := make([]frame.Frame, 0, len(st))
+	for _, n := range st {
+		stackTrace = append(stackTrace, n.ToFrame())
+	}
+	ni := nodeInfo{
+		Category:   AppHang,
+		Node:       *culprit,
+		StackTrace: stackTrace,
+	}
+	ni.Node.Children = nil
+	return &ni
+}
+
+// isIdleFunction checks if a thread running the function is waiting for
+// events.
+func isIdleFunction(pf platform.Platform, function string) bool {
+	for _, prefix := range appHangIdleFunctionPrefixes[pf] {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
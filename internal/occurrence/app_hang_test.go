@@ -0,0 +1,113 @@
+package occurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestFindAppHangInCallTree(t *testing.T) {
+	tests := []struct {
+		name      string
+		platform  platform.Platform
+		root      *nodetree.Node
+		wantNode  string
+		wantStack []string
+	}{
+		{
+			name:     "Find the deepest application frame blocking the main thread",
+			platform: platform.Android,
+			root: newLockTestNode("android.os.Looper.loop()", "android.os", false, 0, 8*time.Second,
+				newLockTestNode("com.example.MainActivity.onClick()", "com.example", true, time.Second, 7*time.Second,
+					newLockTestNode("com.example.Cache.load()", "com.example", true, time.Second, 7*time.Second,
+						newLockTestNode("java.io.FileInputStream.read()", "java.io", false, time.Second, 7*time.Second),
+					),
+				),
+			),
+			wantNode: "com.example.Cache.load()",
+			wantStack: []string{
+				"android.os.Looper.loop()",
+				"com.example.MainActivity.onClick()",
+				"com.example.Cache.load()",
+				"java.io.FileInputStream.read()",
+			},
+		},
+		{
+			name:     "Main thread making progress",
+			platform: platform.Android,
+			root: newLockTestNode("android.os.Looper.loop()", "android.os", false, 0, 8*time.Second,
+				newLockTestNode("com.example.MainActivity.onClick()", "com.example", true, time.Second, 3*time.Second),
+				newLockTestNode("com.example.MainActivity.onClick()", "com.example", true, 4*time.Second, 7*time.Second),
+			),
+		},
+		{
+			name:     "Idle run loop is not a hang",
+			platform: platform.Cocoa,
+			root: newLockTestNode("main", "app", true, 0, 5*time.Second,
+				newLockTestNode("UIApplicationMain", "UIKitCore", false, 0, 5*time.Second,
+					newLockTestNode("mach_msg_trap", "libsystem_kernel", false, 0, 5*time.Second),
+				),
+			),
+		},
+		{
+			name:     "Idle run loop under another application root is not a hang",
+			platform: platform.Cocoa,
+			root: newLockTestNode("static MoviesApp.$main()", "app", true, 0, 5*time.Second,
+				newLockTestNode("UIApplicationMain", "UIKitCore", false, 0, 5*time.Second,
+					newLockTestNode("__CFRunLoopRun", "CoreFoundation", false, 0, 5*time.Second,
+						newLockTestNode("__CFRunLoopServiceMachPort", "CoreFoundation", false, 0, 5*time.Second,
+							newLockTestNode("mach_msg2_trap", "libsystem_kernel", false, 0, 5*time.Second),
+						),
+					),
+				),
+			),
+		},
+		{
+			name:     "Main thread blocked under another application root",
+			platform: platform.Cocoa,
+			root: newLockTestNode("static MoviesApp.$main()", "app", true, 0, 5*time.Second,
+				newLockTestNode("UIApplicationMain", "UIKitCore", false, 0, 5*time.Second,
+					newLockTestNode("MoviesViewController.viewDidLoad()", "app", true, time.Second, 4*time.Second,
+						newLockTestNode("read", "libsystem_kernel", false, time.Second, 4*time.Second),
+					),
+				),
+			),
+			wantNode: "MoviesViewController.viewDidLoad()",
+			wantStack: []string{
+				"static MoviesApp.$main()",
+				"UIApplicationMain",
+				"MoviesViewController.viewDidLoad()",
+				"read",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := DetectAppHangOptions{Threshold: DefaultOptions().AppHangThresholds[tt.platform]}
+			ni := findAppHangInCallTree(tt.platform, tt.root, options)
+			if tt.wantStack == nil {
+				if ni != nil {
+					t.Fatalf("expected no hang, got %s", ni.Node.Name)
+				}
+				return
+			}
+			if ni == nil {
+				t.Fatal("expected a hang, got none")
+			}
+			if ni.Node.Name != tt.wantNode {
+				t.Fatalf("expected %s, got %s", tt.wantNode, ni.Node.Name)
+			}
+			stack := make([]string, 0, len(ni.StackTrace))
+			for _, f := range ni.StackTrace {
+				stack = append(stack, f.Function)
+			}
+			if diff := testutil.Diff(stack, tt.wantStack); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
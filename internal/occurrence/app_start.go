@@ -0,0 +1,163 @@
+package occurrence
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/profile"
+)
+
+type (
+	DetectAppStartOptions struct {
+		// Thresholds is the minimum app start duration, by transaction
+		// operation, for us to consider the app start as slow.
+		Thresholds map[string]time.Duration
+	}
+)
+
+const (
+	AppStart Category = "app_start"
+
+	AppStartColdOp string = "app.start.cold"
+	AppStartWarmOp string = "app.start.warm"
+)
+
+// appStartMaxFunctions is the number of application functions we report as
+// evidence of a slow app start.
+const appStartMaxFunctions = 5
+
+// findSlowAppStart reports app starts taking longer than the platform
+// threshold and attributes the time to the application functions with the
+// most self time on the active thread.
+func findSlowAppStart(
+	p profile.Profile,
+	callTreesPerThreadID map[uint64][]*nodetree.Node,
+	findOptions Options,
+	occurrences *[]*Occurrence,
+) {
+	thresholds, exists := findOptions.AppStartThresholds[p.Platform()]
+	if !exists {
+		return
+	}
+	options := DetectAppStartOptions{
+		Thresholds: thresholds,
+	}
+	tm := p.TransactionMetadata()
+	threshold, exists := options.Thresholds[tm.TransactionOp]
+	if !exists || threshold <= 0 {
+		return
+	}
+	durationNS := p.DurationNS()
+	if tm.TransactionEnd.After(tm.TransactionStart) && !tm.TransactionStart.IsZero() {
+		durationNS = uint64(tm.TransactionEnd.Sub(tm.TransactionStart))
+	}
+	if durationNS < uint64(threshold) {
+		return
+	}
+	callTrees, exists := callTreesPerThreadID[p.Transaction().ActiveThreadID]
+	if !exists {
+		return
+	}
+	functions := topApplicationFunctions(callTrees, appStartMaxFunctions)
+	if len(functions) == 0 {
+		return
+	}
+	ni, exists := findFunctionNode(callTrees, functions[0].Fingerprint)
+	if !exists {
+		return
+	}
+	ni.Category = AppStart
+	o := NewOccurrence(p, ni)
+	addAppStartEvidence(p, o, durationNS, functions)
+	*occurrences = append(*occurrences, o)
+}
+
+// topApplicationFunctions returns the application functions with the most
+// self time, sorted by descending self time.
+func topApplicationFunctions(callTrees []*nodetree.Node, maxFunctions int) []nodetree.CallTreeFunction {
+	results := make(map[uint32]nodetree.CallTreeFunction)
+	for _, root := range callTrees {
+		root.CollectFunctions(results, "", "", 0, 0)
+	}
+	functions := make([]nodetree.CallTreeFunction, 0, len(results))
+	for _, f := range results {
+		if !f.InApp || f.SumSelfTimeNS == 0 {
+			continue
+		}
+		functions = append(functions, f)
+	}
+	sort.SliceStable(functions, func(i, j int) bool {
+		if functions[i].SumSelfTimeNS == functions[j].SumSelfTimeNS {
+			return functions[i].Fingerprint < functions[j].Fingerprint
+		}
+		return functions[i].SumSelfTimeNS > functions[j].SumSelfTimeNS
+	})
+	if len(functions) > maxFunctions {
+		functions = functions[:maxFunctions]
+	}
+	return functions
+}
+
+// findFunctionNode returns the longest node matching the function fingerprint
+// along with its stack.
+func findFunctionNode(callTrees []*nodetree.Node, fingerprint uint32) (nodeInfo, bool) {
+	var ni nodeInfo
+	var found bool
+	var walk func(n *nodetree.Node, st []frame.Frame)
+	walk = func(n *nodetree.Node, st []frame.Frame) {
+		st = append(st, n.ToFrame())
+		if n.Frame.Fingerprint() == fingerprint && (!found || n.DurationNS > ni.Node.DurationNS) {
+			found = true
+			ni.Node = *n
+			ni.Node.Children = nil
+			ni.StackTrace = make([]frame.Frame, len(st))
+			copy(ni.StackTrace, st)
+		}
+		for _, c := range n.Children {
+			walk(c, st)
+		}
+	}
+	for _, root := range callTrees {
+		walk(root, make([]frame.Frame, 0, profile.MaxStackDepth))
+	}
+	return ni, found
+}
+
+func addAppStartEvidence(
+	p profile.Profile,
+	o *Occurrence,
+	durationNS uint64,
+	functions []nodetree.CallTreeFunction,
+) {
+	appStartDuration := time.Duration(durationNS).Round(10 * time.Microsecond)
+	data := make([]map[string]interface{}, 0, len(functions))
+	for _, f := range functions {
+		data = append(data, map[string]interface{}{
+			"fingerprint":     f.Fingerprint,
+			"function":        f.Function,
+			"package":         f.Package,
+			"self_time_ns":    f.SumSelfTimeNS,
+			"sum_duration_ns": f.SumDurationNS,
+		})
+		o.EvidenceDisplay = append(o.EvidenceDisplay, Evidence{
+			Name: EvidenceNameSelfTime,
+			Value: fmt.Sprintf(
+				"%s: %s (%0.2f%% of the app start)",
+				f.Function,
+				time.Duration(f.SumSelfTimeNS).Round(10*time.Microsecond),
+				float64(f.SumSelfTimeNS*100)/float64(durationNS),
+			),
+		})
+	}
+	o.EvidenceData["app_start_duration_ns"] = durationNS
+	o.EvidenceData["app_start_type"] = p.TransactionMetadata().TransactionOp
+	o.EvidenceData["app_start_functions"] = data
+	o.EvidenceDisplay = append(o.EvidenceDisplay, Evidence{
+		Important: true,
+		Name:      EvidenceNameAppStartDuration,
+		Value:     appStartDuration.String(),
+	})
+}
@@ -0,0 +1,106 @@
+package occurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/profile"
+	"github.com/getsentry/vroom/internal/sample"
+	"github.com/getsentry/vroom/internal/testutil"
+	"github.com/getsentry/vroom/internal/transaction"
+)
+
+func TestFindSlowAppStart(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	callTrees := map[uint64][]*nodetree.Node{
+		1: {
+			newLockTestNode("start", "app", true, 0, 3*time.Second,
+				newLockTestNode("loadConfig", "app", true, 0, 2*time.Second,
+					newLockTestNode("read", "libsystem_kernel", false, 0, 1500*time.Millisecond),
+				),
+				newLockTestNode("setupUI", "app", true, 2*time.Second, 3*time.Second),
+			),
+		},
+	}
+	tests := []struct {
+		name          string
+		op            string
+		end           time.Time
+		thresholds    map[string]time.Duration
+		wantFunction  string
+		wantFunctions []string
+	}{
+		{
+			name:          "Cold start slower than the threshold",
+			op:            AppStartColdOp,
+			end:           start.Add(3 * time.Second),
+			wantFunction:  "loadConfig",
+			wantFunctions: []string{"loadConfig", "setupUI"},
+		},
+		{
+			name: "Cold start faster than the threshold",
+			op:   AppStartColdOp,
+			end:  start.Add(time.Second),
+		},
+		{
+			name:          "Cold start slower than a configured threshold",
+			op:            AppStartColdOp,
+			end:           start.Add(time.Second),
+			thresholds:    map[string]time.Duration{AppStartColdOp: 500 * time.Millisecond},
+			wantFunction:  "loadConfig",
+			wantFunctions: []string{"loadConfig", "setupUI"},
+		},
+		{
+			name: "Not an app start",
+			op:   "ui.load",
+			end:  start.Add(3 * time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := profile.New(&sample.Profile{
+				RawProfile: sample.RawProfile{
+					EventID:  "1234567890",
+					Platform: platform.Cocoa,
+					Transaction: transaction.Transaction{
+						ActiveThreadID: 1,
+					},
+					TransactionMetadata: transaction.Metadata{
+						TransactionOp:    tt.op,
+						TransactionStart: start,
+						TransactionEnd:   tt.end,
+					},
+				},
+			})
+			options := DefaultOptions()
+			if tt.thresholds != nil {
+				options.AppStartThresholds[platform.Cocoa] = tt.thresholds
+			}
+			var occurrences []*Occurrence
+			findSlowAppStart(p, callTrees, options, &occurrences)
+			if tt.wantFunctions == nil {
+				if len(occurrences) != 0 {
+					t.Fatalf("expected no occurrence, got %d", len(occurrences))
+				}
+				return
+			}
+			if len(occurrences) != 1 {
+				t.Fatalf("expected 1 occurrence, got %d", len(occurrences))
+			}
+			o := occurrences[0]
+			if o.Subtitle != tt.wantFunction {
+				t.Fatalf("expected %s, got %s", tt.wantFunction, o.Subtitle)
+			}
+			var functions []string
+			for _, f := range o.EvidenceData["app_start_functions"].([]map[string]interface{}) {
+				functions = append(functions, f["function"].(string))
+			}
+			if diff := testutil.Diff(functions, tt.wantFunctions); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
@@ -317,6 +317,9 @@ var detectFrameJobs = map[platform.Platform][]DetectFrameOptions{
 					"zlib_decode_buffer":      Compression,
 					"zlib_encode_buffer":      Compression,
 				},
+				"libdispatch": {
+					"dispatch_semaphore_wait": ThreadWait,
+				},
 				"libsqlite3.dylib": {
 					"sqlite3_blob_read":      SQL,
 					"sqlite3_column_blob":    SQL,
@@ -351,6 +354,9 @@ var detectFrameJobs = map[platform.Platform][]DetectFrameOptions{
 					"__fread": FileRead,
 					"fread":   FileRead,
 				},
+				"libsystem_pthread": {
+					"pthread_mutex_lock": ThreadWait,
+				},
 				"libxpc.dylib": {
 					"xpc_connection_send_message_with_reply_sync": XPC,
 				},
@@ -436,6 +442,9 @@ var detectFrameJobs = map[platform.Platform][]DetectFrameOptions{
 				"androidx.room": {
 					"androidx.room.RoomDatabase.query": SQL,
 				},
+				"java.lang": {
+					"java.lang.Object.wait": ThreadWait,
+				},
 				"java.util.zip": {
 					"java.util.zip.Deflater.deflate":           Compression,
 					"java.util.zip.Deflater.deflateBytes":      Compression,
@@ -1,11 +1,47 @@
 package occurrence
 
 import (
+	"time"
+
 	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
 	"github.com/getsentry/vroom/internal/profile"
 )
 
-func Find(p profile.Profile, callTrees map[uint64][]*nodetree.Node) []*Occurrence {
+// Options holds the settings of the detectors which can be configured.
+type Options struct {
+	// AppHangThresholds is, per platform, the minimum amount of time the
+	// main thread needs to be stuck on the same frame for us to consider the
+	// app as hanging. Platforms without a threshold aren't checked.
+	AppHangThresholds map[platform.Platform]time.Duration
+	// AppStartThresholds is, per platform and app start transaction
+	// operation, the minimum app start duration for us to consider the app
+	// start as slow.
+	AppStartThresholds map[platform.Platform]map[string]time.Duration
+}
+
+// DefaultOptions returns the thresholds the platforms use themselves.
+func DefaultOptions() Options {
+	return Options{
+		AppHangThresholds: map[platform.Platform]time.Duration{
+			// Android reports an ANR when the main thread is blocked for 5 seconds.
+			platform.Android: 5 * time.Second,
+			platform.Cocoa:   2 * time.Second,
+		},
+		AppStartThresholds: map[platform.Platform]map[string]time.Duration{
+			platform.Android: {
+				AppStartColdOp: 5 * time.Second,
+				AppStartWarmOp: 2 * time.Second,
+			},
+			platform.Cocoa: {
+				AppStartColdOp: 2 * time.Second,
+				AppStartWarmOp: time.Second,
+			},
+		},
+	}
+}
+
+func Find(p profile.Profile, callTrees map[uint64][]*nodetree.Node, options Options) []*Occurrence {
 	var occurrences []*Occurrence
 	if jobs, exists := detectFrameJobs[p.Platform()]; exists {
 		for _, metadata := range jobs {
@@ -13,5 +49,8 @@ func Find(p profile.Profile, callTrees map[uint64][]*nodetree.Node) []*Occurrenc
 		}
 	}
 	findFrameDropCause(p, callTrees, &occurrences)
+	findAppHangCause(p, callTrees, options, &occurrences)
+	findSlowAppStart(p, callTrees, options, &occurrences)
+	findLockHolders(p, occurrences)
 	return occurrences
 }
@@ -74,42 +74,52 @@ func findFrameDropCause(
 	}
 	for _, mv := range frameDrops.Values {
 		stats := newFrozenFrameStats(mv.ElapsedSinceStartNs, mv.Value)
-		for _, root := range callTrees {
-			st := make([]*nodetree.Node, 0, profile.MaxStackDepth)
-			cause := findFrameDropCauseFrame(
-				root,
-				stats,
-				&st,
-				0,
-			)
-			if cause == nil {
-				continue
-			}
-			// We found a potential stacktrace responsible for this frozen frame
-			stackTrace := make([]frame.Frame, 0, len(cause.st))
-			var unknownFramesCount float64
-			for _, f := range cause.st {
-				if f.Frame.Function == "" {
-					unknownFramesCount++
-				}
-				stackTrace = append(stackTrace, f.ToFrame())
-			}
-			// If there are too many unknown frames in the stack,
-			// we do not create an occurrence.
-			if unknownFramesCount >= float64(len(stackTrace))*unknownFramesInTheStackThreshold {
-				continue
+		ni, exists := findFrameDropCauseInCallTrees(callTrees, stats)
+		if !exists {
+			continue
+		}
+		*occurrences = append(*occurrences, NewOccurrence(p, ni))
+	}
+}
+
+// findFrameDropCauseInCallTrees returns the most likely cause of a frame drop
+// found in the call trees.
+func findFrameDropCauseInCallTrees(
+	callTrees []*nodetree.Node,
+	stats frozenFrameStats,
+) (nodeInfo, bool) {
+	for _, root := range callTrees {
+		st := make([]*nodetree.Node, 0, profile.MaxStackDepth)
+		cause := findFrameDropCauseFrame(
+			root,
+			stats,
+			&st,
+			0,
+		)
+		if cause == nil {
+			continue
+		}
+		// We found a potential stacktrace responsible for this frozen frame
+		stackTrace := make([]frame.Frame, 0, len(cause.st))
+		var unknownFramesCount float64
+		for _, f := range cause.st {
+			if f.Frame.Function == "" {
+				unknownFramesCount++
 			}
-			*occurrences = append(
-				*occurrences,
-				NewOccurrence(p, nodeInfo{
-					Category:   FrameDrop,
-					Node:       *cause.n,
-					StackTrace: stackTrace,
-				}),
-			)
-			break
+			stackTrace = append(stackTrace, f.ToFrame())
+		}
+		// If there are too many unknown frames in the stack,
+		// we do not create an occurrence.
+		if unknownFramesCount >= float64(len(stackTrace))*unknownFramesInTheStackThreshold {
+			continue
 		}
+		return nodeInfo{
+			Category:   FrameDrop,
+			Node:       *cause.n,
+			StackTrace: stackTrace,
+		}, true
 	}
+	return nodeInfo{}, false
 }
 
 func findFrameDropCauseFrame(
@@ -0,0 +1,157 @@
+package occurrence
+
+import (
+	"math"
+	"time"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+const (
+	ChunkID    string = "chunk_id"
+	ProfilerID string = "profiler_id"
+)
+
+// Measurements carrying frame renders we look for a cause of.
+var chunkFrameDropMeasurements = []string{
+	"frozen_frame_renders",
+	"slow_frame_renders",
+}
+
+// FindInChunk returns occurrences detected on a continuous profiling chunk.
+func FindInChunk(c chunk.Chunk, callTrees map[string][]*nodetree.Node) []*Occurrence {
+	var occurrences []*Occurrence
+	findChunkFrameDropCause(c, callTrees, &occurrences)
+	return occurrences
+}
+
+func findChunkFrameDropCause(
+	c chunk.Chunk,
+	callTreesPerThreadID map[string][]*nodetree.Node,
+	occurrences *[]*Occurrence,
+) {
+	m, err := c.GetMeasurements()
+	if err != nil {
+		return
+	}
+	callTrees, exists := callTreesPerThreadID[c.MainThreadID()]
+	if !exists {
+		return
+	}
+	start := c.StartTimestamp()
+	end := c.EndTimestamp()
+	// Call tree nodes are using absolute timestamps in nanoseconds.
+	startNS := uint64(start * 1e9)
+	seen := make(map[nodeKey]struct{})
+	for _, name := range chunkFrameDropMeasurements {
+		frameRenders, exists := m[name]
+		if !exists {
+			continue
+		}
+		for _, mv := range frameRenders.Values {
+			if mv.Timestamp < start || mv.Timestamp > end {
+				continue
+			}
+			// V2 measurements have absolute timestamps in seconds, we convert
+			// them to an offset from the start of the chunk.
+			elapsedNS := uint64(math.Round((mv.Timestamp - start) * 1e9))
+			stats := newFrozenFrameStats(startNS+elapsedNS, mv.Value)
+			ni, exists := findFrameDropCauseInCallTrees(callTrees, stats)
+			if !exists {
+				continue
+			}
+			// A chunk might contain many frame drops caused by the same function,
+			// we only report it once.
+			nk := nodeKey{Package: ni.Node.Package, Function: ni.Node.Name}
+			if _, exists := seen[nk]; exists {
+				continue
+			}
+			seen[nk] = struct{}{}
+			o := newChunkOccurrence(c, ni)
+			o.EvidenceData["frame_render_type"] = name
+			o.EvidenceData["frame_render_duration_ns"] = uint64(mv.Value)
+			o.EvidenceData["frame_render_timestamp"] = mv.Timestamp
+			*occurrences = append(*occurrences, o)
+		}
+	}
+}
+
+// newChunkOccurrence returns an Occurrence struct populated with info
+// from a chunk. Chunks don't have a profile ID, the occurrence points to the
+// continuous profile of the profiler between the start and end timestamps,
+// in seconds, of the culprit.
+func newChunkOccurrence(c chunk.Chunk, ni nodeInfo) *Occurrence {
+	title, issueType := issueTitleAndType(ni.Category)
+	pf := normalizeNodeInfo(c.GetPlatform(), &ni)
+	fingerprint := generateFingerprint(c.GetProjectID(), title, issueType, ni)
+	return &Occurrence{
+		Culprit:       ni.Node.Name,
+		DetectionTime: time.Now().UTC(),
+		Event: Event{
+			Environment:    c.GetEnvironment(),
+			ID:             eventID(),
+			OrganizationID: c.GetOrganizationID(),
+			Platform:       pf,
+			ProjectID:      c.GetProjectID(),
+			Received:       timestampToTime(c.GetReceived()),
+			Release:        c.GetRelease(),
+			StackTrace:     StackTrace{Frames: ni.StackTrace},
+			Tags:           chunkTags(c),
+			Timestamp:      timestampToTime(c.StartTimestamp()),
+		},
+		EvidenceData: map[string]interface{}{
+			"end_timestamp":     float64(ni.Node.EndNS) / 1e9,
+			"frame_duration_ns": ni.Node.DurationNS,
+			"frame_module":      ni.Node.Frame.Module,
+			"frame_name":        ni.Node.Name,
+			"frame_package":     ni.Node.Frame.Package,
+			"start_timestamp":   float64(ni.Node.StartNS) / 1e9,
+			"template_name":     "continuous_profile",
+			ChunkID:             c.GetID(),
+			ProfilerID:          c.GetProfilerID(),
+		},
+		EvidenceDisplay: []Evidence{
+			{
+				Important: true,
+				Name:      EvidenceNameFunction,
+				Value:     ni.Node.Name,
+			},
+			{
+				Name:  EvidenceNamePackage,
+				Value: ni.Node.Package,
+			},
+		},
+		Fingerprint: []string{fingerprint},
+		ID:          eventID(),
+		IssueTitle:  title,
+		Level:       "info",
+		PayloadType: OccurrencePayload,
+		ProjectID:   c.GetProjectID(),
+		Subtitle:    ni.Node.Name,
+		Type:        issueType,
+		category:    ni.Category,
+		durationNS:  ni.Node.DurationNS,
+		sampleCount: ni.Node.SampleCount,
+		startNS:     ni.Node.StartNS,
+		endNS:       ni.Node.EndNS,
+	}
+}
+
+// chunkTags returns the tags of a chunk occurrence. Chunks aren't tied to a
+// transaction, we tag them with what we know of the chunk instead.
+func chunkTags(c chunk.Chunk) map[string]string {
+	tags := make(map[string]string)
+	if env := c.GetEnvironment(); env != "" {
+		tags["environment"] = env
+	}
+	if release := c.GetRelease(); release != "" {
+		tags["release"] = release
+	}
+	return tags
+}
+
+func timestampToTime(ts float64) time.Time {
+	sec, dec := math.Modf(ts)
+	return time.Unix(int64(sec), int64(dec*1e9)).UTC()
+}
@@ -0,0 +1,92 @@
+package occurrence
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/measurements"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/sample"
+)
+
+func TestFindChunkFrameDropCause(t *testing.T) {
+	start := 1_700_000_000.0
+	m, err := json.Marshal(map[string]measurements.MeasurementV2{
+		"slow_frame_renders": {
+			Unit: "nanosecond",
+			Values: []measurements.MeasurementValueV2{
+				{
+					Timestamp: start + 0.4,
+					Value:     float64(200 * time.Millisecond),
+				},
+				// Same cause, it should only be reported once.
+				{
+					Timestamp: start + 0.4,
+					Value:     float64(200 * time.Millisecond),
+				},
+				// Outside of the chunk.
+				{
+					Timestamp: start + 10,
+					Value:     float64(200 * time.Millisecond),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := chunk.New(&chunk.SampleChunk{
+		ID:             "chunk",
+		ProfilerID:     "profiler",
+		Platform:       platform.Cocoa,
+		ProjectID:      1,
+		OrganizationID: 1,
+		Environment:    "production",
+		Measurements:   m,
+		Profile: chunk.SampleData{
+			Samples: []chunk.Sample{
+				{ThreadID: "1", Timestamp: start},
+				{ThreadID: "1", Timestamp: start + 0.5},
+			},
+			ThreadMetadata: map[string]sample.ThreadMetadata{
+				"1": {Name: "main"},
+			},
+		},
+	})
+	startNS := time.Duration(start * 1e9)
+	callTrees := map[string][]*nodetree.Node{
+		"1": {
+			newLockTestNode("root", "app", true, startNS, startNS+500*time.Millisecond,
+				newLockTestNode("render", "app", true, startNS+200*time.Millisecond, startNS+400*time.Millisecond),
+			),
+		},
+	}
+
+	var occurrences []*Occurrence
+	findChunkFrameDropCause(c, callTrees, &occurrences)
+	if len(occurrences) != 1 {
+		t.Fatalf("expected 1 occurrence, got %d", len(occurrences))
+	}
+	o := occurrences[0]
+	if o.Type != FrameDropType {
+		t.Fatalf("expected type %d, got %d", FrameDropType, o.Type)
+	}
+	if o.Subtitle != "render" {
+		t.Fatalf("expected render, got %s", o.Subtitle)
+	}
+	if o.EvidenceData[ChunkID] != "chunk" || o.EvidenceData[ProfilerID] != "profiler" {
+		t.Fatalf("expected chunk and profiler IDs in evidence, got %v", o.EvidenceData)
+	}
+	if o.EvidenceData["template_name"] != "continuous_profile" {
+		t.Fatalf("expected a continuous profile template, got %v", o.EvidenceData["template_name"])
+	}
+	if o.EvidenceData["start_timestamp"] != start+0.2 || o.EvidenceData["end_timestamp"] != start+0.4 {
+		t.Fatalf("expected the time range of the culprit, got %v", o.EvidenceData)
+	}
+	if o.Event.Tags["environment"] != "production" {
+		t.Fatalf("expected an environment tag, got %v", o.Event.Tags)
+	}
+}
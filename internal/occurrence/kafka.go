@@ -2,10 +2,16 @@ package occurrence
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/schema"
 )
 
+// GenerateKafkaMessageBatch builds a message per occurrence, keyed by project
+// so occurrences of a project keep their order, with the headers of the
+// occurrence schema.
 func GenerateKafkaMessageBatch(occurrences []*Occurrence) ([]kafka.Message, error) {
 	messages := make([]kafka.Message, 0, len(occurrences))
 	for _, o := range occurrences {
@@ -14,7 +20,9 @@ func GenerateKafkaMessageBatch(occurrences []*Occurrence) ([]kafka.Message, erro
 			return nil, err
 		}
 		messages = append(messages, kafka.Message{
-			Value: b,
+			Key:     []byte(strconv.FormatUint(o.ProjectID, 10)),
+			Value:   b,
+			Headers: schema.Headers(schema.Occurrence),
 		})
 	}
 	return messages, nil
@@ -0,0 +1,37 @@
+package occurrence
+
+import (
+	"testing"
+
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/schema"
+)
+
+func TestGenerateKafkaMessageBatchMatchesSchema(t *testing.T) {
+	occurrences := []*Occurrence{
+		FromRegressedFunction(
+			platform.Python,
+			RegressedFunction{
+				OrganizationID:  1,
+				ProjectID:       1,
+				AggregateRange1: 100_000_000,
+				AggregateRange2: 200_000_000,
+			},
+			frame.Frame{Module: "foo", Function: "bar"},
+		),
+	}
+	messages, err := GenerateKafkaMessageBatch(occurrences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		name, version, ok := schema.FromHeaders(m.Headers)
+		if !ok || name != schema.Occurrence {
+			t.Fatalf("expected the occurrence schema header, got: %v", m.Headers)
+		}
+		if err := schema.Validate(name, version, m.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package occurrence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/profile"
+)
+
+type (
+	lockHolder struct {
+		threadID  uint64
+		overlapNS uint64
+		stack     []*nodetree.Node
+	}
+)
+
+// lockWaitFunctionsByPackage lists the functions a thread blocks in while
+// waiting on a lock, on top of the thread wait functions we detect.
+var lockWaitFunctionsByPackage = map[string]map[string]struct{}{
+	"java.lang": {
+		"java.lang.Object.wait": {},
+	},
+	"libdispatch": {
+		"dispatch_semaphore_wait": {},
+	},
+	"libsystem_pthread": {
+		"pthread_mutex_lock": {},
+	},
+}
+
+const (
+	// A thread needs to be running the same stack for at least this share of
+	// the wait for us to consider it as the lock holder.
+	minLockHolderOverlapPercent float64 = 0.5
+)
+
+// findLockHolders looks at what other threads were executing while the active
+// thread was waiting and attaches the most likely lock holder stack to each
+// thread wait occurrence.
+func findLockHolders(p profile.Profile, occurrences []*Occurrence) {
+	var waits []*Occurrence
+	for _, o := range occurrences {
+		if o.category == ThreadWait && o.endNS > o.startNS {
+			waits = append(waits, o)
+		}
+	}
+	if len(waits) == 0 {
+		return
+	}
+	callTrees, err := p.CallTreesForAllThreads()
+	if err != nil {
+		return
+	}
+	activeThreadID := p.Transaction().ActiveThreadID
+	for _, o := range waits {
+		h := findLockHolder(p.Platform(), callTrees, activeThreadID, o.startNS, o.endNS)
+		if h == nil {
+			continue
+		}
+		addLockHolderEvidence(p, o, h)
+	}
+}
+
+func findLockHolder(
+	pf platform.Platform,
+	callTreesPerThreadID map[uint64][]*nodetree.Node,
+	activeThreadID uint64,
+	startNS, endNS uint64,
+) *lockHolder {
+	minOverlapNS := uint64(float64(endNS-startNS) * minLockHolderOverlapPercent)
+	var best *lockHolder
+	for threadID, callTrees := range callTreesPerThreadID {
+		if threadID == activeThreadID {
+			continue
+		}
+		for _, root := range callTrees {
+			st := make([]*nodetree.Node, 0, profile.MaxStackDepth)
+			h := findDeepestOverlappingStack(root, startNS, endNS, minOverlapNS, &st)
+			if h == nil {
+				continue
+			}
+			// A thread waiting itself is not holding the lock we're looking for and
+			// a stack without any application frame is most likely an idle thread.
+			if isWaitFrame(pf, h.stack[len(h.stack)-1]) || !hasApplicationFrame(h.stack) {
+				continue
+			}
+			h.threadID = threadID
+			if best == nil ||
+				h.overlapNS > best.overlapNS ||
+				h.overlapNS == best.overlapNS && len(h.stack) > len(best.stack) ||
+				h.overlapNS == best.overlapNS && len(h.stack) == len(best.stack) && h.threadID < best.threadID {
+				best = h
+			}
+		}
+	}
+	return best
+}
+
+// findDeepestOverlappingStack returns the deepest stack overlapping the wait
+// window enough to be considered as holding the lock.
+func findDeepestOverlappingStack(
+	n *nodetree.Node,
+	startNS, endNS, minOverlapNS uint64,
+	st *[]*nodetree.Node,
+) *lockHolder {
+	overlapNS := overlapDurationNS(n, startNS, endNS)
+	// Children can't overlap more than their parent, no need to go further.
+	if overlapNS == 0 || overlapNS < minOverlapNS {
+		return nil
+	}
+	*st = append(*st, n)
+	defer func() {
+		*st = (*st)[:len(*st)-1]
+	}()
+	// Siblings don't overlap in time so only one of them can cover enough
+	// of the window.
+	for _, c := range n.Children {
+		if h := findDeepestOverlappingStack(c, startNS, endNS, minOverlapNS, st); h != nil {
+			return h
+		}
+	}
+	h := &lockHolder{
+		overlapNS: overlapNS,
+		stack:     make([]*nodetree.Node, len(*st)),
+	}
+	copy(h.stack, *st)
+	return h
+}
+
+func overlapDurationNS(n *nodetree.Node, startNS, endNS uint64) uint64 {
+	start := max(n.StartNS, startNS)
+	end := min(n.EndNS, endNS)
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
+
+func hasApplicationFrame(st []*nodetree.Node) bool {
+	for _, n := range st {
+		if n.IsApplication {
+			return true
+		}
+	}
+	return false
+}
+
+// isWaitFrame checks if the node waits on a lock or matches one of the
+// thread wait functions we detect for this platform.
+func isWaitFrame(pf platform.Platform, n *nodetree.Node) bool {
+	name := n.Name
+	if pf == platform.Android {
+		name, _, _ = strings.Cut(name, "(")
+	}
+	if _, exists := lockWaitFunctionsByPackage[n.Package][name]; exists {
+		return true
+	}
+	for _, options := range detectFrameJobs[pf] {
+		var functionsByPackage map[string]map[string]Category
+		switch o := options.(type) {
+		case DetectExactFrameOptions:
+			functionsByPackage = o.FunctionsByPackage
+		case DetectAndroidFrameOptions:
+			functionsByPackage = o.FunctionsByPackage
+		}
+		if category, exists := functionsByPackage[n.Package][name]; exists && category == ThreadWait {
+			return true
+		}
+	}
+	return false
+}
+
+func addLockHolderEvidence(p profile.Profile, o *Occurrence, h *lockHolder) {
+	pf := p.Platform()
+	if pf == platform.Android {
+		pf = platform.Java
+	}
+	stackTrace := make([]string, 0, len(h.stack))
+	for _, n := range h.stack {
+		stackTrace = append(stackTrace, n.Frame.FullyQualifiedName(pf))
+	}
+	culprit := h.stack[len(h.stack)-1]
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		if h.stack[i].IsApplication {
+			culprit = h.stack[i]
+			break
+		}
+	}
+	threadName := p.ThreadName(h.threadID)
+	thread := strconv.FormatUint(h.threadID, 10)
+	if threadName != "" {
+		thread = fmt.Sprintf("%s (%d)", threadName, h.threadID)
+	}
+	if o.EvidenceData == nil {
+		o.EvidenceData = make(map[string]interface{})
+	}
+	o.EvidenceData["lock_holder_thread_id"] = h.threadID
+	o.EvidenceData["lock_holder_thread_name"] = threadName
+	o.EvidenceData["lock_holder_function"] = culprit.Frame.FullyQualifiedName(pf)
+	o.EvidenceData["lock_holder_overlap_ns"] = h.overlapNS
+	o.EvidenceData["lock_holder_stack"] = stackTrace
+	o.EvidenceDisplay = append(o.EvidenceDisplay, Evidence{
+		Name: EvidenceNameLockHolder,
+		Value: fmt.Sprintf(
+			"%s on thread %s",
+			culprit.Frame.FullyQualifiedName(pf),
+			thread,
+		),
+	})
+}
@@ -0,0 +1,216 @@
+package occurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/profile"
+	"github.com/getsentry/vroom/internal/sample"
+	"github.com/getsentry/vroom/internal/testutil"
+	"github.com/getsentry/vroom/internal/transaction"
+)
+
+func newLockTestNode(name, pkg string, inApp bool, start, end time.Duration, children ...*nodetree.Node) *nodetree.Node {
+	return &nodetree.Node{
+		Children:      children,
+		DurationNS:    uint64(end - start),
+		EndNS:         uint64(end),
+		IsApplication: inApp,
+		Name:          name,
+		Package:       pkg,
+		StartNS:       uint64(start),
+		Frame: frame.Frame{
+			Function: name,
+			InApp:    &inApp,
+			Package:  pkg,
+		},
+	}
+}
+
+func TestFindLockHolder(t *testing.T) {
+	tests := []struct {
+		name         string
+		platform     platform.Platform
+		callTrees    map[uint64][]*nodetree.Node
+		startNS      uint64
+		endNS        uint64
+		wantThreadID uint64
+		wantStack    []string
+	}{
+		{
+			name:     "Find the deepest application stack running during the wait",
+			platform: platform.Cocoa,
+			callTrees: map[uint64][]*nodetree.Node{
+				1: {
+					newLockTestNode("main", "app", true, 0, 500*time.Millisecond,
+						newLockTestNode("pthread_mutex_lock", "libsystem_pthread", false, 100*time.Millisecond, 400*time.Millisecond),
+					),
+				},
+				2: {
+					newLockTestNode("worker", "app", true, 0, 500*time.Millisecond,
+						newLockTestNode("holdLock", "app", true, 50*time.Millisecond, 450*time.Millisecond,
+							newLockTestNode("write", "libsystem_kernel", false, 90*time.Millisecond, 420*time.Millisecond),
+						),
+					),
+				},
+				3: {
+					newLockTestNode("idle", "libsystem_kernel", false, 0, 500*time.Millisecond),
+				},
+			},
+			startNS:      uint64(100 * time.Millisecond),
+			endNS:        uint64(400 * time.Millisecond),
+			wantThreadID: 2,
+			wantStack:    []string{"worker", "holdLock", "write"},
+		},
+		{
+			name:     "Ignore threads waiting themselves",
+			platform: platform.Cocoa,
+			callTrees: map[uint64][]*nodetree.Node{
+				1: {
+					newLockTestNode("main", "app", true, 0, 500*time.Millisecond),
+				},
+				2: {
+					newLockTestNode("worker", "app", true, 0, 500*time.Millisecond,
+						newLockTestNode("pthread_mutex_lock", "libsystem_pthread", false, 0, 500*time.Millisecond),
+					),
+				},
+				3: {
+					newLockTestNode("other", "app", true, 200*time.Millisecond, 400*time.Millisecond),
+				},
+			},
+			startNS:      uint64(100 * time.Millisecond),
+			endNS:        uint64(400 * time.Millisecond),
+			wantThreadID: 3,
+			wantStack:    []string{"other"},
+		},
+		{
+			name:     "No thread overlapping enough",
+			platform: platform.Cocoa,
+			callTrees: map[uint64][]*nodetree.Node{
+				2: {
+					newLockTestNode("worker", "app", true, 350*time.Millisecond, 500*time.Millisecond),
+				},
+			},
+			startNS: uint64(100 * time.Millisecond),
+			endNS:   uint64(400 * time.Millisecond),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := findLockHolder(tt.platform, tt.callTrees, 1, tt.startNS, tt.endNS)
+			if tt.wantStack == nil {
+				if h != nil {
+					t.Fatalf("expected no lock holder, got thread %d", h.threadID)
+				}
+				return
+			}
+			if h == nil {
+				t.Fatal("expected a lock holder, got none")
+			}
+			stack := make([]string, 0, len(h.stack))
+			for _, n := range h.stack {
+				stack = append(stack, n.Name)
+			}
+			if h.threadID != tt.wantThreadID {
+				t.Fatalf("expected thread %d, got %d", tt.wantThreadID, h.threadID)
+			}
+			if diff := testutil.Diff(stack, tt.wantStack); diff != "" {
+				t.Fatalf("Result mismatch: got - want +\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFindLockHolderInCocoaProfile runs Find on a profile made of frames from
+// a real iOS profile, the main thread waiting on a lock while a worker thread
+// runs application code.
+func TestFindLockHolderInCocoaProfile(t *testing.T) {
+	const (
+		appPackage     = "/private/var/containers/Bundle/Application/E36E85F0-6DE6-485A-B6CA-4D44057F2115/TrendingMovies.app/TrendingMovies"
+		mainThreadID   = 259
+		workerThreadID = 8707
+	)
+	frames := []frame.Frame{
+		{Function: "MovieCollectionViewCell.posterImage.setter", InstructionAddr: "0x104f4c2c4", Package: appPackage},
+		{Function: "_dispatch_client_callout", InstructionAddr: "0x1d4a48a28", Package: "/usr/lib/system/libdispatch.dylib"},
+		{Function: "__CFRunLoopRun", InstructionAddr: "0x1d4d536fc", Package: "/System/Library/Frameworks/CoreFoundation.framework/CoreFoundation"},
+		{Function: "UIApplicationMain", InstructionAddr: "0x1d7457d88", Package: "/System/Library/PrivateFrameworks/UIKitCore.framework/UIKitCore"},
+		{Function: "main", InstructionAddr: "0x104f78874", Package: appPackage},
+		{Function: "vBoxConvolve", InstructionAddr: "0x1f73f8914", Package: "/System/Library/Frameworks/Accelerate.framework/Frameworks/vImage.framework/vImage"},
+		{Function: "+[UIImageEffects imageByApplyingBlurToImage:withRadius:tintColor:saturationDeltaFactor:maskImage:]", InstructionAddr: "0x104f79684", Package: appPackage},
+		{Function: "_dispatch_call_block_and_release", InstructionAddr: "0x1d4a46e64", Package: "/usr/lib/system/libdispatch.dylib"},
+		{Function: "_pthread_wqthread", InstructionAddr: "0x2466a40b4", Package: "/usr/lib/system/libsystem_pthread.dylib"},
+		{Function: "start_wqthread", InstructionAddr: "0x2466a3e54", Package: "/usr/lib/system/libsystem_pthread.dylib"},
+	}
+	tests := []struct {
+		name      string
+		waitFrame frame.Frame
+	}{
+		{
+			name:      "Mutex",
+			waitFrame: frame.Frame{Function: "pthread_mutex_lock", InstructionAddr: "0x2466a62e0", Package: "/usr/lib/system/libsystem_pthread.dylib"},
+		},
+		{
+			name:      "Semaphore",
+			waitFrame: frame.Frame{Function: "dispatch_semaphore_wait", InstructionAddr: "0x1d4a4a7f0", Package: "/usr/lib/system/libdispatch.dylib"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := sample.Trace{
+				Frames: append(append([]frame.Frame{}, frames...), tt.waitFrame),
+				Stacks: []sample.Stack{
+					{len(frames), 0, 1, 2, 3, 4},
+					{5, 6, 7, 8, 9},
+				},
+				ThreadMetadata: map[string]sample.ThreadMetadata{
+					"259": {Name: "main"},
+				},
+			}
+			for i := 0; i <= 10; i++ {
+				elapsed := uint64(time.Duration(i) * 10 * time.Millisecond)
+				trace.Samples = append(trace.Samples,
+					sample.Sample{ElapsedSinceStartNS: elapsed, StackID: 0, ThreadID: mainThreadID},
+					sample.Sample{ElapsedSinceStartNS: elapsed, StackID: 1, ThreadID: workerThreadID},
+				)
+			}
+			sp := &sample.Profile{
+				RawProfile: sample.RawProfile{
+					EventID:  "4ae8c1c0-6b55-42f4-bbce-9ec354f2aafd",
+					Platform: platform.Cocoa,
+					Trace:    trace,
+					Transaction: transaction.Transaction{
+						ActiveThreadID: mainThreadID,
+						Name:           "example_ios_movies_sources.MoviesViewController",
+					},
+				},
+			}
+			sp.Normalize()
+			p := profile.New(sp)
+			callTrees, err := p.CallTrees()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			occurrences := Find(p, callTrees, DefaultOptions())
+			if len(occurrences) != 1 {
+				t.Fatalf("expected 1 occurrence, got %d", len(occurrences))
+			}
+			o := occurrences[0]
+			if o.category != ThreadWait {
+				t.Fatalf("expected a %s occurrence, got %s", ThreadWait, o.category)
+			}
+			if id := o.EvidenceData["lock_holder_thread_id"]; id != uint64(workerThreadID) {
+				t.Fatalf("expected thread %d to hold the lock, got %v", workerThreadID, id)
+			}
+			if f := o.EvidenceData["lock_holder_function"]; f != frames[6].Function {
+				t.Fatalf("expected %s to hold the lock, got %v", frames[6].Function, f)
+			}
+		})
+	}
+}
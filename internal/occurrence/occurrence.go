@@ -67,6 +67,10 @@ type (
 		category    Category
 		durationNS  uint64
 		sampleCount int
+
+		// Only use for cross-thread analysis.
+		startNS uint64
+		endNS   uint64
 	}
 
 	StackTrace struct {
@@ -92,13 +96,18 @@ const (
 	FrameDropType          Type = 2009
 	FrameRegressionExpType Type = 2010
 	FrameRegressionType    Type = 2011
+	AppHangType            Type = 2013
+	AppStartType           Type = 2014
 
-	EvidenceNameDuration       EvidenceName = "Duration"
-	EvidenceNameFunction       EvidenceName = "Suspect function"
-	EvidenceNamePackage        EvidenceName = "Package"
-	EvidenceFullyQualifiedName EvidenceName = "Fully qualified name"
-	EvidenceBreakpoint         EvidenceName = "Breakpoint"
-	EvidenceRegression         EvidenceName = "Regression"
+	EvidenceNameDuration         EvidenceName = "Duration"
+	EvidenceNameFunction         EvidenceName = "Suspect function"
+	EvidenceNamePackage          EvidenceName = "Package"
+	EvidenceFullyQualifiedName   EvidenceName = "Fully qualified name"
+	EvidenceBreakpoint           EvidenceName = "Breakpoint"
+	EvidenceRegression           EvidenceName = "Regression"
+	EvidenceNameLockHolder       EvidenceName = "Likely lock holder"
+	EvidenceNameAppStartDuration EvidenceName = "App start duration"
+	EvidenceNameSelfTime         EvidenceName = "Self time"
 
 	ContextTrace Context = "trace"
 
@@ -108,6 +117,8 @@ const (
 )
 
 var issueTitles = map[Category]CategoryMetadata{
+	AppHang:          {IssueTitle: "App Hang", Type: AppHangType},
+	AppStart:         {IssueTitle: "Slow App Start", Type: AppStartType},
 	Base64Decode:     {IssueTitle: "Base64 Decode on Main Thread"},
 	Base64Encode:     {IssueTitle: "Base64 Encode on Main Thread"},
 	Compression:      {IssueTitle: "Compression on Main Thread"},
@@ -140,33 +151,9 @@ var issueTitles = map[Category]CategoryMetadata{
 // NewOccurrence returns an Occurrence struct populated with info.
 func NewOccurrence(p profile.Profile, ni nodeInfo) *Occurrence {
 	t := p.Transaction()
-	var title IssueTitle
-	var issueType Type
-	cm, exists := issueTitles[ni.Category]
-	if exists {
-		issueType = cm.Type
-		title = cm.IssueTitle
-	} else {
-		issueType = NoneType
-		title = IssueTitle(fmt.Sprintf("%v issue detected", ni.Category))
-	}
-	pf := p.Platform()
-	switch pf {
-	case platform.Android:
-		pf = platform.Java
-		normalizeAndroidStackTrace(ni.StackTrace)
-		ni.Node.Name = android.StripPackageNameFromFullMethodName(
-			ni.Node.Name,
-			ni.Node.Package,
-		)
-	}
-	h := md5.New()
-	_, _ = io.WriteString(h, strconv.FormatUint(p.ProjectID(), 10))
-	_, _ = io.WriteString(h, string(title))
-	_, _ = io.WriteString(h, strconv.Itoa(int(issueType)))
-	_, _ = io.WriteString(h, ni.Node.Frame.ModuleOrPackage())
-	_, _ = io.WriteString(h, ni.Node.Name)
-	fingerprint := fmt.Sprintf("%x", h.Sum(nil))
+	title, issueType := issueTitleAndType(ni.Category)
+	pf := normalizeNodeInfo(p.Platform(), &ni)
+	fingerprint := generateFingerprint(p.ProjectID(), title, issueType, ni)
 	tags := p.TransactionTags()
 	if tags == nil {
 		tags = make(map[string]string)
@@ -200,9 +187,15 @@ func NewOccurrence(p profile.Profile, ni nodeInfo) *Occurrence {
 		category:        ni.Category,
 		durationNS:      ni.Node.DurationNS,
 		sampleCount:     ni.Node.SampleCount,
+		startNS:         ni.Node.StartNS,
+		endNS:           ni.Node.EndNS,
 	}
 }
 
+const (
+	FunctionRegression Category = "function_regression"
+)
+
 func FromRegressedFunction(
 	pf platform.Platform,
 	regressed RegressedFunction,
@@ -288,8 +281,48 @@ func FromRegressedFunction(
 			beforeP95,
 			afterP95,
 		),
-		Type: occurrenceType,
+		Type:     occurrenceType,
+		category: FunctionRegression,
+	}
+}
+
+// Category returns the category of the issue the occurrence was detected
+// for.
+func (o *Occurrence) Category() Category {
+	return o.category
+}
+
+func issueTitleAndType(c Category) (IssueTitle, Type) {
+	cm, exists := issueTitles[c]
+	if !exists {
+		return IssueTitle(fmt.Sprintf("%v issue detected", c)), NoneType
 	}
+	return cm.IssueTitle, cm.Type
+}
+
+// normalizeNodeInfo strips the package name from Android frames and returns
+// the platform to report.
+func normalizeNodeInfo(pf platform.Platform, ni *nodeInfo) platform.Platform {
+	switch pf {
+	case platform.Android:
+		pf = platform.Java
+		normalizeAndroidStackTrace(ni.StackTrace)
+		ni.Node.Name = android.StripPackageNameFromFullMethodName(
+			ni.Node.Name,
+			ni.Node.Package,
+		)
+	}
+	return pf
+}
+
+func generateFingerprint(projectID uint64, title IssueTitle, issueType Type, ni nodeInfo) string {
+	h := md5.New()
+	_, _ = io.WriteString(h, strconv.FormatUint(projectID, 10))
+	_, _ = io.WriteString(h, string(title))
+	_, _ = io.WriteString(h, strconv.Itoa(int(issueType)))
+	_, _ = io.WriteString(h, ni.Node.Frame.ModuleOrPackage())
+	_, _ = io.WriteString(h, ni.Node.Name)
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 func eventID() string {
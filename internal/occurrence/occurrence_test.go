@@ -83,6 +83,9 @@ func TestFromRegressedFunction(t *testing.T) {
 			if occ.Subtitle != tt.expectedSubtitle {
 				t.Fatalf("Occurrent subtitle mismatch: got %v want %v\n", occ.Subtitle, tt.expectedSubtitle)
 			}
+			if occ.Category() != FunctionRegression {
+				t.Fatalf("Occurrent category mismatch: got %v want %v\n", occ.Category(), FunctionRegression)
+			}
 		})
 	}
 }
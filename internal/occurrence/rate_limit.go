@@ -0,0 +1,131 @@
+package occurrence
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RateLimiter limits the number of occurrences emitted per fingerprint
+	// within a time window.
+	RateLimiter struct {
+		limit  int
+		window time.Duration
+
+		mu        sync.Mutex
+		windows   map[string]*fingerprintWindow
+		lastPrune time.Time
+		now       func() time.Time
+	}
+
+	fingerprintWindow struct {
+		start      time.Time
+		count      int
+		suppressed uint64
+	}
+)
+
+const (
+	EvidenceNameSuppressed EvidenceName = "Suppressed occurrences"
+)
+
+// NewRateLimiter returns a RateLimiter allowing limit occurrences per
+// fingerprint for each window.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*fingerprintWindow),
+		now:     time.Now,
+	}
+}
+
+// Filter returns the occurrences allowed to be emitted. Suppressed
+// occurrences are counted and the count is attached as evidence to the next
+// occurrence emitted with the same fingerprint, until Emitted is called with
+// it.
+func (r *RateLimiter) Filter(occurrences []*Occurrence) []*Occurrence {
+	if r == nil || r.limit <= 0 || r.window <= 0 {
+		return occurrences
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.prune(now)
+	var i int
+	for _, o := range occurrences {
+		key := strings.Join(o.Fingerprint, ",")
+		w, exists := r.windows[key]
+		if !exists {
+			w = &fingerprintWindow{start: now}
+			r.windows[key] = w
+		} else if now.Sub(w.start) >= r.window {
+			w.start = now
+			w.count = 0
+		}
+		if w.count >= r.limit {
+			w.suppressed++
+			continue
+		}
+		w.count++
+		if w.suppressed > 0 {
+			addSuppressedEvidence(o, w.suppressed)
+		}
+		occurrences[i] = o
+		i++
+	}
+	return occurrences[:i]
+}
+
+// Emitted resets the suppressed counts attached to occurrences once they
+// were written. Counts attached to occurrences we failed to write are
+// attached to the next ones instead.
+func (r *RateLimiter) Emitted(occurrences []*Occurrence) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, o := range occurrences {
+		reported, ok := o.EvidenceData["suppressed_occurrences"].(uint64)
+		if !ok {
+			continue
+		}
+		w, exists := r.windows[strings.Join(o.Fingerprint, ",")]
+		if !exists {
+			continue
+		}
+		// More occurrences might have been suppressed since the count was
+		// attached.
+		w.suppressed -= min(w.suppressed, reported)
+	}
+}
+
+// prune removes expired windows, at most once per window. Windows with
+// suppressed occurrences left to report are kept for another window, for the
+// next occurrence to carry the count, then dropped with it.
+func (r *RateLimiter) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < r.window {
+		return
+	}
+	r.lastPrune = now
+	for key, w := range r.windows {
+		age := now.Sub(w.start)
+		if age >= 2*r.window || w.suppressed == 0 && age >= r.window {
+			delete(r.windows, key)
+		}
+	}
+}
+
+func addSuppressedEvidence(o *Occurrence, suppressed uint64) {
+	if o.EvidenceData == nil {
+		o.EvidenceData = make(map[string]interface{})
+	}
+	o.EvidenceData["suppressed_occurrences"] = suppressed
+	o.EvidenceDisplay = append(o.EvidenceDisplay, Evidence{
+		Name:  EvidenceNameSuppressed,
+		Value: fmt.Sprintf("%d similar occurrences were not reported", suppressed),
+	})
+}
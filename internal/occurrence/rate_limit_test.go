@@ -0,0 +1,81 @@
+package occurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterFilter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(2, time.Minute)
+	r.now = func() time.Time { return now }
+
+	newOccurrences := func(fingerprints ...string) []*Occurrence {
+		occurrences := make([]*Occurrence, 0, len(fingerprints))
+		for _, f := range fingerprints {
+			occurrences = append(occurrences, &Occurrence{Fingerprint: []string{f}})
+		}
+		return occurrences
+	}
+
+	if got := r.Filter(newOccurrences("a", "a", "a", "b")); len(got) != 3 {
+		t.Fatalf("expected 3 occurrences, got %d", len(got))
+	}
+	if got := r.Filter(newOccurrences("a", "b")); len(got) != 1 {
+		t.Fatalf("expected 1 occurrence, got %d", len(got))
+	}
+
+	now = now.Add(time.Minute)
+	got := r.Filter(newOccurrences("a"))
+	if len(got) != 1 {
+		t.Fatalf("expected 1 occurrence, got %d", len(got))
+	}
+	if suppressed := got[0].EvidenceData["suppressed_occurrences"]; suppressed != uint64(2) {
+		t.Fatalf("expected 2 suppressed occurrences, got %v", suppressed)
+	}
+	if len(r.windows) != 1 {
+		t.Fatalf("expected expired windows to be pruned, got %d", len(r.windows))
+	}
+}
+
+func TestRateLimiterKeepsSuppressedCountUntilEmitted(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(1, time.Minute)
+	r.now = func() time.Time { return now }
+	newOccurrence := func() []*Occurrence {
+		return []*Occurrence{{Fingerprint: []string{"a"}}}
+	}
+
+	r.Emitted(r.Filter(newOccurrence()))
+	r.Filter(newOccurrence())
+
+	// Writing the occurrence carrying the count failed, the next one carries
+	// it again.
+	now = now.Add(time.Minute)
+	r.Filter(newOccurrence())
+	now = now.Add(time.Minute)
+	got := r.Filter(newOccurrence())
+	if suppressed := got[0].EvidenceData["suppressed_occurrences"]; suppressed != uint64(1) {
+		t.Fatalf("expected 1 suppressed occurrence, got %v", suppressed)
+	}
+
+	r.Emitted(got)
+	now = now.Add(time.Minute)
+	got = r.Filter(newOccurrence())
+	if suppressed, exists := got[0].EvidenceData["suppressed_occurrences"]; exists {
+		t.Fatalf("expected no suppressed occurrences, got %v", suppressed)
+	}
+}
+
+func TestRateLimiterPrunesWindowsWithSuppressedCounts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(1, time.Minute)
+	r.now = func() time.Time { return now }
+
+	r.Filter([]*Occurrence{{Fingerprint: []string{"a"}}, {Fingerprint: []string{"a"}}})
+	now = now.Add(2 * time.Minute)
+	r.Filter(nil)
+	if len(r.windows) != 0 {
+		t.Fatalf("expected the expired window to be pruned, got %d windows", len(r.windows))
+	}
+}
@@ -0,0 +1,390 @@
+package occurrence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+
+	"github.com/getsentry/vroom/internal/android"
+	"github.com/getsentry/vroom/internal/chunk"
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/profile"
+	"github.com/getsentry/vroom/internal/storageutil"
+)
+
+type (
+	calleeBreakdown struct {
+		fingerprint uint32
+		function    string
+		pkg         string
+		durationNS  uint64
+	}
+
+	// functionBreakdown holds how the time spent in a function is split
+	// between its own code and the functions it calls.
+	functionBreakdown struct {
+		platform   platform.Platform
+		calls      uint64
+		durationNS uint64
+		selfTimeNS uint64
+		callees    map[uint32]*calleeBreakdown
+	}
+
+	calleeChange struct {
+		fingerprint uint32
+		function    string
+		pkg         string
+		beforeNS    uint64
+		afterNS     uint64
+	}
+)
+
+const (
+	EvidenceNameSlowerCallee EvidenceName = "Slower callee"
+
+	// Maximum number of examples we read on each side of the breakpoint.
+	maxRegressionExamples = 5
+	// Maximum number of callees we report as evidence.
+	maxCalleeChanges = 5
+	// Maximum number of callees we display.
+	maxDisplayedCalleeChanges = 3
+	// Maximum number of chunks of a profiler we list looking for examples
+	// from before the breakpoint.
+	maxListedChunks = 1000
+)
+
+// addRegressionBreakdown reads examples from before and after the breakpoint
+// and adds, as evidence, which callees of the regressed function got slower.
+//
+// Examples from before the breakpoint not sent with the regressed function
+// are looked up among the chunks of the profiler of its example. Profiles
+// can't be looked up by time so those are emitted without a breakdown.
+func addRegressionBreakdown(
+	ctx context.Context,
+	profilesBucket *blob.Bucket,
+	pf platform.Platform,
+	regressedFunction RegressedFunction,
+	queue *storageutil.ReadQueue,
+	o *Occurrence,
+) {
+	examplesBefore := regressedFunction.ExamplesBefore
+	examplesAfter := regressedFunction.ExamplesAfter
+	if len(examplesAfter) == 0 {
+		if regressedFunction.ProfileID != "" {
+			examplesAfter = []examples.ExampleMetadata{{ProfileID: regressedFunction.ProfileID}}
+		} else {
+			examplesAfter = []examples.ExampleMetadata{regressedFunction.Example}
+		}
+	}
+	if len(examplesBefore) == 0 {
+		var err error
+		examplesBefore, err = findChunksBefore(ctx, profilesBucket, regressedFunction)
+		if err != nil {
+			slog.Warn(
+				"couldn't look up examples before the breakpoint",
+				slog.Uint64("fingerprint", uint64(regressedFunction.Fingerprint)),
+				slog.String("err", err.Error()),
+			)
+			return
+		}
+	}
+	if len(examplesBefore) == 0 {
+		slog.Info(
+			"no examples before the breakpoint, regression emitted without a breakdown",
+			slog.Uint64("project_id", regressedFunction.ProjectID),
+			slog.Uint64("fingerprint", uint64(regressedFunction.Fingerprint)),
+		)
+		return
+	}
+	before := computeFunctionBreakdown(
+		readExamplesCallTrees(ctx, profilesBucket, regressedFunction, examplesBefore, queue),
+		pf,
+		regressedFunction.Fingerprint,
+	)
+	after := computeFunctionBreakdown(
+		readExamplesCallTrees(ctx, profilesBucket, regressedFunction, examplesAfter, queue),
+		pf,
+		regressedFunction.Fingerprint,
+	)
+	if before.calls == 0 || after.calls == 0 {
+		return
+	}
+	addCalleeBreakdownEvidence(o, before, after)
+}
+
+// findChunksBefore returns the most recent chunks of the profiler of the
+// example of a regressed function written before the breakpoint. Chunks
+// aren't indexed by time, their modification time is when we stored them.
+func findChunksBefore(
+	ctx context.Context,
+	profilesBucket *blob.Bucket,
+	regressedFunction RegressedFunction,
+) ([]examples.ExampleMetadata, error) {
+	example := regressedFunction.Example
+	if regressedFunction.ProfileID != "" || example.ProfilerID == "" {
+		return nil, nil
+	}
+	breakpoint := time.Unix(int64(regressedFunction.Breakpoint), 0)
+	prefix := chunk.StoragePath(
+		regressedFunction.OrganizationID,
+		regressedFunction.ProjectID,
+		example.ProfilerID,
+		"",
+	)
+	type storedChunk struct {
+		id      string
+		modTime time.Time
+	}
+	var chunks []storedChunk
+	it := profilesBucket.List(&blob.ListOptions{Prefix: prefix})
+	for listed := 0; listed < maxListedChunks; listed++ {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimPrefix(obj.Key, prefix)
+		if obj.IsDir || id == example.ChunkID || !obj.ModTime.Before(breakpoint) {
+			continue
+		}
+		chunks = append(chunks, storedChunk{id: id, modTime: obj.ModTime})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].modTime.After(chunks[j].modTime)
+	})
+	exs := make([]examples.ExampleMetadata, 0, min(len(chunks), maxRegressionExamples))
+	for _, c := range chunks[:min(len(chunks), maxRegressionExamples)] {
+		exs = append(exs, examples.ExampleMetadata{
+			ProfilerID: example.ProfilerID,
+			ChunkID:    c.id,
+			ThreadID:   example.ThreadID,
+		})
+	}
+	return exs, nil
+}
+
+// readExamplesCallTrees returns the call trees of the examples we were able
+// to read. Examples we can't read are ignored.
+func readExamplesCallTrees(
+	ctx context.Context,
+	profilesBucket *blob.Bucket,
+	regressedFunction RegressedFunction,
+	exs []examples.ExampleMetadata,
+	queue *storageutil.ReadQueue,
+) [][]*nodetree.Node {
+	if len(exs) > maxRegressionExamples {
+		exs = exs[:maxRegressionExamples]
+	}
+	results := make(chan storageutil.ReadJobResult, len(exs))
+	defer close(results)
+
+	var enqueued int
+	for _, ex := range exs {
+		projectID := ex.ProjectID
+		if projectID == 0 {
+			projectID = regressedFunction.ProjectID
+		}
+		var job storageutil.ReadJob
+		if ex.ProfileID != "" {
+			job = profile.CallTreesReadJob{
+				Ctx:            ctx,
+				OrganizationID: regressedFunction.OrganizationID,
+				ProjectID:      projectID,
+				ProfileID:      ex.ProfileID,
+				Storage:        profilesBucket,
+				Result:         results,
+			}
+		} else {
+			job = chunk.CallTreesReadJob{
+				Ctx:            ctx,
+				OrganizationID: regressedFunction.OrganizationID,
+				ProjectID:      projectID,
+				ProfilerID:     ex.ProfilerID,
+				ChunkID:        ex.ChunkID,
+				ThreadID:       ex.ThreadID,
+				Storage:        profilesBucket,
+				Result:         results,
+			}
+		}
+		if queue.Enqueue(ctx, job) != nil {
+			break
+		}
+		enqueued++
+	}
+
+	callTrees := make([][]*nodetree.Node, 0, enqueued)
+	for i := 0; i < enqueued; i++ {
+		res := <-results
+		if res.Error() != nil {
+			continue
+		}
+		switch result := res.(type) {
+		case profile.CallTreesReadJobResult:
+			for _, trees := range result.CallTrees {
+				callTrees = append(callTrees, trees)
+			}
+		case chunk.CallTreesReadJobResult:
+			for _, trees := range result.CallTrees {
+				callTrees = append(callTrees, trees)
+			}
+		}
+	}
+	return callTrees
+}
+
+func computeFunctionBreakdown(
+	callTrees [][]*nodetree.Node,
+	pf platform.Platform,
+	fingerprint uint32,
+) functionBreakdown {
+	b := functionBreakdown{
+		platform: pf,
+		callees:  make(map[uint32]*calleeBreakdown),
+	}
+	for _, trees := range callTrees {
+		for _, root := range trees {
+			b.add(root, fingerprint)
+		}
+	}
+	return b
+}
+
+func (b *functionBreakdown) add(n *nodetree.Node, fingerprint uint32) {
+	if n.Frame.Fingerprint() != fingerprint {
+		for _, c := range n.Children {
+			b.add(c, fingerprint)
+		}
+		return
+	}
+	// We don't look for the function in its own subtree to avoid counting
+	// recursive calls more than once.
+	b.calls++
+	b.durationNS += n.DurationNS
+	var childrenDurationNS uint64
+	for _, c := range n.Children {
+		childrenDurationNS += c.DurationNS
+		if c.Frame.Function == "" {
+			continue
+		}
+		f := c.Frame.Fingerprint()
+		callee, exists := b.callees[f]
+		if !exists {
+			callee = &calleeBreakdown{
+				fingerprint: f,
+				function:    b.calleeName(c),
+				pkg:         c.Frame.ModuleOrPackage(),
+			}
+			b.callees[f] = callee
+		}
+		callee.durationNS += c.DurationNS
+	}
+	if n.DurationNS > childrenDurationNS {
+		b.selfTimeNS += n.DurationNS - childrenDurationNS
+	}
+}
+
+// calleeName returns the name of the callee as we display it, without the
+// package name on Android, like other occurrences.
+func (b *functionBreakdown) calleeName(n *nodetree.Node) string {
+	switch b.platform {
+	case platform.Android:
+		return android.StripPackageNameFromFullMethodName(n.Frame.Function, n.Frame.Package)
+	}
+	return n.Frame.Function
+}
+
+// perCall returns the average duration per call of the function.
+func (b functionBreakdown) perCall(durationNS uint64) uint64 {
+	if b.calls == 0 {
+		return 0
+	}
+	return durationNS / b.calls
+}
+
+// calleeChanges returns the callees sorted by how much slower they got per
+// call of the regressed function.
+func calleeChanges(before, after functionBreakdown) []calleeChange {
+	changes := make(map[uint32]*calleeChange)
+	for f, c := range before.callees {
+		changes[f] = &calleeChange{
+			fingerprint: f,
+			function:    c.function,
+			pkg:         c.pkg,
+			beforeNS:    before.perCall(c.durationNS),
+		}
+	}
+	for f, c := range after.callees {
+		change, exists := changes[f]
+		if !exists {
+			change = &calleeChange{
+				fingerprint: f,
+				function:    c.function,
+				pkg:         c.pkg,
+			}
+			changes[f] = change
+		}
+		change.afterNS = after.perCall(c.durationNS)
+	}
+	list := make([]calleeChange, 0, len(changes))
+	for _, c := range changes {
+		list = append(list, *c)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		di := int64(list[i].afterNS) - int64(list[i].beforeNS)
+		dj := int64(list[j].afterNS) - int64(list[j].beforeNS)
+		if di == dj {
+			return list[i].fingerprint < list[j].fingerprint
+		}
+		return di > dj
+	})
+	return list
+}
+
+func addCalleeBreakdownEvidence(o *Occurrence, before, after functionBreakdown) {
+	changes := calleeChanges(before, after)
+	if len(changes) > maxCalleeChanges {
+		changes = changes[:maxCalleeChanges]
+	}
+	data := make([]map[string]interface{}, 0, len(changes))
+	var displayed int
+	for _, c := range changes {
+		data = append(data, map[string]interface{}{
+			"after_ns":    c.afterNS,
+			"before_ns":   c.beforeNS,
+			"delta_ns":    int64(c.afterNS) - int64(c.beforeNS),
+			"fingerprint": c.fingerprint,
+			"function":    c.function,
+			"package":     c.pkg,
+		})
+		if c.afterNS <= c.beforeNS || displayed >= maxDisplayedCalleeChanges {
+			continue
+		}
+		displayed++
+		o.EvidenceDisplay = append(o.EvidenceDisplay, Evidence{
+			Name: EvidenceNameSlowerCallee,
+			Value: fmt.Sprintf(
+				"%s went from %s to %s per call.",
+				c.function,
+				time.Duration(c.beforeNS).Round(10*time.Microsecond),
+				time.Duration(c.afterNS).Round(10*time.Microsecond),
+			),
+		})
+	}
+	o.EvidenceData["callee_breakdown"] = data
+	o.EvidenceData["calls_before"] = before.calls
+	o.EvidenceData["calls_after"] = after.calls
+	o.EvidenceData["duration_before_ns"] = before.perCall(before.durationNS)
+	o.EvidenceData["duration_after_ns"] = after.perCall(after.durationNS)
+	o.EvidenceData["self_time_before_ns"] = before.perCall(before.selfTimeNS)
+	o.EvidenceData["self_time_after_ns"] = after.perCall(after.selfTimeNS)
+}
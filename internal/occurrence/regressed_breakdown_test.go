@@ -0,0 +1,75 @@
+package occurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestRegressionCalleeChanges(t *testing.T) {
+	target := newLockTestNode("regressed", "app", true, 0, 0)
+	fingerprint := target.Frame.Fingerprint()
+
+	before := computeFunctionBreakdown([][]*nodetree.Node{
+		{
+			newLockTestNode("main", "app", true, 0, 100*time.Millisecond,
+				newLockTestNode("regressed", "app", true, 0, 50*time.Millisecond,
+					newLockTestNode("parse", "app", true, 0, 20*time.Millisecond),
+					newLockTestNode("write", "libc", false, 20*time.Millisecond, 40*time.Millisecond),
+				),
+			),
+		},
+	}, platform.Cocoa, fingerprint)
+	after := computeFunctionBreakdown([][]*nodetree.Node{
+		{
+			newLockTestNode("main", "app", true, 0, 200*time.Millisecond,
+				newLockTestNode("regressed", "app", true, 0, 70*time.Millisecond,
+					newLockTestNode("parse", "app", true, 0, 20*time.Millisecond),
+					newLockTestNode("write", "libc", false, 20*time.Millisecond, 60*time.Millisecond),
+				),
+				newLockTestNode("regressed", "app", true, 100*time.Millisecond, 170*time.Millisecond,
+					newLockTestNode("parse", "app", true, 100*time.Millisecond, 120*time.Millisecond),
+					newLockTestNode("write", "libc", false, 120*time.Millisecond, 160*time.Millisecond),
+				),
+			),
+		},
+	}, platform.Cocoa, fingerprint)
+
+	if before.calls != 1 || after.calls != 2 {
+		t.Fatalf("expected 1 call before and 2 after, got %d and %d", before.calls, after.calls)
+	}
+
+	changes := calleeChanges(before, after)
+	got := make([]string, 0, len(changes))
+	for _, c := range changes {
+		got = append(got, c.function)
+	}
+	if diff := testutil.Diff(got, []string{"write", "parse"}); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+	if changes[0].beforeNS != uint64(20*time.Millisecond) || changes[0].afterNS != uint64(40*time.Millisecond) {
+		t.Fatalf("unexpected durations per call: %+v", changes[0])
+	}
+}
+
+func TestRegressionCalleeNamesOnAndroid(t *testing.T) {
+	target := newLockTestNode("com.example.Feed.load", "com.example", true, 0, 0)
+	b := computeFunctionBreakdown([][]*nodetree.Node{
+		{
+			newLockTestNode("com.example.Feed.load", "com.example", true, 0, 50*time.Millisecond,
+				newLockTestNode("com.example.db.Cache.read", "com.example.db", true, 0, 20*time.Millisecond),
+			),
+		},
+	}, platform.Android, target.Frame.Fingerprint())
+
+	var got []string
+	for _, c := range b.callees {
+		got = append(got, c.function)
+	}
+	if diff := testutil.Diff(got, []string{"Cache.read"}); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+}
@@ -29,20 +29,28 @@ type RegressedFunction struct {
 	TrendPercentage          float64                  `json:"trend_percentage"`
 	UnweightedPValue         float64                  `json:"unweighted_p_value"`
 	UnweightedTValue         float64                  `json:"unweighted_t_value"`
+
+	// Examples from before and after the breakpoint used to explain the
+	// regression. Examples before default to chunks of the profiler of the
+	// example stored before the breakpoint, examples after to the example
+	// of the regressed function.
+	ExamplesBefore []examples.ExampleMetadata `json:"examples_before,omitempty"`
+	ExamplesAfter  []examples.ExampleMetadata `json:"examples_after,omitempty"`
 }
 
 func ProcessRegressedFunction(
 	ctx context.Context,
 	profilesBucket *blob.Bucket,
 	regressedFunction RegressedFunction,
-	jobs chan storageutil.ReadJob,
+	queue *storageutil.ReadQueue,
 ) (*Occurrence, error) {
 	results := make(chan storageutil.ReadJobResult, 1)
 	defer close(results)
 
+	var job storageutil.ReadJob
 	if regressedFunction.ProfileID != "" {
 		// For back compat, we should be use the example moving forwards
-		jobs <- profile.ReadJob{
+		job = profile.ReadJob{
 			Ctx:            ctx,
 			OrganizationID: regressedFunction.OrganizationID,
 			ProjectID:      regressedFunction.ProjectID,
@@ -51,7 +59,7 @@ func ProcessRegressedFunction(
 			Result:         results,
 		}
 	} else if regressedFunction.Example.ProfileID != "" {
-		jobs <- profile.ReadJob{
+		job = profile.ReadJob{
 			Ctx:            ctx,
 			OrganizationID: regressedFunction.OrganizationID,
 			ProjectID:      regressedFunction.ProjectID,
@@ -60,7 +68,7 @@ func ProcessRegressedFunction(
 			Result:         results,
 		}
 	} else {
-		jobs <- chunk.ReadJob{
+		job = chunk.ReadJob{
 			Ctx:            ctx,
 			OrganizationID: regressedFunction.OrganizationID,
 			ProjectID:      regressedFunction.ProjectID,
@@ -71,12 +79,20 @@ func ProcessRegressedFunction(
 		}
 	}
 
+	// Don't wait for the read pool past the deadline.
+	err := queue.Enqueue(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
 	res := <-results
 	platform, frame, err := getPlatformAndFrame(ctx, res, regressedFunction.Fingerprint)
 	if err != nil {
 		return nil, err
 	}
-	return FromRegressedFunction(platform, regressedFunction, frame), nil
+	o := FromRegressedFunction(platform, regressedFunction, frame)
+	addRegressionBreakdown(ctx, profilesBucket, platform, regressedFunction, queue, o)
+	return o, nil
 }
 
 func getPlatformAndFrame(
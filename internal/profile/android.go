@@ -311,17 +311,24 @@ func (p Android) CallTrees() map[uint64][]*nodetree.Node {
 }
 
 func (p Android) CallTreesWithMaxDepth(maxDepth int) map[uint64][]*nodetree.Node {
+	activeThreadID := p.ActiveThreadID()
+	return p.callTrees(maxDepth, func(threadID uint64) bool {
+		return threadID == activeThreadID
+	})
+}
+
+// CallTreesForAllThreads generates call trees for every thread of the profile,
+// not only the main one.
+func (p Android) CallTreesForAllThreads() map[uint64][]*nodetree.Node {
+	return p.callTrees(MaxStackDepth, func(_ uint64) bool {
+		return true
+	})
+}
+
+func (p Android) callTrees(maxDepth int, includeThread func(threadID uint64) bool) map[uint64][]*nodetree.Node {
 	// in case wall-clock.secs is not monotonic, "fix" it
 	p.FixSamplesTime()
 
-	var activeThreadID uint64
-	for _, thread := range p.Threads {
-		if thread.Name == mainThread {
-			activeThreadID = thread.ID
-			break
-		}
-	}
-
 	buildTimestamp := p.TimestampGetter()
 	treesByThreadID := make(map[uint64][]*nodetree.Node)
 	stacks := make(map[uint64][]*nodetree.Node)
@@ -339,13 +346,17 @@ func (p Android) CallTreesWithMaxDepth(maxDepth int) map[uint64][]*nodetree.Node
 	}
 
 	var maxTimestampNS uint64
-	enterPerMethod := make(map[uint64]int)
-	exitPerMethod := make(map[uint64]int)
+	enterPerMethod := make(map[uint64]map[uint64]int)
+	exitPerMethod := make(map[uint64]map[uint64]int)
 
 	for _, e := range p.Events {
-		if e.ThreadID != activeThreadID {
+		if !includeThread(e.ThreadID) {
 			continue
 		}
+		if _, exists := enterPerMethod[e.ThreadID]; !exists {
+			enterPerMethod[e.ThreadID] = make(map[uint64]int)
+			exitPerMethod[e.ThreadID] = make(map[uint64]int)
+		}
 
 		ts := buildTimestamp(e.Time) + p.SdkStartTime
 		if ts > maxTimestampNS {
@@ -366,7 +377,7 @@ func (p Android) CallTreesWithMaxDepth(maxDepth int) map[uint64][]*nodetree.Node
 			if stackDepth[e.ThreadID] > maxDepth {
 				continue
 			}
-			enterPerMethod[e.MethodID]++
+			enterPerMethod[e.ThreadID][e.MethodID]++
 			n := nodetree.NodeFromFrame(m.Frame(), ts, 0, 0)
 			if len(stacks[e.ThreadID]) == 0 {
 				treesByThreadID[e.ThreadID] = append(treesByThreadID[e.ThreadID], n)
@@ -389,12 +400,12 @@ func (p Android) CallTreesWithMaxDepth(maxDepth int) map[uint64][]*nodetree.Node
 			for ; i >= 0; i-- {
 				n := stacks[e.ThreadID][i]
 				if n.Frame.MethodID != e.MethodID &&
-					enterPerMethod[e.MethodID] <= exitPerMethod[e.MethodID] {
+					enterPerMethod[e.ThreadID][e.MethodID] <= exitPerMethod[e.ThreadID][e.MethodID] {
 					eventSkipped = true
 					break
 				}
 				closeFrame(e.ThreadID, ts, i)
-				exitPerMethod[e.MethodID]++
+				exitPerMethod[e.ThreadID][e.MethodID]++
 				if n.Frame.MethodID == e.MethodID {
 					break
 				}
@@ -669,6 +680,15 @@ func (p Android) ActiveThreadID() uint64 {
 	return 0
 }
 
+func (p Android) ThreadName(threadID uint64) string {
+	for _, t := range p.Threads {
+		if t.ID == threadID {
+			return t.Name
+		}
+	}
+	return ""
+}
+
 func (p Android) GetFrameWithFingerprint(target uint32) (frame.Frame, error) {
 	for _, m := range p.Methods {
 		f := m.Frame()
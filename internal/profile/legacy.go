@@ -145,6 +145,16 @@ func (p *LegacyProfile) UnmarshalJSON(b []byte) error {
 }
 
 func (p LegacyProfile) CallTrees() (map[uint64][]*nodetree.Node, error) {
+	return p.callTrees(false)
+}
+
+// CallTreesForAllThreads generates call trees for every thread of the profile,
+// not only the active one.
+func (p LegacyProfile) CallTreesForAllThreads() (map[uint64][]*nodetree.Node, error) {
+	return p.callTrees(true)
+}
+
+func (p LegacyProfile) callTrees(allThreads bool) (map[uint64][]*nodetree.Node, error) {
 	// Profiles longer than 5s contain a lot of call trees and it produces a lot of noise for the aggregation.
 	// The majority of them might also be timing out and we want to ignore them for the aggregation.
 	if time.Duration(p.DurationNS) > maxProfileDurationForCallTrees {
@@ -182,8 +192,14 @@ func (p LegacyProfile) CallTrees() (map[uint64][]*nodetree.Node, error) {
 		if err != nil {
 			return nil, err
 		}
+		if allThreads {
+			return jsProf.CallTreesForAllThreads()
+		}
 		return jsProf.CallTrees()
 	}
+	if allThreads {
+		return p.Trace.CallTreesForAllThreads(), nil
+	}
 	return p.Trace.CallTrees(), nil
 }
 
@@ -295,6 +311,11 @@ func (p LegacyProfile) GetReceived() time.Time {
 	return p.Received.Time()
 }
 
+// CollapseInlineFrames does nothing since legacy profiles don't have inline
+// frames.
+func (p *LegacyProfile) CollapseInlineFrames() {
+}
+
 func (p *LegacyProfile) Normalize() {
 	switch t := p.Trace.(type) {
 	case *Android:
@@ -363,6 +384,13 @@ func (p LegacyProfile) GetOptions() options.Options {
 	return p.Options
 }
 
+func (p LegacyProfile) ThreadName(threadID uint64) string {
+	if p.Trace == nil {
+		return ""
+	}
+	return p.Trace.ThreadName(threadID)
+}
+
 func (p LegacyProfile) GetFrameWithFingerprint(target uint32) (frame.Frame, error) {
 	return p.Trace.GetFrameWithFingerprint(target)
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/getsentry/vroom/internal/debugmeta"
 	"github.com/getsentry/vroom/internal/frame"
+	"github.com/getsentry/vroom/internal/jsonutil"
 	"github.com/getsentry/vroom/internal/measurements"
 	"github.com/getsentry/vroom/internal/metadata"
 	"github.com/getsentry/vroom/internal/nodetree"
@@ -35,24 +36,23 @@ type (
 		GetTransactionTags() map[string]string
 
 		CallTrees() (map[uint64][]*nodetree.Node, error)
+		CallTreesForAllThreads() (map[uint64][]*nodetree.Node, error)
 		IsSampleFormat() bool
 		Metadata() metadata.Metadata
 		Normalize()
+		CollapseInlineFrames()
 		Speedscope() (speedscope.Output, error)
 		StoragePath() string
 		IsSampled() bool
 		SetProfileID(ID string)
 		GetOptions() options.Options
 		GetFrameWithFingerprint(uint32) (frame.Frame, error)
+		ThreadName(uint64) string
 	}
 
 	Profile struct {
 		profile profileInterface
 	}
-
-	version struct {
-		Version string `json:"version"`
-	}
 )
 
 func New(p profileInterface) Profile {
@@ -62,12 +62,17 @@ func New(p profileInterface) Profile {
 }
 
 func (p *Profile) UnmarshalJSON(b []byte) error {
-	var v version
-	err := json.Unmarshal(b, &v)
+	fields, err := jsonutil.Peek(b, "version")
 	if err != nil {
 		return err
 	}
-	switch v.Version {
+	return p.UnmarshalVersion(b, fields["version"])
+}
+
+// UnmarshalVersion unmarshals a profile in the format of a version, when
+// the version was already read from the payload.
+func (p *Profile) UnmarshalVersion(b []byte, version string) error {
+	switch version {
 	case "":
 		p.profile = new(LegacyProfile)
 	default:
@@ -92,6 +97,21 @@ func (p *Profile) CallTrees() (map[uint64][]*nodetree.Node, error) {
 	return callTrees, err
 }
 
+// CallTreesForAllThreads returns call trees for every thread of the profile.
+// It's more expensive than CallTrees and should only be used when we need to
+// look at what other threads were doing.
+func (p *Profile) CallTreesForAllThreads() (map[uint64][]*nodetree.Node, error) {
+	callTrees, err := p.profile.CallTreesForAllThreads()
+
+	for _, callTreesForThread := range callTrees {
+		for _, callTree := range callTreesForThread {
+			callTree.RecursiveComputeSelfTime()
+		}
+	}
+
+	return callTrees, err
+}
+
 func (p *Profile) DebugMeta() debugmeta.DebugMeta {
 	return p.profile.GetDebugMeta()
 }
@@ -132,6 +152,12 @@ func (p *Profile) Normalize() {
 	p.profile.Normalize()
 }
 
+// CollapseInlineFrames attributes the time spent in inline frames to the frame
+// they were inlined into, for call trees, speedscope output and functions.
+func (p *Profile) CollapseInlineFrames() {
+	p.profile.CollapseInlineFrames()
+}
+
 func (p *Profile) Transaction() transaction.Transaction {
 	return p.profile.GetTransaction()
 }
@@ -193,3 +219,12 @@ func (p *Profile) GetOptions() options.Options {
 func (p *Profile) GetFrameWithFingerprint(target uint32) (frame.Frame, error) {
 	return p.profile.GetFrameWithFingerprint(target)
 }
+
+func (p *Profile) ThreadName(threadID uint64) string {
+	return p.profile.ThreadName(threadID)
+}
+
+// MainThreadID returns the ID of the thread the transaction was active on.
+func (p *Profile) MainThreadID() uint64 {
+	return p.Transaction().ActiveThreadID
+}
@@ -15,7 +15,10 @@ type (
 		OrganizationID uint64
 		ProjectID      uint64
 		ProfileID      string
-		Result         chan<- storageutil.ReadJobResult
+		// CollapseInlineFrames attributes the time spent in inline frames to
+		// the frame they were inlined into.
+		CollapseInlineFrames bool
+		Result               chan<- storageutil.ReadJobResult
 	}
 
 	ReadJobResult struct {
@@ -33,6 +36,9 @@ func (job ReadJob) Read() {
 		StoragePath(job.OrganizationID, job.ProjectID, job.ProfileID),
 		&profile,
 	)
+	if err == nil && job.CollapseInlineFrames {
+		profile.CollapseInlineFrames()
+	}
 
 	job.Result <- ReadJobResult{Profile: &profile, Err: err}
 }
@@ -66,6 +72,10 @@ func (job CallTreesReadJob) Read() {
 		return
 	}
 
+	if job.CollapseInlineFrames {
+		profile.CollapseInlineFrames()
+	}
+
 	callTrees, err := profile.CallTrees()
 
 	job.Result <- CallTreesReadJobResult{
@@ -10,7 +10,9 @@ type (
 	Trace interface {
 		ActiveThreadID() uint64
 		CallTrees() map[uint64][]*nodetree.Node
+		CallTreesForAllThreads() map[uint64][]*nodetree.Node
 		Speedscope() (speedscope.Output, error)
 		GetFrameWithFingerprint(uint32) (frame.Frame, error)
+		ThreadName(uint64) string
 	}
 )
@@ -0,0 +1,235 @@
+package quantile
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+// RelativeAccuracy is the maximum relative error of the quantiles returned by
+// a sketch. All sketches share it so they can always be merged together.
+const RelativeAccuracy = 0.01
+
+var (
+	ErrEmptySketch          = errors.New("cannot compute quantile from an empty sketch")
+	ErrInvalidQuantile      = errors.New("q must be a value between 0 and 1.0")
+	ErrIncompatibleAccuracy = errors.New("sketch relative accuracy is not supported")
+
+	gamma      = (1 + RelativeAccuracy) / (1 - RelativeAccuracy)
+	multiplier = 1 / math.Log(gamma)
+)
+
+type (
+	// Sketch is a mergeable quantile sketch for durations based on DDSketch.
+	// Values are counted in logarithmic bins so the memory used only grows
+	// with the range of values recorded, not with their number.
+	Sketch struct {
+		bins      map[int32]uint64
+		zeroCount uint64
+		count     uint64
+		sum       uint64
+		min       uint64
+		max       uint64
+	}
+
+	sketchJSON struct {
+		RelativeAccuracy float64          `json:"relative_accuracy"`
+		Count            uint64           `json:"count"`
+		Sum              uint64           `json:"sum"`
+		Min              uint64           `json:"min"`
+		Max              uint64           `json:"max"`
+		ZeroCount        uint64           `json:"zero_count,omitempty"`
+		Bins             map[int32]uint64 `json:"bins"`
+	}
+)
+
+func New() *Sketch {
+	return &Sketch{
+		bins: make(map[int32]uint64),
+	}
+}
+
+// FromValues returns a sketch containing all the values.
+func FromValues(values []uint64) *Sketch {
+	s := New()
+	for _, v := range values {
+		s.Add(v)
+	}
+	return s
+}
+
+func (s *Sketch) Add(v uint64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	if v == 0 {
+		s.zeroCount++
+		return
+	}
+	if s.bins == nil {
+		s.bins = make(map[int32]uint64)
+	}
+	s.bins[index(v)]++
+}
+
+// Merge adds all the values recorded in o to s.
+func (s *Sketch) Merge(o *Sketch) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.zeroCount += o.zeroCount
+	if len(o.bins) > 0 && s.bins == nil {
+		s.bins = make(map[int32]uint64, len(o.bins))
+	}
+	for i, c := range o.bins {
+		s.bins[i] += c
+	}
+}
+
+func (s *Sketch) Clone() *Sketch {
+	if s == nil {
+		return nil
+	}
+	c := New()
+	c.Merge(s)
+	return c
+}
+
+// Count returns the number of values recorded.
+func (s *Sketch) Count() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.count
+}
+
+// Sum returns the sum of the values recorded.
+func (s *Sketch) Sum() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.sum
+}
+
+// Quantile returns the nearest-rank quantile q of the values recorded, within
+// RelativeAccuracy of the exact value.
+func (s *Sketch) Quantile(q float64) (uint64, error) {
+	if s == nil || s.count == 0 {
+		return 0, ErrEmptySketch
+	}
+	if q <= 0 || q > 1.0 {
+		return 0, ErrInvalidQuantile
+	}
+	rank := uint64(math.Ceil(float64(s.count) * q))
+	if rank <= s.zeroCount {
+		return 0, nil
+	}
+	indexes := make([]int32, 0, len(s.bins))
+	for i := range s.bins {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i] < indexes[j]
+	})
+	seen := s.zeroCount
+	for _, i := range indexes {
+		seen += s.bins[i]
+		if seen >= rank {
+			return s.clamp(value(i)), nil
+		}
+	}
+	return s.max, nil
+}
+
+// Equal reports whether both sketches have the same content.
+func (s *Sketch) Equal(o *Sketch) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	if s.count != o.count || s.sum != o.sum || s.min != o.min || s.max != o.max ||
+		s.zeroCount != o.zeroCount || len(s.bins) != len(o.bins) {
+		return false
+	}
+	for i, c := range s.bins {
+		if o.bins[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	bins := s.bins
+	if bins == nil {
+		bins = map[int32]uint64{}
+	}
+	return json.Marshal(sketchJSON{
+		RelativeAccuracy: RelativeAccuracy,
+		Count:            s.count,
+		Sum:              s.sum,
+		Min:              s.min,
+		Max:              s.max,
+		ZeroCount:        s.zeroCount,
+		Bins:             bins,
+	})
+}
+
+func (s *Sketch) UnmarshalJSON(b []byte) error {
+	var raw sketchJSON
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+	if raw.RelativeAccuracy != RelativeAccuracy {
+		return ErrIncompatibleAccuracy
+	}
+	*s = Sketch{
+		bins:      raw.Bins,
+		zeroCount: raw.ZeroCount,
+		count:     raw.Count,
+		sum:       raw.Sum,
+		min:       raw.Min,
+		max:       raw.Max,
+	}
+	if s.bins == nil {
+		s.bins = make(map[int32]uint64)
+	}
+	return nil
+}
+
+func (s *Sketch) clamp(v float64) uint64 {
+	r := uint64(math.Round(v))
+	if r < s.min {
+		return s.min
+	}
+	if r > s.max {
+		return s.max
+	}
+	return r
+}
+
+// index returns the bin a value belongs to. Every value in bin i is in the
+// interval (gamma^(i-1), gamma^i].
+func index(v uint64) int32 {
+	return int32(math.Ceil(math.Log(float64(v)) * multiplier))
+}
+
+// value returns the value representing bin i, with a relative error of at
+// most RelativeAccuracy for every value in the bin.
+func value(i int32) float64 {
+	return 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+}
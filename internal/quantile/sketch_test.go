@@ -0,0 +1,132 @@
+package quantile
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func exactQuantile(values []uint64, q float64) uint64 {
+	sorted := make([]uint64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[int(math.Ceil(float64(len(sorted))*q))-1]
+}
+
+func TestSketchQuantile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []uint64
+		q      float64
+		want   uint64
+	}{
+		{
+			name:   "single value",
+			values: []uint64{10},
+			q:      0.99,
+			want:   10,
+		},
+		{
+			name:   "p75 of small values",
+			values: []uint64{1, 2, 3, 4, 10, 8, 7, 11, 20},
+			q:      0.75,
+			want:   10,
+		},
+		{
+			name:   "p95 of small values",
+			values: []uint64{1, 2, 3, 4, 10, 8, 7, 11, 20},
+			q:      0.95,
+			want:   20,
+		},
+		{
+			name:   "zeros",
+			values: []uint64{0, 0, 0, 5},
+			q:      0.75,
+			want:   0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := FromValues(test.values).Quantile(test.q)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSketchRelativeAccuracy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := make([]uint64, 10_000)
+	for i := range values {
+		values[i] = uint64(r.ExpFloat64() * 20_000_000)
+	}
+	s := FromValues(values)
+	for _, q := range []float64{0.5, 0.75, 0.95, 0.99} {
+		got, err := s.Quantile(q)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := exactQuantile(values, q)
+		if math.Abs(float64(got)-float64(want)) > RelativeAccuracy*float64(want) {
+			t.Fatalf("p%v: got %d, want %d within %v", q*100, got, want, RelativeAccuracy)
+		}
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a := FromValues([]uint64{10, 5, 25})
+	b := FromValues([]uint64{45, 60, 0})
+	a.Merge(b)
+	a.Merge(nil)
+
+	want := FromValues([]uint64{10, 5, 25, 45, 60, 0})
+	if !a.Equal(want) {
+		t.Fatalf("merged sketch differs from the sketch of all values")
+	}
+	if a.Count() != 6 || a.Sum() != 145 {
+		t.Fatalf("got count %d and sum %d, want 6 and 145", a.Count(), a.Sum())
+	}
+}
+
+func TestSketchEmpty(t *testing.T) {
+	var s *Sketch
+	if _, err := s.Quantile(0.5); err != ErrEmptySketch {
+		t.Fatalf("got %v, want %v", err, ErrEmptySketch)
+	}
+	if _, err := New().Quantile(0.5); err != ErrEmptySketch {
+		t.Fatalf("got %v, want %v", err, ErrEmptySketch)
+	}
+	if _, err := FromValues([]uint64{1}).Quantile(0); err != ErrInvalidQuantile {
+		t.Fatalf("got %v, want %v", err, ErrInvalidQuantile)
+	}
+}
+
+func TestSketchJSON(t *testing.T) {
+	s := FromValues([]uint64{0, 10, 5, 25, 1_000_000})
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got Sketch
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Equal(s) {
+		t.Fatalf("sketch changed after a round trip: %s", b)
+	}
+
+	err = json.Unmarshal([]byte(`{"relative_accuracy":0.02,"bins":{}}`), &got)
+	if err != ErrIncompatibleAccuracy {
+		t.Fatalf("got %v, want %v", err, ErrIncompatibleAccuracy)
+	}
+}
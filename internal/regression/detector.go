@@ -0,0 +1,249 @@
+package regression
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+	"github.com/getsentry/vroom/internal/occurrence"
+	"github.com/getsentry/vroom/internal/quantile"
+)
+
+type (
+	Options struct {
+		// BucketSize is the duration of the time buckets we aggregate
+		// function durations into.
+		BucketSize time.Duration
+		// MaxBuckets is the number of buckets we keep for each function.
+		MaxBuckets int
+		// MinBucketsPerSide is the minimum number of buckets we need on each
+		// side of a breakpoint.
+		MinBucketsPerSide int
+		// MaxPValue is the p-value under which we consider the change as
+		// statistically significant.
+		MaxPValue float64
+		// MinRelativeChange is the minimum relative increase of the p95 for a
+		// change to be reported.
+		MinRelativeChange float64
+	}
+
+	// Detector keeps rolling p95 aggregates of function durations per project
+	// and looks for regressions in them.
+	Detector struct {
+		options Options
+
+		mu     sync.Mutex
+		series map[seriesKey]*series
+	}
+
+	seriesKey struct {
+		projectID   uint64
+		fingerprint uint32
+	}
+
+	series struct {
+		organizationID uint64
+		buckets        []bucket
+		lastBreakpoint uint64
+	}
+
+	bucket struct {
+		start     int64
+		durations *quantile.Sketch
+		p95       float64
+		closed    bool
+		example   examples.ExampleMetadata
+	}
+)
+
+// Number of examples we keep on each side of a breakpoint.
+const maxExamplesPerSide = 5
+
+func DefaultOptions() Options {
+	return Options{
+		BucketSize:        time.Hour,
+		MaxBuckets:        48,
+		MinBucketsPerSide: 6,
+		MaxPValue:         0.01,
+		MinRelativeChange: 0.1,
+	}
+}
+
+func NewDetector(options Options) *Detector {
+	return &Detector{
+		options: options,
+		series:  make(map[seriesKey]*series),
+	}
+}
+
+// Add records the function durations of a profile or a chunk.
+func (d *Detector) Add(
+	organizationID uint64,
+	projectID uint64,
+	timestamp time.Time,
+	example examples.ExampleMetadata,
+	functions []nodetree.CallTreeFunction,
+) {
+	if d == nil {
+		return
+	}
+	start := timestamp.Truncate(d.options.BucketSize).Unix()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range functions {
+		if len(f.DurationsNS) == 0 && f.Durations.Count() == 0 {
+			continue
+		}
+		key := seriesKey{projectID: projectID, fingerprint: f.Fingerprint}
+		s, exists := d.series[key]
+		if !exists {
+			s = &series{organizationID: organizationID}
+			d.series[key] = s
+		}
+		b := s.bucket(start)
+		if b == nil || b.closed {
+			// Data is too old or arrived after the bucket was aggregated.
+			continue
+		}
+		b.durations = f.MergeDurationsInto(b.durations)
+		b.example = example
+	}
+}
+
+// bucket returns the bucket starting at start, creating it if needed.
+func (s *series) bucket(start int64) *bucket {
+	i := sort.Search(len(s.buckets), func(i int) bool {
+		return s.buckets[i].start >= start
+	})
+	if i < len(s.buckets) && s.buckets[i].start == start {
+		return &s.buckets[i]
+	}
+	if i == 0 && len(s.buckets) > 0 && s.buckets[0].closed {
+		return nil
+	}
+	s.buckets = append(s.buckets, bucket{})
+	copy(s.buckets[i+1:], s.buckets[i:])
+	s.buckets[i] = bucket{start: start}
+	return &s.buckets[i]
+}
+
+// Detect aggregates the buckets ending before now and returns the functions
+// with a newly detected regression.
+func (d *Detector) Detect(now time.Time) []occurrence.RegressedFunction {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bucketSize := int64(d.options.BucketSize / time.Second)
+	var regressed []occurrence.RegressedFunction
+	for key, s := range d.series {
+		for i := range s.buckets {
+			b := &s.buckets[i]
+			if b.closed || b.start+bucketSize > now.Unix() {
+				continue
+			}
+			b.close()
+		}
+		if len(s.buckets) > d.options.MaxBuckets {
+			s.buckets = s.buckets[len(s.buckets)-d.options.MaxBuckets:]
+		}
+		// Forget about functions we haven't seen in a while.
+		if len(s.buckets) == 0 ||
+			s.buckets[len(s.buckets)-1].start+int64(d.options.MaxBuckets)*bucketSize < now.Unix() {
+			delete(d.series, key)
+			continue
+		}
+		rf, exists := d.detect(key, s)
+		if !exists {
+			continue
+		}
+		regressed = append(regressed, rf)
+	}
+	return regressed
+}
+
+func (b *bucket) close() {
+	b.closed = true
+	p95, err := b.durations.Quantile(0.95)
+	if err == nil {
+		b.p95 = float64(p95)
+	}
+	b.durations = nil
+}
+
+// detect searches for the breakpoint maximizing the t statistic of a Welch's
+// t-test between the p95 before and after it.
+func (d *Detector) detect(key seriesKey, s *series) (occurrence.RegressedFunction, bool) {
+	closed := make([]*bucket, 0, len(s.buckets))
+	for i := range s.buckets {
+		if s.buckets[i].closed && s.buckets[i].p95 > 0 {
+			closed = append(closed, &s.buckets[i])
+		}
+	}
+	minBuckets := max(d.options.MinBucketsPerSide, 2)
+	if len(closed) < 2*minBuckets {
+		return occurrence.RegressedFunction{}, false
+	}
+	values := make([]float64, 0, len(closed))
+	for _, b := range closed {
+		values = append(values, b.p95)
+	}
+	bestIndex := -1
+	bestT := math.Inf(-1)
+	var bestP float64
+	for i := minBuckets; i <= len(values)-minBuckets; i++ {
+		t, p := welchTTest(values[:i], values[i:])
+		if t > bestT {
+			bestIndex, bestT, bestP = i, t, p
+		}
+	}
+	if bestIndex == -1 || bestP > d.options.MaxPValue {
+		return occurrence.RegressedFunction{}, false
+	}
+	before, _ := meanAndVariance(values[:bestIndex])
+	after, _ := meanAndVariance(values[bestIndex:])
+	if before <= 0 || (after-before)/before < d.options.MinRelativeChange {
+		return occurrence.RegressedFunction{}, false
+	}
+	breakpoint := uint64(closed[bestIndex].start)
+	if breakpoint <= s.lastBreakpoint {
+		return occurrence.RegressedFunction{}, false
+	}
+	s.lastBreakpoint = breakpoint
+	examplesBefore := make([]examples.ExampleMetadata, 0, maxExamplesPerSide)
+	for i := bestIndex - 1; i >= 0 && len(examplesBefore) < maxExamplesPerSide; i-- {
+		if hasExample(closed[i]) {
+			examplesBefore = append(examplesBefore, closed[i].example)
+		}
+	}
+	examplesAfter := make([]examples.ExampleMetadata, 0, maxExamplesPerSide)
+	for i := bestIndex; i < len(closed) && len(examplesAfter) < maxExamplesPerSide; i++ {
+		if hasExample(closed[i]) {
+			examplesAfter = append(examplesAfter, closed[i].example)
+		}
+	}
+	return occurrence.RegressedFunction{
+		OrganizationID:           s.organizationID,
+		ProjectID:                key.projectID,
+		Example:                  closed[len(closed)-1].example,
+		Fingerprint:              key.fingerprint,
+		AbsolutePercentageChange: after / before,
+		AggregateRange1:          before,
+		AggregateRange2:          after,
+		Breakpoint:               breakpoint,
+		TrendDifference:          after - before,
+		TrendPercentage:          after / before,
+		UnweightedPValue:         bestP,
+		UnweightedTValue:         bestT,
+		ExamplesBefore:           examplesBefore,
+		ExamplesAfter:            examplesAfter,
+	}, true
+}
+
+func hasExample(b *bucket) bool {
+	return b.example.ProfileID != "" || b.example.ChunkID != ""
+}
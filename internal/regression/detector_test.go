@@ -0,0 +1,64 @@
+package regression
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/getsentry/vroom/internal/examples"
+	"github.com/getsentry/vroom/internal/nodetree"
+)
+
+func TestStudentTCDF(t *testing.T) {
+	tests := []struct {
+		t    float64
+		df   float64
+		want float64
+	}{
+		{t: 0, df: 10, want: 0.5},
+		{t: 2.228, df: 10, want: 0.975},
+		{t: -2.228, df: 10, want: 0.025},
+		{t: 1.96, df: 1e6, want: 0.975},
+	}
+	for _, tt := range tests {
+		if got := studentTCDF(tt.t, tt.df); math.Abs(got-tt.want) > 1e-3 {
+			t.Fatalf("studentTCDF(%v, %v) = %v, want %v", tt.t, tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	options := DefaultOptions()
+	options.MinBucketsPerSide = 3
+	d := NewDetector(options)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	durations := []uint64{100, 102, 98, 101, 99, 100, 200, 205, 198, 202, 201, 199}
+	for i, duration := range durations {
+		ts := start.Add(time.Duration(i) * time.Hour)
+		d.Add(1, 2, ts, examples.ExampleMetadata{ProfileID: ts.String()}, []nodetree.CallTreeFunction{
+			{Fingerprint: 42, DurationsNS: []uint64{duration, duration}},
+			{Fingerprint: 43, DurationsNS: []uint64{100, 100}},
+		})
+	}
+
+	now := start.Add(time.Duration(len(durations)) * time.Hour)
+	regressed := d.Detect(now)
+	if len(regressed) != 1 {
+		t.Fatalf("expected 1 regression, got %d", len(regressed))
+	}
+	rf := regressed[0]
+	if rf.Fingerprint != 42 || rf.OrganizationID != 1 || rf.ProjectID != 2 {
+		t.Fatalf("unexpected regressed function: %+v", rf)
+	}
+	if want := uint64(start.Add(6 * time.Hour).Unix()); rf.Breakpoint != want {
+		t.Fatalf("expected breakpoint %d, got %d", want, rf.Breakpoint)
+	}
+	if rf.AggregateRange2 <= rf.AggregateRange1 {
+		t.Fatalf("expected p95 to increase, got %v -> %v", rf.AggregateRange1, rf.AggregateRange2)
+	}
+
+	// The same regression should not be reported twice.
+	if regressed := d.Detect(now); len(regressed) != 0 {
+		t.Fatalf("expected no regression, got %d", len(regressed))
+	}
+}
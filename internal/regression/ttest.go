@@ -0,0 +1,134 @@
+package regression
+
+import (
+	"math"
+)
+
+// welchTTest runs a one-sided Welch's t-test checking if the mean of after is
+// greater than the mean of before. It returns the t statistic and the p-value.
+func welchTTest(before, after []float64) (float64, float64) {
+	meanBefore, varianceBefore := meanAndVariance(before)
+	meanAfter, varianceAfter := meanAndVariance(after)
+	nBefore := float64(len(before))
+	nAfter := float64(len(after))
+
+	stdErrBefore := varianceBefore / nBefore
+	stdErrAfter := varianceAfter / nAfter
+	stdErr := stdErrBefore + stdErrAfter
+	if stdErr == 0 {
+		switch {
+		case meanAfter > meanBefore:
+			return math.Inf(1), 0
+		case meanAfter < meanBefore:
+			return math.Inf(-1), 1
+		default:
+			return 0, 1
+		}
+	}
+	t := (meanAfter - meanBefore) / math.Sqrt(stdErr)
+
+	// Welch–Satterthwaite equation
+	df := stdErr * stdErr /
+		(stdErrBefore*stdErrBefore/(nBefore-1) + stdErrAfter*stdErrAfter/(nAfter-1))
+
+	return t, 1 - studentTCDF(t, df)
+}
+
+func meanAndVariance(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, squares / float64(len(values)-1)
+}
+
+// studentTCDF returns the cumulative distribution function of Student's
+// t-distribution with df degrees of freedom.
+func studentTCDF(t, df float64) float64 {
+	if math.IsInf(t, 1) {
+		return 1
+	}
+	if math.IsInf(t, -1) {
+		return 0
+	}
+	tail := 0.5 * regularizedIncompleteBeta(df/(df+t*t), df/2, 0.5)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// regularizedIncompleteBeta computes I_x(a, b) using its continued fraction
+// representation.
+func regularizedIncompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges faster on this side.
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - front*betaContinuedFraction(1-x, b, a)/b
+}
+
+func betaContinuedFraction(x, a, b float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		// Even step
+		numerator := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		// Odd step
+		numerator = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
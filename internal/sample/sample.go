@@ -192,11 +192,25 @@ func (p Profile) GetDurationNS() uint64 {
 
 // CallTrees generates call trees from samples.
 func (p Profile) CallTrees() (map[uint64][]*nodetree.Node, error) {
+	activeThreadID := p.Transaction.ActiveThreadID
+	return p.callTrees(func(threadID uint64) bool {
+		return threadID == activeThreadID
+	})
+}
+
+// CallTreesForAllThreads generates call trees from samples for every thread
+// of the profile, not only the active one.
+func (p Profile) CallTreesForAllThreads() (map[uint64][]*nodetree.Node, error) {
+	return p.callTrees(func(_ uint64) bool {
+		return true
+	})
+}
+
+func (p Profile) callTrees(includeThread func(threadID uint64) bool) (map[uint64][]*nodetree.Node, error) {
 	sort.SliceStable(p.Trace.Samples, func(i, j int) bool {
 		return p.Trace.Samples[i].ElapsedSinceStartNS < p.Trace.Samples[j].ElapsedSinceStartNS
 	})
 
-	activeThreadID := p.Transaction.ActiveThreadID
 	treesByThreadID := make(map[uint64][]*nodetree.Node)
 	samplesByThreadID := make(map[uint64][]Sample)
 
@@ -210,7 +224,7 @@ func (p Profile) CallTrees() (map[uint64][]*nodetree.Node, error) {
 		// The last sample is not represented, only used for its timestamp.
 		for sampleIndex := 0; sampleIndex < len(samples)-1; sampleIndex++ {
 			s := samples[sampleIndex]
-			if s.ThreadID != activeThreadID {
+			if !includeThread(s.ThreadID) {
 				continue
 			}
 
@@ -281,6 +295,19 @@ func (t *Trace) ThreadName(threadID, queueAddress string, mainThread bool) strin
 	return ""
 }
 
+// ThreadName returns the name of a thread given its ID, falling back on the
+// label of the queue it was running on.
+func (p Profile) ThreadName(threadID uint64) string {
+	tid := strconv.FormatUint(threadID, 10)
+	isMainThread := threadID == p.Transaction.ActiveThreadID
+	for _, s := range p.Trace.Samples {
+		if s.ThreadID == threadID && s.QueueAddress != "" {
+			return p.Trace.ThreadName(tid, s.QueueAddress, isMainThread)
+		}
+	}
+	return p.Trace.ThreadName(tid, "", isMainThread)
+}
+
 func (p *Profile) IsSampleFormat() bool {
 	return true
 }
@@ -426,6 +453,25 @@ func (p *Profile) Metadata() metadata.Metadata {
 	}
 }
 
+// CollapseInlineFrames removes inline frames from the stacks so their time is
+// attributed to the frame they were inlined into.
+func CollapseInlineFrames[S ~[]int](stacks []S, frames []frame.Frame) {
+	for i, stack := range stacks {
+		collapsed := make(S, 0, len(stack))
+		for _, frameID := range stack {
+			if frameID < len(frames) && frames[frameID].IsInline() {
+				continue
+			}
+			collapsed = append(collapsed, frameID)
+		}
+		stacks[i] = collapsed
+	}
+}
+
+func (p *Profile) CollapseInlineFrames() {
+	CollapseInlineFrames(p.Trace.Stacks, p.Trace.Frames)
+}
+
 func (p *Profile) Normalize() {
 	for i := range p.Trace.Frames {
 		f := p.Trace.Frames[i]
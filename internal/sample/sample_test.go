@@ -1414,3 +1414,46 @@ func TestCallTreesFingerprintPerPlatform(t *testing.T) {
 		})
 	}
 }
+
+func TestCollapseInlineFrames(t *testing.T) {
+	p := Profile{
+		RawProfile: RawProfile{
+			Transaction: transaction.Transaction{ActiveThreadID: 1},
+			Trace: Trace{
+				Samples: []Sample{
+					{StackID: 0, ElapsedSinceStartNS: 10, ThreadID: 1},
+					{StackID: 1, ElapsedSinceStartNS: 40, ThreadID: 1},
+					{StackID: 1, ElapsedSinceStartNS: 50, ThreadID: 1},
+				},
+				Stacks: []Stack{
+					{1, 0},
+					{2, 1, 0},
+				},
+				Frames: []frame.Frame{
+					{Function: "function0", Status: "symbolicated", SymAddr: "0x1"},
+					{Function: "function1", Status: "symbolicated"},
+					{Function: "function2", Status: "symbolicated", SymAddr: "0x2"},
+				},
+			},
+		},
+	}
+
+	p.CollapseInlineFrames()
+
+	if diff := testutil.Diff(p.Trace.Stacks, []Stack{{0}, {2, 0}}); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+
+	callTrees, err := p.CallTrees()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	roots := callTrees[1]
+	if len(roots) != 1 || roots[0].Name != "function0" || roots[0].DurationNS != 40 {
+		t.Fatalf("expected a single function0 root lasting 40ns, got %+v", roots)
+	}
+	children := roots[0].Children
+	if len(children) != 1 || children[0].Name != "function2" || children[0].DurationNS != 10 {
+		t.Fatalf("expected function2 to be called by function0 for 10ns, got %+v", children)
+	}
+}
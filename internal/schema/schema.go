@@ -0,0 +1,146 @@
+// Package schema holds the JSON Schemas of the messages we produce to Kafka,
+// the contract we share with their consumers (Snuba, the issue platform).
+//
+// Schemas are versioned: a change breaking consumers adds a new version of a
+// schema instead of editing the current one, and every message carries the
+// name and version of its schema in its headers.
+package schema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/segmentio/kafka-go"
+)
+
+type Name string
+
+const (
+	Functions    Name = "functions"
+	Occurrence   Name = "occurrence"
+	Profile      Name = "profile"
+	ProfileChunk Name = "profile_chunk"
+
+	// NameHeader is the message header holding the name of its schema.
+	NameHeader = "schema"
+	// VersionHeader is the message header holding the version of its schema.
+	VersionHeader = "schema-version"
+
+	// FunctionsSketchesVersion is the version of the functions schema
+	// summarizing durations with sketches instead of listing them.
+	FunctionsSketchesVersion = 2
+)
+
+var (
+	ErrUnknownSchema = errors.New("schema: unknown schema")
+
+	//go:embed schemas/*.json
+	files embed.FS
+
+	// versions holds the versions of each schema we produce messages with,
+	// messages use the first one unless they're built for another.
+	versions = map[Name][]int{
+		Functions:    {1, FunctionsSketchesVersion},
+		Occurrence:   {1},
+		Profile:      {1},
+		ProfileChunk: {1},
+	}
+
+	schemas = compile()
+)
+
+func fileName(name Name, version int) string {
+	return fmt.Sprintf("%s.v%d.json", name, version)
+}
+
+func compile() map[Name]map[int]*jsonschema.Schema {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	compiled := make(map[Name]map[int]*jsonschema.Schema, len(versions))
+	for name, vs := range versions {
+		compiled[name] = make(map[int]*jsonschema.Schema, len(vs))
+		for _, version := range vs {
+			f := fileName(name, version)
+			b, err := files.ReadFile("schemas/" + f)
+			if err != nil {
+				panic(err)
+			}
+			err = c.AddResource(f, bytes.NewReader(b))
+			if err != nil {
+				panic(err)
+			}
+			compiled[name][version] = c.MustCompile(f)
+		}
+	}
+	return compiled
+}
+
+// Headers returns the headers identifying the schema of a message, in its
+// default version.
+func Headers(name Name) []kafka.Header {
+	vs, exists := versions[name]
+	if !exists {
+		return nil
+	}
+	return VersionHeaders(name, vs[0])
+}
+
+// VersionHeaders returns the headers identifying a version of the schema of a
+// message.
+func VersionHeaders(name Name, version int) []kafka.Header {
+	if _, exists := schemas[name][version]; !exists {
+		return nil
+	}
+	return []kafka.Header{
+		{Key: NameHeader, Value: []byte(name)},
+		{Key: VersionHeader, Value: []byte(strconv.Itoa(version))},
+	}
+}
+
+// FromHeaders returns the name and version of the schema of a message, found
+// in its headers. Messages without a version use the default one.
+func FromHeaders(headers []kafka.Header) (Name, int, bool) {
+	var name Name
+	var version int
+	var found bool
+	for _, h := range headers {
+		switch h.Key {
+		case NameHeader:
+			name = Name(h.Value)
+			found = true
+		case VersionHeader:
+			version, _ = strconv.Atoi(string(h.Value))
+		}
+	}
+	if !found {
+		return "", 0, false
+	}
+	if version == 0 {
+		if vs, exists := versions[name]; exists {
+			version = vs[0]
+		}
+	}
+	return name, version, true
+}
+
+// Validate checks a message against a version of its schema.
+func Validate(name Name, version int, b []byte) error {
+	s, exists := schemas[name][version]
+	if !exists {
+		return fmt.Errorf("%w: %q version %d", ErrUnknownSchema, name, version)
+	}
+	// Numbers are kept as json.Number so large integers keep their precision.
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	err := d.Decode(&v)
+	if err != nil {
+		return err
+	}
+	return s.Validate(v)
+}
@@ -0,0 +1,126 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/getsentry/vroom/internal/testutil"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  Name
+		version int
+		message string
+		wantErr bool
+	}{
+		{
+			name:   "valid chunk",
+			schema: ProfileChunk,
+			message: `{"project_id":1,"profiler_id":"a","chunk_id":"b","start_timestamp":1.5,` +
+				`"end_timestamp":2.5,"duration_ms":1000,"received":1.5,"retention_days":90,` +
+				`"environment":"prod","platform":"python","release":"1.0","sdk_name":"sentry.python",` +
+				`"sdk_version":"2.0"}`,
+		},
+		{
+			name:    "missing field",
+			schema:  ProfileChunk,
+			message: `{"project_id":1,"profiler_id":"a","chunk_id":"b"}`,
+			wantErr: true,
+		},
+		{
+			name:   "unknown field",
+			schema: ProfileChunk,
+			message: `{"project_id":1,"profiler_id":"a","chunk_id":"b","start_timestamp":1.5,` +
+				`"end_timestamp":2.5,"duration_ms":1000,"received":1.5,"retention_days":90,` +
+				`"environment":"prod","platform":"python","release":"1.0","sdk_name":"sentry.python",` +
+				`"sdk_version":"2.0","unknown":true}`,
+			wantErr: true,
+		},
+		{
+			name:   "wrong type",
+			schema: Functions,
+			message: `{"functions":[],"profile_id":"a","platform":"python","project_id":"1",` +
+				`"received":1,"retention_days":90,"timestamp":1,"transaction_name":"",` +
+				`"materialization_version":1}`,
+			wantErr: true,
+		},
+		{
+			name:    "sketches",
+			schema:  Functions,
+			version: FunctionsSketchesVersion,
+			message: `{"functions":[{"fingerprint":1,"function":"a","package":"b","in_app":true,` +
+				`"durations_sketch":{"relative_accuracy":0.01,"count":1,"sum":10,"min":10,"max":10,"bins":{"1":1}},` +
+				`"thread_id":"1"}],"profile_id":"a","platform":"python","project_id":1,` +
+				`"received":1,"retention_days":90,"timestamp":1,"transaction_name":"",` +
+				`"materialization_version":1}`,
+		},
+		{
+			name:    "durations with sketches",
+			schema:  Functions,
+			version: FunctionsSketchesVersion,
+			message: `{"functions":[{"fingerprint":1,"function":"a","package":"b","in_app":true,` +
+				`"durations_ns":[10],"thread_id":"1"}],"profile_id":"a","platform":"python","project_id":1,` +
+				`"received":1,"retention_days":90,"timestamp":1,"transaction_name":"",` +
+				`"materialization_version":1}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			schema:  Profile,
+			message: `{`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version := test.version
+			if version == 0 {
+				version = 1
+			}
+			err := Validate(test.schema, version, []byte(test.message))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateUnknownSchema(t *testing.T) {
+	err := Validate("unknown", 1, []byte("{}"))
+	if !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema, got: %v", err)
+	}
+	err = Validate(Profile, 2, []byte("{}"))
+	if !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema, got: %v", err)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	headers := Headers(Functions)
+	want := []kafka.Header{
+		{Key: NameHeader, Value: []byte("functions")},
+		{Key: VersionHeader, Value: []byte("1")},
+	}
+	if diff := testutil.Diff(headers, want); diff != "" {
+		t.Fatalf("Result mismatch: got - want +\n%s", diff)
+	}
+	name, version, ok := FromHeaders(headers)
+	if !ok || name != Functions || version != 1 {
+		t.Fatalf("expected %q version 1, got: %q version %d", Functions, name, version)
+	}
+	_, version, _ = FromHeaders(VersionHeaders(Functions, FunctionsSketchesVersion))
+	if version != FunctionsSketchesVersion {
+		t.Fatalf("expected version %d, got: %d", FunctionsSketchesVersion, version)
+	}
+	if Headers("unknown") != nil {
+		t.Fatal("expected no headers for an unknown schema")
+	}
+	if VersionHeaders(Profile, 2) != nil {
+		t.Fatal("expected no headers for an unknown version")
+	}
+}
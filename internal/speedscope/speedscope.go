@@ -10,6 +10,7 @@ import (
 	"github.com/getsentry/vroom/internal/measurements"
 	"github.com/getsentry/vroom/internal/options"
 	"github.com/getsentry/vroom/internal/platform"
+	"github.com/getsentry/vroom/internal/quantile"
 	"github.com/getsentry/vroom/internal/timeutil"
 	"github.com/getsentry/vroom/internal/transaction"
 )
@@ -39,14 +40,14 @@ type (
 	}
 
 	FrameInfo struct {
-		Count       uint32   `json:"count"`
-		Weight      uint64   `json:"weight"`
-		SumDuration uint64   `json:"sumDuration"`
-		SumSelfTime uint64   `json:"sumSelfTime"`
-		DurationsNS []uint64 `json:"-"`
-		P75Duration uint64   `json:"p75Duration"`
-		P95Duration uint64   `json:"p95Duration"`
-		P99Duration uint64   `json:"p99Duration"`
+		Count       uint32           `json:"count"`
+		Weight      uint64           `json:"weight"`
+		SumDuration uint64           `json:"sumDuration"`
+		SumSelfTime uint64           `json:"sumSelfTime"`
+		Durations   *quantile.Sketch `json:"-"`
+		P75Duration uint64           `json:"p75Duration"`
+		P95Duration uint64           `json:"p95Duration"`
+		P99Duration uint64           `json:"p99Duration"`
 	}
 
 	Event struct {
@@ -0,0 +1,222 @@
+package storageutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Priority is the class of a read job. Workers always run queued jobs of a
+// higher priority first.
+type Priority int
+
+const (
+	// PriorityInteractive is for reads of a single profile someone is
+	// waiting on.
+	PriorityInteractive Priority = iota
+	// PriorityBulk is for reads of many profiles, like flamegraphs and
+	// function aggregations.
+	PriorityBulk
+	// PriorityBackground is for reads nobody is waiting on, like the
+	// processing of regressions we detect ourselves.
+	PriorityBackground
+
+	numPriorities = 3
+)
+
+var (
+	readPoolQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vroom",
+		Name:      "read_pool_queued_jobs",
+		Help:      "Read jobs waiting for a worker.",
+	}, []string{"priority"})
+	readPoolRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vroom",
+		Name:      "read_pool_rejected_jobs_total",
+		Help:      "Read jobs not run because their request was done first.",
+	}, []string{"priority"})
+	readPoolBusyWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vroom",
+		Name:      "read_pool_busy_workers",
+		Help:      "Workers running a read job.",
+	})
+
+	readPoolWaitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vroom",
+		Name:      "read_pool_wait_duration_seconds",
+		Help:      "Time read jobs spent queued before a worker ran them.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"priority"})
+	readPoolJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vroom",
+		Name:      "read_pool_job_duration_seconds",
+		Help:      "Time workers spent running read jobs.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"priority"})
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBulk:
+		return "bulk"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	// ReadScheduler runs read jobs on a pool of workers, by priority.
+	ReadScheduler struct {
+		queues [numPriorities]chan scheduledJob
+		wg     sync.WaitGroup
+	}
+
+	// ReadQueue enqueues the read jobs of a request with a priority and a
+	// limit on how many of them are queued or running at once, so a request
+	// reading many profiles doesn't take every worker.
+	ReadQueue struct {
+		scheduler *ReadScheduler
+		priority  Priority
+		slots     chan struct{}
+	}
+
+	scheduledJob struct {
+		job      ReadJob
+		priority Priority
+		release  func()
+		queuedAt time.Time
+	}
+)
+
+// NewReadScheduler starts workers running jobs until the scheduler is
+// closed. Each priority queues up to queueSize jobs.
+func NewReadScheduler(workers, queueSize int) *ReadScheduler {
+	s := &ReadScheduler{}
+	for i := range s.queues {
+		s.queues[i] = make(chan scheduledJob, queueSize)
+	}
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Close stops the workers once every queued job ran. Jobs can't be enqueued
+// after it was called.
+func (s *ReadScheduler) Close() {
+	for _, q := range s.queues {
+		close(q)
+	}
+	s.wg.Wait()
+}
+
+// Queue returns a queue for the jobs of a request. maxInFlight limits how
+// many of them are queued or running at once, 0 means no limit.
+func (s *ReadScheduler) Queue(priority Priority, maxInFlight int) *ReadQueue {
+	q := &ReadQueue{scheduler: s, priority: priority}
+	if maxInFlight > 0 {
+		q.slots = make(chan struct{}, maxInFlight)
+	}
+	return q
+}
+
+// Enqueue waits for the request to have a job slot and for room in the queue
+// of its priority, unless the context is done first, in which case the job
+// isn't run and the context error is returned.
+func (q *ReadQueue) Enqueue(ctx context.Context, job ReadJob) error {
+	release := func() {}
+	if q.slots != nil {
+		select {
+		case q.slots <- struct{}{}:
+			release = func() { <-q.slots }
+		case <-ctx.Done():
+			readPoolRejected.WithLabelValues(q.priority.String()).Inc()
+			return ctx.Err()
+		}
+	}
+	// The job is counted as queued before it's sent so workers never
+	// decrement the count before it was incremented.
+	readPoolQueued.WithLabelValues(q.priority.String()).Inc()
+	select {
+	case q.scheduler.queues[q.priority] <- scheduledJob{job: job, priority: q.priority, release: release, queuedAt: time.Now()}:
+		return nil
+	case <-ctx.Done():
+		readPoolQueued.WithLabelValues(q.priority.String()).Dec()
+		release()
+		readPoolRejected.WithLabelValues(q.priority.String()).Inc()
+		return ctx.Err()
+	}
+}
+
+func (s *ReadScheduler) work() {
+	defer s.wg.Done()
+	queues := s.queues
+	for {
+		j, ok := next(&queues)
+		if !ok {
+			return
+		}
+		priority := j.priority.String()
+		readPoolQueued.WithLabelValues(priority).Dec()
+		readPoolBusyWorkers.Inc()
+		start := time.Now()
+		readPoolWaitDuration.WithLabelValues(priority).Observe(start.Sub(j.queuedAt).Seconds())
+		j.job.Read()
+		readPoolJobDuration.WithLabelValues(priority).Observe(time.Since(start).Seconds())
+		readPoolBusyWorkers.Dec()
+		j.release()
+	}
+}
+
+// next returns the queued job of the highest priority, waiting for one if
+// none are queued. Queues are set to nil once closed and drained, and false
+// is returned once they all are.
+func next(queues *[numPriorities]chan scheduledJob) (scheduledJob, bool) {
+	for {
+		open := false
+		for i, q := range queues {
+			if q == nil {
+				continue
+			}
+			select {
+			case j, ok := <-q:
+				if ok {
+					return j, true
+				}
+				queues[i] = nil
+				continue
+			default:
+			}
+			open = true
+		}
+		if !open {
+			return scheduledJob{}, false
+		}
+
+		var (
+			j  scheduledJob
+			ok bool
+			i  int
+		)
+		select {
+		case j, ok = <-queues[PriorityInteractive]:
+			i = int(PriorityInteractive)
+		case j, ok = <-queues[PriorityBulk]:
+			i = int(PriorityBulk)
+		case j, ok = <-queues[PriorityBackground]:
+			i = int(PriorityBackground)
+		}
+		if ok {
+			return j, true
+		}
+		queues[i] = nil
+	}
+}
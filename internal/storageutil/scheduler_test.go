@@ -0,0 +1,112 @@
+package storageutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type funcJob func()
+
+func (f funcJob) Read() {
+	f()
+}
+
+// blockWorker occupies the only worker of a scheduler until the returned
+// function is called.
+func blockWorker(t *testing.T, s *ReadScheduler) func() {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	err := s.Queue(PriorityInteractive, 0).Enqueue(context.Background(), funcJob(func() {
+		close(started)
+		<-unblock
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return func() { close(unblock) }
+}
+
+func TestReadSchedulerRunsHigherPriorityFirst(t *testing.T) {
+	s := NewReadScheduler(1, 10)
+	unblock := blockWorker(t, s)
+
+	var (
+		mu    sync.Mutex
+		order []Priority
+	)
+	for _, p := range []Priority{PriorityBackground, PriorityBulk, PriorityInteractive} {
+		err := s.Queue(p, 0).Enqueue(context.Background(), funcJob(func() {
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	unblock()
+	s.Close()
+
+	want := []Priority{PriorityInteractive, PriorityBulk, PriorityBackground}
+	if len(order) != len(want) {
+		t.Fatalf("expected %d jobs to run, got: %d", len(want), len(order))
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected jobs to run in order %v, got: %v", want, order)
+		}
+	}
+}
+
+func TestReadQueueLimitsJobsInFlight(t *testing.T) {
+	s := NewReadScheduler(1, 10)
+	defer s.Close()
+	unblock := blockWorker(t, s)
+	defer unblock()
+
+	q := s.Queue(PriorityBulk, 1)
+	err := q.Enqueue(context.Background(), funcJob(func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The request already has a job queued, the next one waits for it to
+	// run until the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = q.Enqueue(ctx, funcJob(func() {
+		t.Error("job enqueued past the request limit ran")
+	}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	// Other requests aren't limited by it.
+	err = s.Queue(PriorityBulk, 1).Enqueue(context.Background(), funcJob(func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadQueueEnqueueFullQueue(t *testing.T) {
+	s := NewReadScheduler(1, 1)
+	defer s.Close()
+	unblock := blockWorker(t, s)
+	defer unblock()
+
+	q := s.Queue(PriorityBulk, 0)
+	err := q.Enqueue(context.Background(), funcJob(func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = q.Enqueue(ctx, funcJob(func() {}))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/pierrec/lz4/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
 )
@@ -16,8 +19,61 @@ import (
 // ErrObjectNotFound indicates an object was not found.
 var ErrObjectNotFound = errors.New("object not found")
 
+var (
+	storageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vroom",
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Time spent writing or reading a compressed object.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"operation", "result"})
+	storageObjectSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vroom",
+		Name:      "storage_object_size_bytes",
+		Help:      "Compressed size of the objects written or read.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"operation"})
+)
+
+// observeStorageOperation records how long an operation took and, if it
+// succeeded, the size of the object. Writing an object that already exists
+// is a duplicate rather than an error.
+func observeStorageOperation(operation string, start time.Time, size int64, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		result = "not_found"
+	case gcerrors.Code(err) == gcerrors.FailedPrecondition:
+		result = "duplicate"
+	case err != nil:
+		result = "error"
+	}
+	storageOperationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+	if err == nil {
+		storageObjectSize.WithLabelValues(operation).Observe(float64(size))
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // CompressedWrite compresses and writes data to Google Cloud Storage.
 func CompressedWrite(ctx context.Context, b *blob.Bucket, objectName string, d interface{}) error {
+	start := time.Now()
+	size, err := compressedWrite(ctx, b, objectName, d)
+	observeStorageOperation("write", start, size, err)
+	return err
+}
+
+func compressedWrite(ctx context.Context, b *blob.Bucket, objectName string, d interface{}) (int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	writerOptions := &blob.WriterOptions{
@@ -34,24 +90,25 @@ func CompressedWrite(ctx context.Context, b *blob.Bucket, objectName string, d i
 	}
 	ow, err := b.NewWriter(ctx, objectName, writerOptions)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	zw := lz4.NewWriter(ow)
+	cw := &countingWriter{w: ow}
+	zw := lz4.NewWriter(cw)
 	_ = zw.Apply(lz4.CompressionLevelOption(lz4.Level9))
 	jw := json.NewEncoder(zw)
 	err = jw.Encode(d)
 	if err != nil {
 		cancel()
 		ow.Close()
-		return err
+		return 0, err
 	}
 	err = zw.Close()
 	if err != nil {
 		cancel()
 		ow.Close()
-		return err
+		return 0, err
 	}
-	return ow.Close()
+	return cw.n, ow.Close()
 }
 
 // UnmarshalCompressed reads compressed JSON data from GCS and unmarshals it.
@@ -61,24 +118,36 @@ func UnmarshalCompressed(
 	objectName string,
 	d interface{},
 ) error {
+	start := time.Now()
+	size, err := unmarshalCompressed(ctx, b, objectName, d)
+	observeStorageOperation("read", start, size, err)
+	return err
+}
+
+func unmarshalCompressed(
+	ctx context.Context,
+	b *blob.Bucket,
+	objectName string,
+	d interface{},
+) (int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	or, err := b.NewReader(ctx, objectName, nil)
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
-			return fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+			return 0, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
 		}
 
-		return err
+		return 0, err
 	}
 	defer or.Close()
 	zr := lz4.NewReader(or)
 	err = json.NewDecoder(zr).Decode(d)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return or.Size(), nil
 }
 
 type (
@@ -90,9 +159,3 @@ type (
 		Error() error
 	}
 )
-
-func ReadWorker(jobs <-chan ReadJob) {
-	for job := range jobs {
-		job.Read()
-	}
-}
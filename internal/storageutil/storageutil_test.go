@@ -16,9 +16,12 @@ import (
 	"github.com/google/uuid"
 	"github.com/phayes/freeport"
 	"github.com/pierrec/lz4/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/gcsblob"
+	"gocloud.dev/gcerrors"
 
 	gojson "github.com/goccy/go-json"
 	jsoniter "github.com/json-iterator/go"
@@ -267,3 +270,31 @@ func BenchmarkJsonIterator(b *testing.B) {
 		}
 	}
 }
+
+func TestCompressedWriteDuplicate(t *testing.T) {
+	ctx := context.Background()
+	objectName := uuid.NewString()
+	profile := Profile{Samples: []int{1}, Frames: []int{1}}
+
+	duplicates := func() uint64 {
+		var m dto.Metric
+		err := storageOperationDuration.WithLabelValues("write", "duplicate").(prometheus.Histogram).Write(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := duplicates()
+
+	err := CompressedWrite(ctx, gcsBlobBucket, objectName, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CompressedWrite(ctx, gcsBlobBucket, objectName, profile)
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Fatalf("expecting a failed precondition error, instead got %v", err)
+	}
+	if got := duplicates() - before; got != 1 {
+		t.Fatalf("expecting 1 duplicate write, got %d", got)
+	}
+}